@@ -0,0 +1,117 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// uploadChunkSize is the size of the chunks the file is sent in
+const uploadChunkSize = 32 << 10
+
+// attachCmd represents the attach command
+var attachCmd = &cobra.Command{
+	Use:   "attach FILE",
+	Short: "Attach a file to a blog",
+	Long: `Upload FILE as an attachment of a blog, e.g. an image used in its content.
+The file is streamed to the server in chunks together with its size and SHA-256,
+which the server verifies. Content the server has already is not stored twice.
+The content type is detected by the server unless given with --type.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blogID, err := cmd.Flags().GetString("blog")
+		contentType, err := cmd.Flags().GetString("type")
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		// Read the file once for its size and hash, the server needs both up front
+		hash := sha256.New()
+		size, err := io.Copy(hash, f)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		ctx, cancel := transferContext()
+		defer cancel()
+		stream, err := attachmentClient.UploadAttachment(ctx)
+		if err != nil {
+			return err
+		}
+		// The first message carries the attachment, every message a chunk of the file
+		req := &blogpb.UploadAttachmentReq{
+			Attachment: &blogpb.Attachment{
+				BlogId:      blogID,
+				Filename:    filepath.Base(args[0]),
+				ContentType: contentType,
+				Size:        size,
+				Sha256:      hex.EncodeToString(hash.Sum(nil)),
+			},
+		}
+		for {
+			buf := make([]byte, uploadChunkSize)
+			n, err := io.ReadFull(f, buf)
+			if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+				return err
+			}
+			if n > 0 || req.Attachment != nil {
+				req.Chunk = buf[:n]
+				// On a send error the server has ended the upload, CloseAndRecv returns why
+				if stream.Send(req) != nil {
+					break
+				}
+				req = &blogpb.UploadAttachmentReq{}
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		res, err := stream.CloseAndRecv()
+		if err != nil {
+			return err
+		}
+		attachment := res.GetAttachment()
+		fmt.Printf("Attached %s (%s, %d bytes) with id %s\n", attachment.GetFilename(), attachment.GetContentType(), attachment.GetSize(), attachment.GetId())
+		if res.GetDeduplicated() {
+			fmt.Println("The content was on the server already and is stored once")
+		}
+		return nil
+	},
+}
+
+func init() {
+	attachCmd.Flags().StringP("blog", "b", "", "The id of the blog")
+	attachCmd.Flags().StringP("type", "t", "", "Content type of the file, detected by the server if empty")
+	attachCmd.MarkFlagRequired("blog")
+	rootCmd.AddCommand(attachCmd)
+}
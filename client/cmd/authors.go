@@ -0,0 +1,225 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// authorsCmd represents the authors command, the author commands are its subcommands
+var authorsCmd = &cobra.Command{
+	Use:   "authors",
+	Short: "Manage the authors blog posts are written by",
+	Long: `Manage the authors blog posts are written by. A blog can only be created with
+the id of an existing author as --author.`,
+}
+
+// authorsCreateCmd represents the authors create command
+var authorsCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new author",
+	Long: `Create a new author. The id is the handle used as --author of blogs, lower case
+letters, digits and dashes only, and cannot be changed later.
+			Example:
+			blogclient authors create --id snow-dev --name "Snow Dev" --email snow@example.com`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		name, err := cmd.Flags().GetString("name")
+		bio, err := cmd.Flags().GetString("bio")
+		email, err := cmd.Flags().GetString("email")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := authorClient.CreateAuthor(ctx, &blogpb.CreateAuthorReq{
+			Author: &blogpb.Author{
+				Id:          id,
+				DisplayName: name,
+				Bio:         bio,
+				Email:       email,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		printAuthor(res.GetAuthor())
+		return nil
+	},
+}
+
+// authorsReadCmd represents the authors read command
+var authorsReadCmd = &cobra.Command{
+	Use:   "read",
+	Short: "Show an author",
+	Long:  `Show an author by id.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := authorClient.ReadAuthor(ctx, &blogpb.ReadAuthorReq{Id: id})
+		if err != nil {
+			return err
+		}
+		printAuthor(res.GetAuthor())
+		return nil
+	},
+}
+
+// authorsUpdateCmd represents the authors update command
+var authorsUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update an author",
+	Long:  `Update an author, only the fields given with --name, --bio and --email are changed.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		name, err := cmd.Flags().GetString("name")
+		bio, err := cmd.Flags().GetString("bio")
+		email, err := cmd.Flags().GetString("email")
+		if err != nil {
+			return err
+		}
+
+		// Only send the fields the user actually set, the others keep their current value.
+		mask := &fieldmaskpb.FieldMask{}
+		for _, f := range []struct{ flag, field string }{{"name", "display_name"}, {"bio", "bio"}, {"email", "email"}} {
+			if cmd.Flags().Changed(f.flag) {
+				mask.Paths = append(mask.Paths, f.field)
+			}
+		}
+		if len(mask.Paths) == 0 {
+			return fmt.Errorf("nothing to update, set at least one of --name, --bio or --email")
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := authorClient.UpdateAuthor(ctx, &blogpb.UpdateAuthorReq{
+			Author: &blogpb.Author{
+				Id:          id,
+				DisplayName: name,
+				Bio:         bio,
+				Email:       email,
+			},
+			UpdateMask: mask,
+		})
+		if err != nil {
+			return err
+		}
+		printAuthor(res.GetAuthor())
+		return nil
+	},
+}
+
+// authorsListCmd represents the authors list command
+var authorsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all authors",
+	Long:  `List all authors by id, fetching page after page.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pageSize, err := cmd.Flags().GetInt32("page-size")
+		if err != nil {
+			return err
+		}
+
+		req := &blogpb.ListAuthorsReq{PageSize: pageSize}
+		for {
+			next, err := listAuthorsPage(req)
+			if err != nil {
+				return err
+			}
+			if next == "" {
+				return nil
+			}
+			req.PageToken = next
+		}
+	},
+}
+
+// listAuthorsPage prints a single page of authors and returns the token of the next page, if any.
+func listAuthorsPage(req *blogpb.ListAuthorsReq) (string, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	stream, err := authorClient.ListAuthors(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	next := ""
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return next, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if res.GetNextPageToken() != "" {
+			next = res.GetNextPageToken()
+			continue
+		}
+		author := res.GetAuthor()
+		fmt.Printf("%-32s  %s\n", author.GetId(), author.GetDisplayName())
+	}
+}
+
+// printAuthor prints an author message in a human readable form.
+func printAuthor(author *blogpb.Author) {
+	fmt.Printf("ID:       %s\n", author.GetId())
+	fmt.Printf("Name:     %s\n", author.GetDisplayName())
+	fmt.Printf("Email:    %s\n", author.GetEmail())
+	fmt.Printf("Created:  %s\n", formatTime(author.GetCreateTime()))
+	fmt.Printf("Updated:  %s\n", formatTime(author.GetUpdateTime()))
+	fmt.Printf("Bio:\n%s\n", author.GetBio())
+}
+
+func init() {
+	authorsCreateCmd.Flags().StringP("id", "i", "", "The id of the author, e.g. snow-dev")
+	authorsCreateCmd.Flags().StringP("name", "n", "", "The name shown for the author")
+	authorsCreateCmd.Flags().String("bio", "", "A few words about the author")
+	authorsCreateCmd.Flags().String("email", "", "The email address of the author")
+	authorsCreateCmd.MarkFlagRequired("id")
+	authorsCreateCmd.MarkFlagRequired("name")
+	authorsCmd.AddCommand(authorsCreateCmd)
+
+	authorsReadCmd.Flags().StringP("id", "i", "", "The id of the author")
+	authorsReadCmd.MarkFlagRequired("id")
+	authorsCmd.AddCommand(authorsReadCmd)
+
+	authorsUpdateCmd.Flags().StringP("id", "i", "", "The id of the author")
+	authorsUpdateCmd.Flags().StringP("name", "n", "", "The name shown for the author")
+	authorsUpdateCmd.Flags().String("bio", "", "A few words about the author")
+	authorsUpdateCmd.Flags().String("email", "", "The email address of the author")
+	authorsUpdateCmd.MarkFlagRequired("id")
+	authorsCmd.AddCommand(authorsUpdateCmd)
+
+	authorsListCmd.Flags().Int32("page-size", 0, "Number of authors fetched per request (0 uses the server default)")
+	authorsCmd.AddCommand(authorsListCmd)
+
+	rootCmd.AddCommand(authorsCmd)
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// commentsCmd represents the comments command, the comment commands are its subcommands
+var commentsCmd = &cobra.Command{
+	Use:   "comments",
+	Short: "Read and write comments on blog posts",
+	Long:  `Read and write comments on blog posts, replies to a comment are shown indented below it.`,
+}
+
+// commentsListCmd represents the comments list command
+var commentsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show the comments of a blog post",
+	Long: `Show all comments of a blog post as threads, or only the replies to one comment with --parent.
+			Example:
+			blogclient comments list --blog 5d...`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blogID, err := cmd.Flags().GetString("blog")
+		parentID, err := cmd.Flags().GetString("parent")
+		pageSize, err := cmd.Flags().GetInt32("page-size")
+		if err != nil {
+			return err
+		}
+
+		// A thread can only be shown once all of it is there, so fetch every page.
+		req := &blogpb.ListCommentsReq{BlogId: blogID, ParentId: parentID, PageSize: pageSize}
+		var comments []*blogpb.Comment
+		for {
+			page, next, err := listCommentsPage(req)
+			if err != nil {
+				return err
+			}
+			comments = append(comments, page...)
+			if next == "" {
+				break
+			}
+			req.PageToken = next
+		}
+
+		if len(comments) == 0 {
+			fmt.Println("No comments")
+			return nil
+		}
+		printThreads(comments, parentID)
+		return nil
+	},
+}
+
+// listCommentsPage returns a single page of comments and the token of the next page, if any.
+func listCommentsPage(req *blogpb.ListCommentsReq) ([]*blogpb.Comment, string, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	stream, err := commentClient.ListComments(ctx, req)
+	if err != nil {
+		return nil, "", err
+	}
+	var comments []*blogpb.Comment
+	next := ""
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return comments, next, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if res.GetNextPageToken() != "" {
+			next = res.GetNextPageToken()
+			continue
+		}
+		comments = append(comments, res.GetComment())
+	}
+}
+
+// printThreads prints the comments below root ("" for the blog itself) with the replies
+// indented below the comment they reply to. Comments come in the order they were written,
+// so do the replies of each comment.
+func printThreads(comments []*blogpb.Comment, root string) {
+	replies := make(map[string][]*blogpb.Comment)
+	for _, c := range comments {
+		replies[c.GetParentId()] = append(replies[c.GetParentId()], c)
+	}
+	var printReplies func(parent string, depth int)
+	printReplies = func(parent string, depth int) {
+		for _, c := range replies[parent] {
+			printComment(c, strings.Repeat("    ", depth))
+			printReplies(c.GetId(), depth+1)
+		}
+	}
+	printReplies(root, 0)
+}
+
+// printComment prints a comment, every line starting with indent.
+func printComment(c *blogpb.Comment, indent string) {
+	if c.GetDeleted() {
+		fmt.Printf("%s[deleted]  (%s)\n\n", indent, c.GetId())
+		return
+	}
+	edited := ""
+	if !c.GetUpdateTime().AsTime().Equal(c.GetCreateTime().AsTime()) {
+		edited = ", edited"
+	}
+	fmt.Printf("%s%s on %s%s  (%s)\n", indent, c.GetAuthorId(), formatTime(c.GetCreateTime()), edited, c.GetId())
+	for _, line := range strings.Split(c.GetContent(), "\n") {
+		fmt.Printf("%s  %s\n", indent, line)
+	}
+	fmt.Println()
+}
+
+// commentsAddCmd represents the comments add command
+var commentsAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Comment on a blog post or reply to a comment",
+	Long: `Comment on a published blog post, or reply to one of its comments with --parent.
+			Example:
+			blogclient comments add --blog 5d... --content "Nice post!"
+			blogclient comments add --blog 5d... --parent 5e... --content "Thanks!"`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blogID, err := cmd.Flags().GetString("blog")
+		parentID, err := cmd.Flags().GetString("parent")
+		author, err := cmd.Flags().GetString("author")
+		content, err := cmd.Flags().GetString("content")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := commentClient.CreateComment(ctx, &blogpb.CreateCommentReq{
+			Comment: &blogpb.Comment{
+				BlogId:   blogID,
+				ParentId: parentID,
+				AuthorId: author,
+				Content:  content,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Comment added: %s\n", res.GetComment().GetId())
+		return nil
+	},
+}
+
+// commentsEditCmd represents the comments edit command
+var commentsEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Change the content of a comment",
+	Long:  `Replace the content of a comment, deleted comments can't be edited.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		content, err := cmd.Flags().GetString("content")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := commentClient.UpdateComment(ctx, &blogpb.UpdateCommentReq{Id: id, Content: content})
+		if err != nil {
+			return err
+		}
+		printComment(res.GetComment(), "")
+		return nil
+	},
+}
+
+// commentsDeleteCmd represents the comments delete command
+var commentsDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete a comment",
+	Long:  `Delete a comment. If it has replies it stays in the thread as [deleted] so the replies keep their place.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		if _, err := commentClient.DeleteComment(ctx, &blogpb.DeleteCommentReq{Id: id}); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted the comment with ID: %s\n", id)
+		return nil
+	},
+}
+
+func init() {
+	commentsListCmd.Flags().StringP("blog", "b", "", "The id of the blog")
+	commentsListCmd.Flags().StringP("parent", "p", "", "Only show the replies to this comment")
+	commentsListCmd.Flags().Int32("page-size", 0, "Number of comments fetched per request (0 uses the server default)")
+	commentsListCmd.MarkFlagRequired("blog")
+	commentsCmd.AddCommand(commentsListCmd)
+
+	commentsAddCmd.Flags().StringP("blog", "b", "", "The id of the blog")
+	commentsAddCmd.Flags().StringP("parent", "p", "", "The id of the comment to reply to")
+	commentsAddCmd.Flags().StringP("author", "a", os.Getenv("USER"), "Add an author")
+	commentsAddCmd.Flags().StringP("content", "c", "", "The comment")
+	commentsAddCmd.MarkFlagRequired("blog")
+	commentsAddCmd.MarkFlagRequired("content")
+	commentsCmd.AddCommand(commentsAddCmd)
+
+	commentsEditCmd.Flags().StringP("id", "i", "", "The id of the comment")
+	commentsEditCmd.Flags().StringP("content", "c", "", "The new content")
+	commentsEditCmd.MarkFlagRequired("id")
+	commentsEditCmd.MarkFlagRequired("content")
+	commentsCmd.AddCommand(commentsEditCmd)
+
+	commentsDeleteCmd.Flags().StringP("id", "i", "", "The id of the comment")
+	commentsDeleteCmd.MarkFlagRequired("id")
+	commentsCmd.AddCommand(commentsDeleteCmd)
+
+	rootCmd.AddCommand(commentsCmd)
+}
@@ -16,13 +16,28 @@ limitations under the License.
 package cmd
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// createAttempts is how often create tries to reach the server.
+const createAttempts = 3
+
+// newIdempotencyKey returns a random key for a CreateBlog request.
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	rand.Read(key)
+	return hex.EncodeToString(key)
+}
+
 // createCmd represents the create command
 var createCmd = &cobra.Command{
 	Use:   "create",
@@ -38,7 +53,17 @@ var createCmd = &cobra.Command{
 		author, err := cmd.Flags().GetString("author")
 		title, err := cmd.Flags().GetString("title")
 		content, err := cmd.Flags().GetString("content")
+		tags, err := cmd.Flags().GetStringSlice("tag")
+		slug, err := cmd.Flags().GetString("slug")
+		publish, err := cmd.Flags().GetBool("publish")
+		publishAt, err := cmd.Flags().GetString("publish-at")
+		key, err := cmd.Flags().GetString("idempotency-key")
+		format, err := cmd.Flags().GetString("format")
 
+		if err != nil {
+			return err
+		}
+		contentFormat, err := parseFormat(format)
 		if err != nil {
 			return err
 		}
@@ -48,29 +73,70 @@ var createCmd = &cobra.Command{
 			AuthorId: author,
 			Title:    title,
 			Content:  content,
+			Tags:     tags,
+			Slug:     slug,
+			// How the content is written, see 'blogclient render'
+			ContentFormat: contentFormat,
 		}
 
-		// RPC call
-		res, err := client.CreateBlog(
-			context.TODO(),
-			// wrap the blog message in a CreateBlog request message
-			&blogpb.CreateBlogReq{
-				Blog: blog,
-			},
-		)
+		// Blogs are drafts until published
+		if publish || publishAt != "" {
+			blog.Status = blogpb.BlogStatus_PUBLISHED
+		}
+		if publishAt != "" {
+			t, err := parsePublishTime(publishAt)
+			if err != nil {
+				return fmt.Errorf("--publish-at: %v", err)
+			}
+			blog.PublishTime = timestamppb.New(t)
+		}
+
+		// Every create gets an idempotency key, so retrying can't create the blog twice
+		if key == "" {
+			key = newIdempotencyKey()
+		}
+
+		// RPC call, retried a few times while the server can't be reached
+		var res *blogpb.CreateBlogRes
+		for attempt := 1; ; attempt++ {
+			// Every attempt gets the whole --timeout
+			ctx, cancel := requestContext()
+			res, err = client.CreateBlog(
+				ctx,
+				// wrap the blog message in a CreateBlog request message
+				&blogpb.CreateBlogReq{
+					Blog:           blog,
+					IdempotencyKey: key,
+				},
+			)
+			cancel()
+			if status.Code(err) != codes.Unavailable || attempt == createAttempts {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if status.Code(err) == codes.Unavailable {
+			return fmt.Errorf("%v\nThe blog may have been created, run create again with --idempotency-key %s to make sure it isn't created twice", err, key)
+		}
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("Blog created: %s\n", res.Blog.Id)
+		fmt.Printf("Blog created: %s (slug %s, %s)\n", res.Blog.Id, res.Blog.Slug, statusName(res.Blog.Status))
 		return nil
 	},
 }
 
 func init() {
-	createCmd.Flags().StringP("author", "a", "", "Add an author")
+	createCmd.Flags().StringP("author", "a", "", "The id of the author, see 'blogclient authors'")
 	createCmd.Flags().StringP("title", "t", "", "A title for the blog")
 	createCmd.Flags().StringP("content", "c", "", "The content for the blog")
+	createCmd.Flags().StringSlice("tag", nil, "Tag the blog, repeat the flag or separate tags with commas")
+	createCmd.Flags().String("slug", "", "A slug for the blog, made from the title if not set")
+	createCmd.Flags().Bool("publish", false, "Publish the blog right away instead of creating a draft")
+	createCmd.Flags().String("publish-at", "", "Schedule the blog for a date, time or duration from now, see publish --at")
+	createCmd.Flags().String("format", "plain", "How the content is written: plain or markdown")
+	createCmd.Flags().String("idempotency-key", "", "Reuse the key of an earlier create that may have failed, random if not set")
 	createCmd.MarkFlagRequired("author")
 	createCmd.MarkFlagRequired("title")
 	createCmd.MarkFlagRequired("content")
@@ -16,7 +16,6 @@ limitations under the License.
 package cmd
 
 import (
-	"context"
 	"fmt"
 	blogpb "github.com/snow-dev/simple-api/proto"
 
@@ -27,7 +26,8 @@ import (
 var deleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delte a blog post by its ID",
-	Long:  `Delete a blog post by its mongoDB Unique identifier. If no blog post is found for de ID, it will return a 'Not found' error`,
+	Long: `Delete a blog post by its mongoDB Unique identifier. If no blog post is found for de ID, it will return a 'Not found' error.
+The blog post is moved to the trash, see 'blogclient trash' and 'blogclient restore'.`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		id, err := cmd.Flags().GetString("id")
@@ -38,14 +38,16 @@ var deleteCmd = &cobra.Command{
 		req := &blogpb.DeleteBlogReq{
 			Id: id,
 		}
+		ctx, cancel := requestContext()
+		defer cancel()
 		// We only return true upon success for others cases an error is thrown
 		// We can thus omit the response variable for now and just print something
-		_, err = client.DeleteBlog(context.Background(), req)
+		_, err = client.DeleteBlog(ctx, req)
 		if err != nil {
 			return err
 		}
 
-		fmt.Printf("Successfully deleted the blog with ID: %s\n", id)
+		fmt.Printf("Moved the blog with ID %s to the trash, undo with 'blogclient restore --id %s'\n", id, id)
 		return nil
 	},
 }
@@ -0,0 +1,137 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffLine is a line of a diff: ' ' in both texts, '-' only in the old one, '+' only in the new one.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// diffLines finds the shortest edit from a to b through their longest common subsequence.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		// Removed lines come before the added ones, like in diff -u.
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// unifiedDiff returns the differences between a and b in unified format with the given
+// number of context lines, or "" if there are none.
+func unifiedDiff(fromName, toName string, a, b []string, context int) string {
+	lines := diffLines(a, b)
+
+	// Lines within context of a change are part of a hunk.
+	inHunk := make([]bool, len(lines))
+	changed := false
+	for k, line := range lines {
+		if line.op == ' ' {
+			continue
+		}
+		changed = true
+		for c := k - context; c <= k+context; c++ {
+			if c >= 0 && c < len(lines) {
+				inHunk[c] = true
+			}
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	// aLine and bLine count the lines of a and b before lines[k].
+	aLine, bLine := 0, 0
+	for k := 0; k < len(lines); {
+		if !inHunk[k] {
+			aLine++
+			bLine++
+			k++
+			continue
+		}
+		end := k
+		aCount, bCount := 0, 0
+		for ; end < len(lines) && inHunk[end]; end++ {
+			if lines[end].op != '+' {
+				aCount++
+			}
+			if lines[end].op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine, aCount), hunkRange(bLine, bCount))
+		for _, line := range lines[k:end] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		aLine += aCount
+		bLine += bCount
+		k = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the lines of one side of a hunk, start is the number of lines before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		// An empty range names the line after which it is.
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
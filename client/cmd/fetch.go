@@ -0,0 +1,135 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// fetchCmd represents the fetch command
+var fetchCmd = &cobra.Command{
+	Use:   "fetch",
+	Short: "Download an attachment, or list the attachments of a blog",
+	Long: `Download the attachment with --id into the current directory under its file name,
+or into the file given with --output, "-" writes it to the standard output.
+Existing files are not overwritten. The download is checked against the SHA-256 of the attachment.
+With --blog instead of --id the attachments of the blog are listed.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		id, err := cmd.Flags().GetString("id")
+		blogID, err := cmd.Flags().GetString("blog")
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+		if (id == "") == (blogID == "") {
+			return errors.New("either --id or --blog is required")
+		}
+		if blogID != "" {
+			return listAttachments(blogID)
+		}
+
+		ctx, cancel := transferContext()
+		defer cancel()
+		stream, err := attachmentClient.DownloadAttachment(ctx, &blogpb.DownloadAttachmentReq{Id: id})
+		if err != nil {
+			return err
+		}
+		// The first message carries the attachment
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		attachment := res.GetAttachment()
+
+		var out io.Writer = os.Stdout
+		if output != "-" {
+			if output == "" {
+				output = filepath.Base(attachment.GetFilename())
+			}
+			// O_EXCL fails if the file exists already
+			f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return err
+			}
+			// Don't leave a partial or corrupt file behind
+			defer func() {
+				if err != nil {
+					os.Remove(output)
+				}
+			}()
+			defer f.Close()
+			out = f
+		}
+
+		hash := sha256.New()
+		w := io.MultiWriter(out, hash)
+		size := int64(0)
+		for {
+			n, err := w.Write(res.GetChunk())
+			size += int64(n)
+			if err != nil {
+				return err
+			}
+			res, err = stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if got := hex.EncodeToString(hash.Sum(nil)); size != attachment.GetSize() || got != attachment.GetSha256() {
+			return fmt.Errorf("the download of %d bytes with SHA-256 %s does not match the attachment", size, got)
+		}
+		if output != "-" {
+			fmt.Printf("Saved %s (%s, %d bytes) to %s\n", attachment.GetFilename(), attachment.GetContentType(), size, output)
+		}
+		return nil
+	},
+}
+
+// listAttachments prints the attachments of a blog
+func listAttachments(blogID string) error {
+	ctx, cancel := requestContext()
+	defer cancel()
+	res, err := attachmentClient.ListAttachments(ctx, &blogpb.ListAttachmentsReq{BlogId: blogID})
+	if err != nil {
+		return err
+	}
+	if len(res.GetAttachments()) == 0 {
+		fmt.Println("No attachments")
+	}
+	for _, attachment := range res.GetAttachments() {
+		fmt.Printf("%s  %-30s  %-24s  %d bytes\n", attachment.GetId(), attachment.GetFilename(), attachment.GetContentType(), attachment.GetSize())
+	}
+	return nil
+}
+
+func init() {
+	fetchCmd.Flags().StringP("id", "i", "", "The id of the attachment")
+	fetchCmd.Flags().StringP("blog", "b", "", "List the attachments of this blog instead")
+	fetchCmd.Flags().StringP("output", "o", "", "File to save the attachment to, - for the standard output")
+	rootCmd.AddCommand(fetchCmd)
+}
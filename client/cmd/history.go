@@ -0,0 +1,169 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the revisions of a blog post",
+	Long: `List the revisions of a blog post, newest first, or compare two of them with --diff.
+--diff takes two revision numbers, or one to compare with the current version.
+			Example:
+			blogclient history --id 5d...
+			blogclient history --id 5d... --diff 2:5
+			blogclient history --id 5d... --diff 3`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		diff, err := cmd.Flags().GetString("diff")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		current, err := client.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: id})
+		if err != nil {
+			return err
+		}
+		if diff != "" {
+			return printDiff(current.GetBlog(), diff)
+		}
+
+		ctx, cancel = requestContext()
+		defer cancel()
+		res, err := client.ListRevisions(ctx, &blogpb.ListRevisionsReq{BlogId: id})
+		if err != nil {
+			return err
+		}
+		blog := current.GetBlog()
+		fmt.Printf("%5d  %-29s  %-16s  %s (current)\n", blog.GetVersion(), formatTime(blog.GetUpdateTime()), blog.GetEditor(), blog.GetTitle())
+		for _, rev := range res.GetRevisions() {
+			fmt.Printf("%5d  %-29s  %s\n", rev.GetNumber(), formatTime(rev.GetTime()), rev.GetEditor())
+		}
+		return nil
+	},
+}
+
+// printDiff prints the unified diff between the revisions given as "from:to" or "from".
+func printDiff(current *blogpb.Blog, revisions string) error {
+	from, to := revisions, strconv.FormatInt(current.GetVersion(), 10)
+	if i := strings.Index(revisions, ":"); i >= 0 {
+		from, to = revisions[:i], revisions[i+1:]
+	}
+	a, err := revisionBlog(current, from)
+	if err != nil {
+		return err
+	}
+	b, err := revisionBlog(current, to)
+	if err != nil {
+		return err
+	}
+
+	d := unifiedDiff("revision "+from, "revision "+to, blogLines(a), blogLines(b), 3)
+	if d == "" {
+		fmt.Println("No differences")
+		return nil
+	}
+	fmt.Print(d)
+	return nil
+}
+
+// revisionBlog fetches the blog at a revision number, the current version is the blog itself.
+func revisionBlog(current *blogpb.Blog, number string) (*blogpb.Blog, error) {
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("--diff: %q is not a revision number", number)
+	}
+	if n == current.GetVersion() {
+		return current, nil
+	}
+	ctx, cancel := requestContext()
+	defer cancel()
+	res, err := client.GetRevision(ctx, &blogpb.GetRevisionReq{BlogId: current.GetId(), Number: n})
+	if err != nil {
+		return nil, err
+	}
+	return res.GetRevision().GetBlog(), nil
+}
+
+// blogLines is the text of a blog that revisions are compared on.
+func blogLines(blog *blogpb.Blog) []string {
+	lines := []string{
+		"Title:  " + blog.GetTitle(),
+		"Author: " + blog.GetAuthorId(),
+		"Slug:   " + blog.GetSlug(),
+		"Tags:   " + strings.Join(blog.GetTags(), ", "),
+		"",
+	}
+	return append(lines, strings.Split(blog.GetContent(), "\n")...)
+}
+
+// revertCmd represents the revert command
+var revertCmd = &cobra.Command{
+	Use:   "revert",
+	Short: "Revert a blog post to an earlier revision",
+	Long:  `Set the author, title, content, tags and slug of a blog post back to those of a revision, see 'blogclient history'.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		revision, err := cmd.Flags().GetInt64("revision")
+		version, err := cmd.Flags().GetInt64("version")
+		editor, err := cmd.Flags().GetString("editor")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.RevertBlog(ctx, &blogpb.RevertBlogReq{
+			BlogId:          id,
+			Number:          revision,
+			ExpectedVersion: version,
+			Editor:          editor,
+		})
+		if err != nil {
+			return err
+		}
+		printBlog(res.GetBlog())
+		return nil
+	},
+}
+
+func init() {
+	historyCmd.Flags().StringP("id", "i", "", "The id of the blog")
+	historyCmd.Flags().String("diff", "", `Compare two revisions "from:to", or one with the current version`)
+	historyCmd.MarkFlagRequired("id")
+	rootCmd.AddCommand(historyCmd)
+
+	revertCmd.Flags().StringP("id", "i", "", "The id of the blog")
+	revertCmd.Flags().Int64P("revision", "r", 0, "The revision to revert to")
+	revertCmd.Flags().Int64("version", 0, "The version of the blog the revert is based on, fails if it changed since (0 skips the check)")
+	revertCmd.Flags().String("editor", os.Getenv("USER"), "Who reverts the blog, recorded in its history")
+	revertCmd.MarkFlagRequired("id")
+	revertCmd.MarkFlagRequired("revision")
+	rootCmd.AddCommand(revertCmd)
+}
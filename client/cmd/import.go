@@ -0,0 +1,112 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Create many blogs at once from a JSON Lines file",
+	Long: `Create a blog for every line of FILE, or of the standard input if FILE is "-".
+Every line is a blog as JSON, with the fields of create, e.g.
+
+	{"author_id": "snow-dev", "title": "Hello", "content": "First post", "tags": ["go"], "status": "PUBLISHED"}
+
+The blogs are sent to the server in one stream. Lines that fail are reported
+with the reason and skipped, the others are imported anyway.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		author, err := cmd.Flags().GetString("author")
+		if err != nil {
+			return err
+		}
+
+		in := os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		ctx, cancel := transferContext()
+		defer cancel()
+		stream, err := client.BatchCreateBlogs(ctx)
+		if err != nil {
+			return err
+		}
+		// lines holds the line number of every blog sent, the results only know their index in the stream.
+		var lines []int
+		skipped := 0
+		reader := bufio.NewReader(in)
+		for line := 1; ; line++ {
+			text, err := reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if len(bytes.TrimSpace(text)) > 0 {
+				blog := &blogpb.Blog{}
+				if perr := protojson.Unmarshal(text, blog); perr != nil {
+					fmt.Printf("line %d: %v\n", line, perr)
+					skipped++
+				} else {
+					if blog.AuthorId == "" {
+						blog.AuthorId = author
+					}
+					if serr := stream.Send(&blogpb.BatchCreateBlogsReq{Blog: blog}); serr != nil {
+						// The server ended the stream, CloseAndRecv below tells why.
+						break
+					}
+					lines = append(lines, line)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+
+		res, err := stream.CloseAndRecv()
+		if err != nil {
+			return err
+		}
+		for _, result := range res.Results {
+			if result.Blog == nil {
+				fmt.Printf("line %d: %s: %s\n", lines[result.Index], codes.Code(result.Code), result.Message)
+			}
+		}
+		fmt.Printf("Imported %d blogs, %d failed\n", res.Created, int(res.Failed)+skipped)
+		return nil
+	},
+}
+
+func init() {
+	importCmd.Flags().StringP("author", "a", "", "The id of the author of the blogs without an author_id")
+	rootCmd.AddCommand(importCmd)
+}
@@ -16,49 +16,152 @@ limitations under the License.
 package cmd
 
 import (
-	"context"
 	"fmt"
 	blogpb "github.com/snow-dev/simple-api/proto"
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
+	"strings"
+	"time"
 )
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
 	Use:   "list",
-	Short: "List all blog posts",
-	Long:  `List all blog posts on streaming.`,
+	Short: "List blog posts",
+	Long:  `List blog posts on streaming, one page at a time or all of them with --all.`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Create the request
-		req := &blogpb.ListBlogsReq{}
-		// Call ListBlogs that returns a stream
-		stream, err := client.ListBlogs(context.Background(), req)
-		// Check for errors.
+		pageSize, err := cmd.Flags().GetInt32("page-size")
+		pageToken, err := cmd.Flags().GetString("page-token")
+		all, err := cmd.Flags().GetBool("all")
 		if err != nil {
 			return err
 		}
-		// Start iterating
-		for {
-			// stream.Recv returns a pointer to a ListBlogReq at the current iteration
-			res, err := stream.Recv()
-			// If end of stream, break the loop
-			if err == io.EOF {
-				break
+
+		// Filters and order, they stay the same for every page
+		req := &blogpb.ListBlogsReq{PageSize: pageSize}
+		req.AuthorId, err = cmd.Flags().GetString("author")
+		req.TitleContains, err = cmd.Flags().GetString("title")
+		req.OrderBy, err = cmd.Flags().GetString("sort")
+		req.Tags, err = cmd.Flags().GetStringSlice("tag")
+		statuses, err := cmd.Flags().GetStringSlice("status")
+		since, err := cmd.Flags().GetString("since")
+		until, err := cmd.Flags().GetString("until")
+		if err != nil {
+			return err
+		}
+		for _, name := range statuses {
+			st, ok := blogpb.BlogStatus_value[strings.ToUpper(name)]
+			if !ok || st == 0 {
+				return fmt.Errorf("--status: unknown status %q, want draft, scheduled, published or archived", name)
+			}
+			req.Statuses = append(req.Statuses, blogpb.BlogStatus(st))
+		}
+		if since != "" {
+			t, err := parseTime(since)
+			if err != nil {
+				return fmt.Errorf("--since: %v", err)
+			}
+			req.CreatedAfter = timestamppb.New(t)
+		}
+		if until != "" {
+			t, err := parseTime(until)
+			if err != nil {
+				return fmt.Errorf("--until: %v", err)
 			}
-			// if err, return an error
+			req.CreatedBefore = timestamppb.New(t)
+		}
+
+		// Without --all only one page is printed, with --all we keep following next_page_token.
+		for {
+			req.PageToken = pageToken
+			next, err := listPage(req)
 			if err != nil {
 				return err
 			}
-			// return empty if there are no blogs
-			// If everything went well use the generated getter to print the blog message
-			fmt.Printf("Response %v", res)
-			fmt.Println(res.GetBlog())
+			if next == "" {
+				return nil
+			}
+			if !all {
+				fmt.Printf("More blogs available, run again with --page-token %s or use --all\n", next)
+				return nil
+			}
+			pageToken = next
 		}
-		return nil
 	},
 }
 
+// parseTime accepts a date (2006-01-02), an RFC 3339 time or a duration like 72h meaning that long ago.
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date, RFC 3339 time or duration", s)
+	}
+	return t, nil
+}
+
+// listPage prints a single page of blogs and returns the token of the next page, if any.
+func listPage(req *blogpb.ListBlogsReq) (string, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	// Call ListBlogs that returns a stream
+	stream, err := client.ListBlogs(ctx, req)
+	// Check for errors.
+	if err != nil {
+		return "", err
+	}
+	return printPage(stream)
+}
+
+// pageStream is the client stream of ListBlogs and ListDeletedBlogs.
+type pageStream interface {
+	Recv() (*blogpb.ListBlogsRes, error)
+}
+
+// printPage prints the blogs of a page as they are streamed and returns the token of the next page, if any.
+func printPage(stream pageStream) (string, error) {
+	next := ""
+	// Start iterating
+	for {
+		// stream.Recv returns a pointer to a ListBlogReq at the current iteration
+		res, err := stream.Recv()
+		// If end of stream, break the loop
+		if err == io.EOF {
+			break
+		}
+		// if err, return an error
+		if err != nil {
+			return "", err
+		}
+		// The last message of a page only carries the next page token
+		if res.GetNextPageToken() != "" {
+			next = res.GetNextPageToken()
+			continue
+		}
+		// If everything went well use the generated getter to print the blog message
+		printBlog(res.GetBlog())
+		fmt.Println()
+	}
+	return next, nil
+}
+
 func init() {
+	listCmd.Flags().Int32("page-size", 0, "Number of blogs per page (0 uses the server default)")
+	listCmd.Flags().String("page-token", "", "Continue listing from a previous page")
+	listCmd.Flags().Bool("all", false, "List all blogs, fetching page after page")
+	listCmd.Flags().StringP("author", "a", "", "Only list blogs of this author")
+	listCmd.Flags().StringP("title", "t", "", "Only list blogs whose title contains this text (ignoring case)")
+	listCmd.Flags().String("since", "", "Only list blogs created since a date (2006-01-02), time (RFC 3339) or duration ago (72h)")
+	listCmd.Flags().String("until", "", "Only list blogs created before a date, time or duration ago")
+	listCmd.Flags().StringSlice("tag", nil, "Only list blogs carrying all of these tags")
+	listCmd.Flags().StringSlice("status", nil, "Only list blogs in these statuses: draft, scheduled, published or archived (default published)")
+	listCmd.Flags().String("sort", "", `Sort by "title" or "create_time", add " desc" for descending order`)
 	rootCmd.AddCommand(listCmd)
 }
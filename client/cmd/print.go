@@ -0,0 +1,92 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// printBlog prints a blog message in a human readable form.
+func printBlog(blog *blogpb.Blog) {
+	fmt.Printf("ID:       %s\n", blog.GetId())
+	fmt.Printf("Author:   %s\n", blog.GetAuthorId())
+	fmt.Printf("Title:    %s\n", blog.GetTitle())
+	fmt.Printf("Slug:     %s\n", blog.GetSlug())
+	fmt.Printf("Tags:     %s\n", strings.Join(blog.GetTags(), ", "))
+	fmt.Printf("Status:   %s\n", statusName(blog.GetStatus()))
+	fmt.Printf("Publish:  %s\n", formatTime(blog.GetPublishTime()))
+	fmt.Printf("Version:  %d\n", blog.GetVersion())
+	fmt.Printf("Created:  %s\n", formatTime(blog.GetCreateTime()))
+	fmt.Printf("Updated:  %s\n", formatTime(blog.GetUpdateTime()))
+	fmt.Printf("Editor:   %s\n", blog.GetEditor())
+	fmt.Printf("Format:   %s\n", formatName(blog.GetContentFormat()))
+	if blog.GetDeleteTime() != nil {
+		fmt.Printf("Deleted:  %s\n", formatTime(blog.GetDeleteTime()))
+	}
+	fmt.Printf("Content:\n%s\n", blog.GetContent())
+}
+
+// statusName is the lower case name of a blog status, as accepted by list --status.
+func statusName(st blogpb.BlogStatus) string {
+	return strings.ToLower(st.String())
+}
+
+// formatName is the lower case name of a content format, as accepted by create --format.
+func formatName(format blogpb.ContentFormat) string {
+	if format == blogpb.ContentFormat_CONTENT_FORMAT_UNSPECIFIED {
+		return "plain"
+	}
+	return strings.ToLower(format.String())
+}
+
+// parseFormat parses the name of a content format.
+func parseFormat(name string) (blogpb.ContentFormat, error) {
+	format, ok := blogpb.ContentFormat_value[strings.ToUpper(name)]
+	if !ok || format == 0 {
+		return 0, fmt.Errorf("unknown format %q, want plain or markdown", name)
+	}
+	return blogpb.ContentFormat(format), nil
+}
+
+// formatTime shows a server timestamp in local time.
+func formatTime(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return "-"
+	}
+	return ts.AsTime().Local().Format(time.RFC1123)
+}
+
+// printError prints the error a command failed with.
+// For a request the server rejected as invalid every problem goes on a line of its own.
+func printError(err error) {
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			fmt.Println("Error: the server rejected the request")
+			for _, violation := range badRequest.GetFieldViolations() {
+				fmt.Printf("  %s: %s\n", violation.GetField(), violation.GetDescription())
+			}
+			return
+		}
+	}
+	fmt.Println("Error:", err)
+}
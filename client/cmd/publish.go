@@ -0,0 +1,118 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// publishCmd represents the publish command
+var publishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "Publish a blog post now or at a later time",
+	Long: `Publish a blog post by its ID. With --at the blog is scheduled and the server publishes it at that time.
+			Example:
+			blogclient publish --id 5d... --at 2019-12-24T18:00:00+01:00
+			blogclient publish --id 5d... --at 2h`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		at, err := cmd.Flags().GetString("at")
+		version, err := cmd.Flags().GetInt64("version")
+		if err != nil {
+			return err
+		}
+
+		req := &blogpb.PublishBlogReq{Id: id, ExpectedVersion: version}
+		if at != "" {
+			t, err := parsePublishTime(at)
+			if err != nil {
+				return fmt.Errorf("--at: %v", err)
+			}
+			req.PublishTime = timestamppb.New(t)
+		}
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.PublishBlog(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		if res.GetBlog().GetStatus() == blogpb.BlogStatus_SCHEDULED {
+			fmt.Printf("Blog %s scheduled for %s\n", id, formatTime(res.GetBlog().GetPublishTime()))
+			return nil
+		}
+		fmt.Printf("Blog %s published\n", id)
+		return nil
+	},
+}
+
+// unpublishCmd represents the unpublish command
+var unpublishCmd = &cobra.Command{
+	Use:   "unpublish",
+	Short: "Turn a blog post back into a draft or archive it",
+	Long:  `Turn a published or scheduled blog post back into a draft, or archive it with --archive.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		archive, err := cmd.Flags().GetBool("archive")
+		version, err := cmd.Flags().GetInt64("version")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{
+			Id:              id,
+			Archive:         archive,
+			ExpectedVersion: version,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Blog %s is now %s\n", id, statusName(res.GetBlog().GetStatus()))
+		return nil
+	},
+}
+
+// parsePublishTime accepts a date (2006-01-02), an RFC 3339 time or a duration like 2h meaning that long from now.
+func parsePublishTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(d), nil
+	}
+	return parseTime(s)
+}
+
+func init() {
+	publishCmd.Flags().StringP("id", "i", "", "The id of the blog")
+	publishCmd.Flags().String("at", "", "Schedule the blog for a date (2006-01-02), time (RFC 3339) or duration from now (2h)")
+	publishCmd.Flags().Int64("version", 0, "The version of the blog, fails if it changed since (0 skips the check)")
+	publishCmd.MarkFlagRequired("id")
+	rootCmd.AddCommand(publishCmd)
+
+	unpublishCmd.Flags().StringP("id", "i", "", "The id of the blog")
+	unpublishCmd.Flags().Bool("archive", false, "Archive the blog instead of turning it back into a draft")
+	unpublishCmd.Flags().Int64("version", 0, "The version of the blog, fails if it changed since (0 skips the check)")
+	unpublishCmd.MarkFlagRequired("id")
+	rootCmd.AddCommand(unpublishCmd)
+}
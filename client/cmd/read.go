@@ -16,7 +16,6 @@ limitations under the License.
 package cmd
 
 import (
-	"context"
 	"fmt"
 	blogpb "github.com/snow-dev/simple-api/proto"
 
@@ -26,29 +25,63 @@ import (
 // readCmd represents the read command
 var readCmd = &cobra.Command{
 	Use:   "read",
-	Short: "Find a Blog post by its ID",
-	Long:  `Find a blog post by it's mongoDB Unique identifier'`,
+	Short: "Find a Blog post by its ID or slug",
+	Long: `Find a blog post by it's mongoDB Unique identifier' or by its slug with --slug.
+Old slugs of a blog still find it.`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		id, err := cmd.Flags().GetString("id")
+		slug, err := cmd.Flags().GetString("slug")
 		if err != nil {
 			return err
 		}
+		if (id == "") == (slug == "") {
+			return fmt.Errorf("set either --id or --slug")
+		}
+
+		if slug != "" {
+			ctx, cancel := requestContext()
+			defer cancel()
+			res, err := client.ReadBlogBySlug(ctx, &blogpb.ReadBlogBySlugReq{Slug: slug})
+			if err != nil {
+				return err
+			}
+			if res.GetMoved() {
+				fmt.Printf("%s is an old slug, the blog moved to %s\n\n", slug, res.GetBlog().GetSlug())
+			}
+			printBlog(res.GetBlog())
+			printByline(res.GetAuthor())
+			return nil
+		}
 
 		req := &blogpb.ReadBlogReq{
 			Id: id,
 		}
-		res, err := client.ReadBlog(context.Background(), req)
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.ReadBlog(ctx, req)
 		if err != nil {
 			return err
 		}
-		fmt.Println(res)
+		printBlog(res.GetBlog())
+		printByline(res.GetAuthor())
 		return nil
 	},
 }
 
+// printByline prints who wrote a blog, blogs from before authors may have no known author.
+func printByline(author *blogpb.Author) {
+	if author == nil {
+		return
+	}
+	fmt.Printf("\nWritten by %s (%s)\n", author.GetDisplayName(), author.GetId())
+	if author.GetBio() != "" {
+		fmt.Println(author.GetBio())
+	}
+}
+
 func init() {
 	readCmd.Flags().StringP("id", "i", "", "The id of the blog")
-	readCmd.MarkFlagRequired("id")
+	readCmd.Flags().StringP("slug", "s", "", "The slug of the blog")
 	rootCmd.AddCommand(readCmd)
 }
@@ -0,0 +1,73 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render a blog as HTML",
+	Long: `Render the content of a blog as HTML the way the server does for everyone, markdown included.
+Prints the reading time, the excerpt and the table of contents, then the HTML.
+With --html only the HTML is printed, e.g. to save it to a file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		version, err := cmd.Flags().GetInt64("version")
+		htmlOnly, err := cmd.Flags().GetBool("html")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.RenderBlog(ctx, &blogpb.RenderBlogReq{Id: id, Version: version})
+		if err != nil {
+			return err
+		}
+		if htmlOnly {
+			fmt.Print(res.GetHtml())
+			return nil
+		}
+
+		fmt.Printf("Version:  %d\n", res.GetVersion())
+		fmt.Printf("Reading:  %d min (%d words)\n", res.GetReadingTimeMinutes(), res.GetWordCount())
+		fmt.Printf("Excerpt:  %s\n", res.GetExcerpt())
+		if len(res.GetToc()) > 0 {
+			fmt.Println("Contents:")
+			for _, entry := range res.GetToc() {
+				// Indent the headings by their level
+				fmt.Printf("%s%s (#%s)\n", strings.Repeat("  ", int(entry.GetLevel())), entry.GetText(), entry.GetAnchor())
+			}
+		}
+		fmt.Printf("HTML:\n%s", res.GetHtml())
+		return nil
+	},
+}
+
+func init() {
+	renderCmd.Flags().StringP("id", "i", "", "The id of the blog")
+	renderCmd.Flags().Int64("version", 0, "Render this revision instead of the current version")
+	renderCmd.Flags().Bool("html", false, "Only print the HTML")
+	renderCmd.MarkFlagRequired("id")
+	rootCmd.AddCommand(renderCmd)
+}
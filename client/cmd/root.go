@@ -34,21 +34,28 @@ var cfgFile string
 // Client and context global vars for the cmd package
 // So they can be used by our subcommands.
 var client blogpb.BlogServiceClient
-var requestCtx context.Context
+var commentClient blogpb.CommentServiceClient
+var authorClient blogpb.AuthorServiceClient
+var attachmentClient blogpb.AttachmentServiceClient
 var requestOpts grpc.DialOption
 
+// timeout is how long a unary call to the server may take, set with --timeout.
+var timeout time.Duration
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "blogclient",
 	Short: "a gRPC client to communicate with the BlogService server",
 	Long:  `a gRPC client to communicate with the BlogService server. You can use this client to create and read blogs`,
+	// Errors are printed by Execute, so the problems of a rejected request can be listed
+	SilenceErrors: true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		printError(err)
 		os.Exit(1)
 	}
 }
@@ -58,8 +65,8 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	// After Cobra rootconfig init, initialize the client
 	fmt.Println("Starting Blog Service Client")
-	// Establish context to timeout after 10 seconds if server does not respond.
-	requestCtx, _ = context.WithTimeout(context.Background(), 10*time.Second)
+	// Every unary call gives up when the server doesn't respond in time, see requestContext.
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 10*time.Second, "How long each unary call to the server may take, 0 for no limit (import, attach, fetch and watch are never limited)")
 	// Establish insecure grpc options (no TLS)
 	requestOpts = grpc.WithInsecure()
 	// Dial the server, returns a client connection
@@ -69,6 +76,25 @@ func init() {
 	}
 	// Instantiate the BlogServiceClient with our client connection to the server
 	client = blogpb.NewBlogServiceClient(conn)
+	// The comments, authors and attachments are served on the same connection
+	commentClient = blogpb.NewCommentServiceClient(conn)
+	authorClient = blogpb.NewAuthorServiceClient(conn)
+	attachmentClient = blogpb.NewAttachmentServiceClient(conn)
+}
+
+// requestContext returns the context of a single call to the server, it times out after --timeout.
+// The server cancels its work on the call as soon as the context ends.
+func requestContext() (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// transferContext returns the context of a bulk stream (import, upload or download), --timeout doesn't apply.
+// These run as long as there is data to move, the server puts its own, much longer, limit on them.
+func transferContext() (context.Context, context.CancelFunc) {
+	return context.WithCancel(context.Background())
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -0,0 +1,72 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// searchCmd represents the search command
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search blog posts by the words in their title and content",
+	Long: `Search blog posts by the words in their title and content, best match first.
+Words must all match unless separated by OR, "quotes" match an exact phrase
+and title: or content: restrict a word or phrase to one field.
+			Example:
+			blogclient search 'title:grpc "protocol buffers" OR mongodb'`,
+	Args: cobra.MinimumNArgs(1),
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, err := cmd.Flags().GetInt32("limit")
+		if err != nil {
+			return err
+		}
+
+		req := &blogpb.SearchBlogsReq{
+			Query: strings.Join(args, " "),
+			Limit: limit,
+		}
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.SearchBlogs(ctx, req)
+		if err != nil {
+			return err
+		}
+
+		if len(res.GetResults()) == 0 {
+			fmt.Println("No blogs found")
+			return nil
+		}
+		for _, result := range res.GetResults() {
+			fmt.Printf("%s  (id %s, score %.2f)\n", result.GetTitleHighlight(), result.GetBlog().GetId(), result.GetScore())
+			if result.GetSnippet() != "" {
+				fmt.Printf("    %s\n", result.GetSnippet())
+			}
+			fmt.Println()
+		}
+		return nil
+	},
+}
+
+func init() {
+	searchCmd.Flags().Int32P("limit", "l", 0, "Maximum number of results (0 uses the server default)")
+	rootCmd.AddCommand(searchCmd)
+}
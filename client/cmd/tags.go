@@ -0,0 +1,52 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// tagsCmd represents the tags command
+var tagsCmd = &cobra.Command{
+	Use:   "tags",
+	Short: "List the tags in use",
+	Long:  `List every tag with the number of blogs carrying it, most used first.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.ListTags(ctx, &blogpb.ListTagsReq{})
+		if err != nil {
+			return err
+		}
+
+		if len(res.GetTags()) == 0 {
+			fmt.Println("No tags yet")
+			return nil
+		}
+		for _, tag := range res.GetTags() {
+			fmt.Printf("%6d  %s\n", tag.GetCount(), tag.GetTag())
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tagsCmd)
+}
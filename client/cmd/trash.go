@@ -0,0 +1,129 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+)
+
+// trashCmd represents the trash command
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List deleted blog posts",
+	Long: `List the blog posts in the trash. They can be restored with 'blogclient restore' until
+the server purges them, or be purged right away with 'blogclient purge'.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pageSize, err := cmd.Flags().GetInt32("page-size")
+		pageToken, err := cmd.Flags().GetString("page-token")
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			return err
+		}
+
+		for {
+			next, err := listDeletedPage(&blogpb.ListDeletedBlogsReq{
+				PageSize:  pageSize,
+				PageToken: pageToken,
+			})
+			if err != nil {
+				return err
+			}
+			if next == "" {
+				return nil
+			}
+			if !all {
+				fmt.Printf("More blogs available, run again with --page-token %s or use --all\n", next)
+				return nil
+			}
+			pageToken = next
+		}
+	},
+}
+
+// listDeletedPage prints a single page of deleted blogs and returns the token of the next page, if any.
+func listDeletedPage(req *blogpb.ListDeletedBlogsReq) (string, error) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	stream, err := client.ListDeletedBlogs(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return printPage(stream)
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore a deleted blog post",
+	Long:  `Move a blog post out of the trash, it comes back with the status it had.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.RestoreBlog(ctx, &blogpb.RestoreBlogReq{Id: id})
+		if err != nil {
+			return err
+		}
+		printBlog(res.GetBlog())
+		return nil
+	},
+}
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently delete a blog post from the trash",
+	Long:  `Permanently delete a blog post that is in the trash, this cannot be undone.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := cmd.Flags().GetString("id")
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := requestContext()
+		defer cancel()
+		if _, err := client.PurgeBlog(ctx, &blogpb.PurgeBlogReq{Id: id}); err != nil {
+			return err
+		}
+		fmt.Printf("Permanently deleted the blog with ID: %s\n", id)
+		return nil
+	},
+}
+
+func init() {
+	trashCmd.Flags().Int32("page-size", 0, "Number of blogs per page (0 uses the server default)")
+	trashCmd.Flags().String("page-token", "", "Continue listing from a previous page")
+	trashCmd.Flags().Bool("all", false, "List all deleted blogs, fetching page after page")
+	rootCmd.AddCommand(trashCmd)
+
+	restoreCmd.Flags().StringP("id", "i", "", "The id of the blog")
+	restoreCmd.MarkFlagRequired("id")
+	rootCmd.AddCommand(restoreCmd)
+
+	purgeCmd.Flags().StringP("id", "i", "", "The id of the blog")
+	purgeCmd.MarkFlagRequired("id")
+	rootCmd.AddCommand(purgeCmd)
+}
@@ -16,9 +16,14 @@ limitations under the License.
 package cmd
 
 import (
-	"context"
 	"fmt"
+	"os"
+
 	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	"github.com/spf13/cobra"
 )
@@ -26,8 +31,11 @@ import (
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
 	Use:   "update",
-	Short: "Find a Blog by its ID.",
-	Long:  `Find a Blog by its mongoDB Unique identifier. If not blog is found whit the ID it will return a 'Not Found' error`,
+	Short: "Update a Blog by its ID.",
+	Long: `Update a Blog by its mongoDB Unique identifier. Only the fields given with --author, --title,
+--content, --tag, --slug and --format are changed, --tag replaces all the tags of the blog, --tag "" removes them.
+A new --title also changes the slug unless --slug is given, the old slug keeps working.
+If not blog is found whit the ID it will return a 'Not Found' error`,
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// Get the flags from CLI
@@ -35,6 +43,29 @@ var updateCmd = &cobra.Command{
 		author, err := cmd.Flags().GetString("author")
 		title, err := cmd.Flags().GetString("title")
 		content, err := cmd.Flags().GetString("content")
+		tags, err := cmd.Flags().GetStringSlice("tag")
+		slug, err := cmd.Flags().GetString("slug")
+		editor, err := cmd.Flags().GetString("editor")
+		version, err := cmd.Flags().GetInt64("version")
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+		contentFormat, err := parseFormat(format)
+		if err != nil {
+			return err
+		}
+
+		// Only send the fields the user actually set, the others keep their current value.
+		mask := &fieldmaskpb.FieldMask{}
+		for _, f := range []struct{ flag, field string }{{"author", "author_id"}, {"title", "title"}, {"content", "content"}, {"tag", "tags"}, {"slug", "slug"}, {"format", "content_format"}} {
+			if cmd.Flags().Changed(f.flag) {
+				mask.Paths = append(mask.Paths, f.field)
+			}
+		}
+		if len(mask.Paths) == 0 {
+			return fmt.Errorf("nothing to update, set at least one of --author, --title, --content, --tag, --slug or --format")
+		}
 
 		// Create an UpdateBlogRequest
 		req := &blogpb.UpdateBlogReq{
@@ -43,24 +74,54 @@ var updateCmd = &cobra.Command{
 				AuthorId: author,
 				Title:    title,
 				Content:  content,
+				Tags:     tags,
+				Slug:     slug,
+				// Only sent if --format is given, like every other field
+				ContentFormat: contentFormat,
 			},
+			ExpectedVersion: version,
+			UpdateMask:      mask,
+			Editor:          editor,
 		}
 
-		res, err := client.UpdateBlog(context.Background(), req)
+		ctx, cancel := requestContext()
+		defer cancel()
+		res, err := client.UpdateBlog(ctx, req)
+		if status.Code(err) == codes.Aborted {
+			// Someone else updated the blog since we read it
+			return fmt.Errorf("update rejected, the blog was changed by someone else (now at version %s, you had %d).\n"+
+				"Read it again with 'blogclient read --id %s' and retry with --version %s",
+				currentVersion(err), version, id, currentVersion(err))
+		}
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(res)
+		printBlog(res.GetBlog())
 		return nil
 	},
 }
 
+// currentVersion extracts the version the server reported in a version conflict error.
+func currentVersion(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetReason() == "VERSION_MISMATCH" {
+			return info.GetMetadata()["current_version"]
+		}
+	}
+	return "unknown"
+}
+
 func init() {
 	updateCmd.Flags().StringP("id", "i", "", "The id of the blog")
-	updateCmd.Flags().StringP("author", "a", "", "Add an author")
+	updateCmd.Flags().StringP("author", "a", "", "The id of the new author, see 'blogclient authors'")
 	updateCmd.Flags().StringP("title", "t", "", "A title for the blog")
 	updateCmd.Flags().StringP("content", "c", "", "The content for the blog")
+	updateCmd.Flags().StringSlice("tag", nil, "Replace the tags of the blog, repeat the flag or separate tags with commas")
+	updateCmd.Flags().String("slug", "", "A new slug for the blog")
+	updateCmd.Flags().String("format", "plain", "How the content is written: plain or markdown")
+	updateCmd.Flags().Int64("version", 0, "The version of the blog the update is based on, the update fails if it changed since (0 overwrites unconditionally)")
+	updateCmd.Flags().String("editor", os.Getenv("USER"), "Who makes the change, recorded in the history of the blog")
 	updateCmd.MarkFlagRequired("id")
 	rootCmd.AddCommand(updateCmd)
 }
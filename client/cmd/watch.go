@@ -0,0 +1,116 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Follow the changes to blog posts as they happen",
+	Long: `Print a line for every blog post created, updated or deleted until interrupted with CTRL+C.
+If the server goes away the watch reconnects and continues where it left off.
+			Example:
+			blogclient watch --author snow-dev --tag go`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := &blogpb.WatchBlogsReq{}
+		var err error
+		req.AuthorId, err = cmd.Flags().GetString("author")
+		req.Tags, err = cmd.Flags().GetStringSlice("tag")
+		req.ResumeToken, err = cmd.Flags().GetString("resume")
+		if err != nil {
+			return err
+		}
+
+		// CTRL+C ends the watch, telling how to continue from there later.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			<-interrupt
+			cancel()
+		}()
+
+		for {
+			err := watch(ctx, req)
+			if status.Code(err) == codes.Canceled {
+				if req.GetResumeToken() != "" {
+					fmt.Printf("Continue with --resume %s\n", req.GetResumeToken())
+				}
+				return nil
+			}
+			// The server restarted or is shutting down, retry with the last token until it is back.
+			if status.Code(err) == codes.Unavailable {
+				fmt.Printf("Lost the connection, reconnecting: %v\n", status.Convert(err).Message())
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+				}
+				continue
+			}
+			if status.Code(err) == codes.FailedPrecondition {
+				return fmt.Errorf("%v\nSome changes were missed, run 'blogclient list' to catch up and watch again", status.Convert(err).Message())
+			}
+			if err != nil && req.GetResumeToken() != "" {
+				return fmt.Errorf("%v\nContinue with --resume %s", err, req.GetResumeToken())
+			}
+			return err
+		}
+	},
+}
+
+// watch prints the events of a single WatchBlogs call, keeping the resume token of the last one in req.
+func watch(ctx context.Context, req *blogpb.WatchBlogsReq) error {
+	stream, err := client.WatchBlogs(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		req.ResumeToken = res.GetResumeToken()
+		// Only a newer resume token, the changes were to blogs we don't follow.
+		if res.GetType() == blogpb.BlogEventType_BLOG_EVENT_TYPE_UNSPECIFIED {
+			continue
+		}
+		blog := res.GetBlog()
+		fmt.Printf("%s  %-7s  %s  v%-3d  %-9s  %s\n",
+			res.GetTime().AsTime().Local().Format("2006-01-02 15:04:05"), res.GetType(),
+			blog.GetId(), blog.GetVersion(), statusName(blog.GetStatus()), blog.GetTitle())
+	}
+}
+
+func init() {
+	watchCmd.Flags().StringP("author", "a", "", "Only follow the blogs of this author")
+	watchCmd.Flags().StringSlice("tag", nil, "Only follow blogs carrying all of these tags")
+	watchCmd.Flags().String("resume", "", "Continue after the change with this resume token")
+	rootCmd.AddCommand(watchCmd)
+}
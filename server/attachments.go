@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrAttachmentNotFound is returned by an AttachmentStore when no attachment has the given ID.
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+const (
+	// maxFilenameLength is the most characters the filename of an attachment may have.
+	maxFilenameLength = 255
+	// attachmentChunkSize is the size of the chunks DownloadAttachment sends.
+	attachmentChunkSize = 64 << 10
+)
+
+// AttachmentStore keeps what is known about the attachments, every BlogStore is one.
+// The content lives in a BlobStore. Attachments belong to a blog, BlogStore.Delete removes them
+// together with the blog.
+type AttachmentStore interface {
+	// CreateAttachment inserts a new attachment, the ID is generated by the store and set on the returned item.
+	CreateAttachment(ctx context.Context, item *AttachmentItem) (*AttachmentItem, error)
+	// ReadAttachment returns the attachment with the given ID or ErrAttachmentNotFound.
+	ReadAttachment(ctx context.Context, id primitive.ObjectID) (*AttachmentItem, error)
+	// ListAttachments returns the attachments of a blog in the order they were created.
+	ListAttachments(ctx context.Context, blogID primitive.ObjectID) ([]*AttachmentItem, error)
+}
+
+type AttachmentItem struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID      primitive.ObjectID `bson:"blog_id"`
+	Filename    string             `bson:"filename"`
+	ContentType string             `bson:"content_type"`
+	Size        int64              `bson:"size"`
+	// SHA256 is the hex encoded hash the content is stored under in the BlobStore.
+	SHA256 string `bson:"sha256"`
+	// Managed by the server, with millisecond precision like the blog timestamps.
+	CreateTime time.Time `bson:"create_time"`
+}
+
+// toProto converts a stored AttachmentItem into its protobuf counterpart.
+func (a *AttachmentItem) toProto() *blogpb.Attachment {
+	return &blogpb.Attachment{
+		Id:          a.ID.Hex(),
+		BlogId:      a.BlogID.Hex(),
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Sha256:      a.SHA256,
+		CreateTime:  timestampOrNil(a.CreateTime),
+	}
+}
+
+// sortAttachments orders a snapshot of attachments by creation, for the stores keeping them in memory.
+func sortAttachments(items []*AttachmentItem) []*AttachmentItem {
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0
+	})
+	return items
+}
+
+// validateAttachment checks what the client tells about an upload, maxSize is the limit of the server.
+func validateAttachment(v *fieldViolations, a *blogpb.Attachment, maxSize int64) {
+	v.objectID("attachment.blog_id", a.GetBlogId())
+	switch filename := a.GetFilename(); {
+	case filename == "":
+		v.add("attachment.filename", "must not be empty")
+	case strings.ContainsAny(filename, `/\`) || filename == "." || filename == "..":
+		v.add("attachment.filename", "%q must be a name without directories", filename)
+	default:
+		v.text("attachment.filename", filename, maxFilenameLength)
+	}
+	switch {
+	case a.GetSize() <= 0:
+		v.add("attachment.size", "must be positive")
+	case a.GetSize() > maxSize:
+		v.add("attachment.size", "%d is above the limit of %d bytes", a.GetSize(), maxSize)
+	}
+	if hash, err := hex.DecodeString(a.GetSha256()); err != nil || len(hash) != sha256.Size || strings.ToLower(a.GetSha256()) != a.GetSha256() {
+		v.add("attachment.sha256", "%q is not a lower case hex encoded SHA-256", a.GetSha256())
+	}
+	if a.GetContentType() != "" {
+		if _, _, err := mime.ParseMediaType(a.GetContentType()); err != nil {
+			v.add("attachment.content_type", "%q is not a MIME type: %v", a.GetContentType(), err)
+		}
+	}
+}
+
+// AttachmentServiceServer implements blogpb.AttachmentServiceServer, keeping the attachments
+// in the same store as the blogs and their content in blobs. The size limit is enforced by
+// the validation layer, see requestValidator.
+type AttachmentServiceServer struct {
+	store BlogStore
+	blobs BlobStore
+}
+
+// upload receives the content of an upload and checks it against the declared size and hash.
+type upload struct {
+	stream   blogpb.AttachmentService_UploadAttachmentServer
+	declared *blogpb.Attachment
+	hash     hash.Hash
+	size     int64
+	// head is the start of the content, to detect the content type from.
+	head []byte
+}
+
+// receive copies the chunks to w, the first one comes with the attachment already.
+func (u *upload) receive(first []byte, w io.Writer) error {
+	chunk := first
+	for {
+		u.size += int64(len(chunk))
+		if u.size > u.declared.GetSize() {
+			// Stop right away instead of receiving everything a client sends.
+			return invalidField("chunk", "received more than the declared size of %d bytes", u.declared.GetSize())
+		}
+		if room := 512 - len(u.head); room > 0 {
+			if room > len(chunk) {
+				room = len(chunk)
+			}
+			u.head = append(u.head, chunk[:room]...)
+		}
+		u.hash.Write(chunk)
+		if _, err := w.Write(chunk); err != nil {
+			return storeError(err, "Could not store the attachment")
+		}
+
+		req, err := u.stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		chunk = req.GetChunk()
+	}
+
+	if u.size != u.declared.GetSize() {
+		return invalidField("chunk", "received %d bytes, not the declared size of %d", u.size, u.declared.GetSize())
+	}
+	if got := hex.EncodeToString(u.hash.Sum(nil)); got != u.declared.GetSha256() {
+		return status.Errorf(codes.DataLoss, "The content has the SHA-256 %s, not the declared %s", got, u.declared.GetSha256())
+	}
+	return nil
+}
+
+func (s AttachmentServiceServer) UploadAttachment(stream blogpb.AttachmentService_UploadAttachmentServer) error {
+	ctx := stream.Context()
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return invalidField("attachment", "the upload is empty, the first message must carry the attachment")
+	}
+	if err != nil {
+		return err
+	}
+	declared := req.GetAttachment()
+	if declared == nil {
+		return invalidField("attachment", "the first message must carry the attachment")
+	}
+	blogID, err := primitive.ObjectIDFromHex(declared.GetBlogId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	if _, err := readLiveBlog(ctx, s.store, blogID); err != nil {
+		return err
+	}
+
+	u := &upload{stream: stream, declared: declared, hash: sha256.New()}
+	// Content that is stored already is only verified, not stored again.
+	stored, err := s.blobs.Has(ctx, declared.GetSha256())
+	if err != nil {
+		return storeError(err, "Could not look up the content")
+	}
+	if stored {
+		if err := u.receive(req.GetChunk(), io.Discard); err != nil {
+			return err
+		}
+	} else {
+		w, err := s.blobs.Create(ctx, declared.GetSha256())
+		if err != nil {
+			return storeError(err, "Could not store the attachment")
+		}
+		if err := u.receive(req.GetChunk(), w); err != nil {
+			w.Abort()
+			return err
+		}
+		if err := w.Commit(); err != nil {
+			return storeError(err, "Could not store the attachment")
+		}
+	}
+
+	contentType := declared.GetContentType()
+	if contentType == "" {
+		contentType = http.DetectContentType(u.head)
+	}
+	data, err := s.store.CreateAttachment(ctx, &AttachmentItem{
+		BlogID:      blogID,
+		Filename:    declared.GetFilename(),
+		ContentType: contentType,
+		Size:        u.size,
+		SHA256:      declared.GetSha256(),
+		CreateTime:  now(),
+	})
+	if err != nil {
+		return storeError(err, "Could not save the attachment")
+	}
+	return stream.SendAndClose(&blogpb.UploadAttachmentRes{Attachment: data.toProto(), Deduplicated: stored})
+}
+
+func (s AttachmentServiceServer) DownloadAttachment(req *blogpb.DownloadAttachmentReq, stream blogpb.AttachmentService_DownloadAttachmentServer) error {
+	ctx := stream.Context()
+	oid, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	data, err := s.store.ReadAttachment(ctx, oid)
+	if err == ErrAttachmentNotFound {
+		return status.Errorf(codes.NotFound, "Could not find attachment with Object Id %s", req.GetId())
+	}
+	if err != nil {
+		return storeError(err, "Could not read attachment %s", req.GetId())
+	}
+	if _, err := readLiveBlog(ctx, s.store, data.BlogID); err != nil {
+		return err
+	}
+
+	content, err := s.blobs.Open(ctx, data.SHA256)
+	if err == ErrBlobNotFound {
+		return status.Errorf(codes.DataLoss, "The content of attachment %s is missing", req.GetId())
+	}
+	if err != nil {
+		return storeError(err, "Could not open attachment %s", req.GetId())
+	}
+	defer content.Close()
+
+	res := &blogpb.DownloadAttachmentRes{Attachment: data.toProto()}
+	for {
+		// A new buffer for every chunk, a sent message must not change.
+		buf := make([]byte, attachmentChunkSize)
+		n, err := io.ReadFull(content, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return storeError(err, "Could not read attachment %s", req.GetId())
+		}
+		if n > 0 {
+			res.Chunk = buf[:n]
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+			res = &blogpb.DownloadAttachmentRes{}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if res.Attachment != nil {
+		// Attachments are never empty, but the attachment has to be sent anyway.
+		return stream.Send(res)
+	}
+	return nil
+}
+
+func (s AttachmentServiceServer) ListAttachments(ctx context.Context, req *blogpb.ListAttachmentsReq) (*blogpb.ListAttachmentsRes, error) {
+	blogID, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	if _, err := readLiveBlog(ctx, s.store, blogID); err != nil {
+		return nil, err
+	}
+	items, err := s.store.ListAttachments(ctx, blogID)
+	if err != nil {
+		return nil, storeError(err, "Could not list attachments")
+	}
+	res := &blogpb.ListAttachmentsRes{}
+	for _, data := range items {
+		res.Attachments = append(res.Attachments, data.toProto())
+	}
+	return res, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// createBlog creates a blog to attach files to and returns its ID.
+func (e *testEnv) createBlog(ctx context.Context, t *testing.T) string {
+	t.Helper()
+	created, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Attachments")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created.GetBlog().GetId()
+}
+
+// upload uploads content in one chunk.
+func (e *testEnv) upload(ctx context.Context, blogID, filename string, content []byte) (*blogpb.Attachment, error) {
+	declared := &blogpb.Attachment{BlogId: blogID, Filename: filename, Size: int64(len(content)), Sha256: sha256Hex(content)}
+	res, err := e.uploadChunks(ctx, declared, content)
+	return res.GetAttachment(), err
+}
+
+// uploadChunks uploads declared with the content sent in the given chunks.
+func (e *testEnv) uploadChunks(ctx context.Context, declared *blogpb.Attachment, chunks ...[]byte) (*blogpb.UploadAttachmentRes, error) {
+	stream, err := e.attachments.UploadAttachment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, chunk := range chunks {
+		req := &blogpb.UploadAttachmentReq{Chunk: chunk}
+		if i == 0 {
+			req.Attachment = declared
+		}
+		// A failed send means the server ended the upload, CloseAndRecv returns why.
+		if err := stream.Send(req); err != nil {
+			break
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// download returns the attachment and its content.
+func (e *testEnv) download(ctx context.Context, id string) (*blogpb.Attachment, []byte, error) {
+	stream, err := e.attachments.DownloadAttachment(ctx, &blogpb.DownloadAttachmentReq{Id: id})
+	if err != nil {
+		return nil, nil, err
+	}
+	var attachment *blogpb.Attachment
+	var content []byte
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return attachment, content, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if res.GetAttachment() != nil {
+			attachment = res.GetAttachment()
+		}
+		content = append(content, res.GetChunk()...)
+	}
+}
+
+func sha256Hex(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+func TestUploadAndDownload(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	// More than one download chunk.
+	content := bytes.Repeat([]byte("Some text. "), attachmentChunkSize/5)
+	declared := &blogpb.Attachment{BlogId: e.createBlog(ctx, t), Filename: "notes.txt", Size: int64(len(content)), Sha256: sha256Hex(content)}
+
+	res, err := e.uploadChunks(ctx, declared, content[:100], content[100:5000], content[5000:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetDeduplicated() {
+		t.Error("New content was deduplicated")
+	}
+	if got := res.GetAttachment().GetContentType(); got != "text/plain; charset=utf-8" {
+		t.Errorf("Got content type %q, want it detected as text", got)
+	}
+
+	attachment, got, err := e.download(ctx, res.GetAttachment().GetId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Downloaded %d bytes, not the %d uploaded", len(got), len(content))
+	}
+	if attachment.GetFilename() != "notes.txt" || attachment.GetSize() != int64(len(content)) {
+		t.Errorf("Got attachment %v", attachment)
+	}
+
+	// The same content again is stored once.
+	declared.Filename = "copy.txt"
+	again, err := e.uploadChunks(ctx, declared, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.GetDeduplicated() || again.GetAttachment().GetId() == res.GetAttachment().GetId() {
+		t.Errorf("Got deduplicated %v, want a new attachment with the stored content", again.GetDeduplicated())
+	}
+}
+
+func TestUploadMismatch(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	blogID := e.createBlog(ctx, t)
+	content := []byte("The real content")
+	tests := []struct {
+		name    string
+		size    int64
+		sha256  string
+		content []byte
+		want    codes.Code
+	}{
+		{"same size, other content", int64(len(content)), sha256Hex(content), []byte("The fake content"), codes.DataLoss},
+		{"fewer bytes", int64(len(content)) + 1, sha256Hex(content), content, codes.InvalidArgument},
+		{"more bytes", int64(len(content)) - 1, sha256Hex(content), content, codes.InvalidArgument},
+		{"above the limit", 2 << 20, sha256Hex(content), content, codes.InvalidArgument},
+		{"malformed hash", int64(len(content)), "abc", content, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		declared := &blogpb.Attachment{BlogId: blogID, Filename: "file.txt", Size: tt.size, Sha256: tt.sha256}
+		if _, err := e.uploadChunks(ctx, declared, tt.content); status.Code(err) != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// None of them left an attachment or content behind.
+	list, err := e.attachments.ListAttachments(ctx, &blogpb.ListAttachmentsReq{BlogId: blogID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.GetAttachments()) != 0 {
+		t.Errorf("Got %d attachments, want none", len(list.GetAttachments()))
+	}
+	res, err := e.uploadChunks(ctx, &blogpb.Attachment{BlogId: blogID, Filename: "file.txt", Size: int64(len(content)), Sha256: sha256Hex(content)}, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetDeduplicated() {
+		t.Error("A failed upload stored the content")
+	}
+}
+
+func TestUploadToTrashedBlog(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	blogID := e.createBlog(ctx, t)
+	attachment, err := e.upload(ctx, blogID, "file.txt", []byte("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: blogID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.upload(ctx, blogID, "other.txt", []byte("content")); status.Code(err) != codes.NotFound {
+		t.Errorf("Got %v uploading to a trashed blog, want NotFound", err)
+	}
+	if _, err := e.upload(ctx, primitive.NewObjectID().Hex(), "file.txt", []byte("content")); status.Code(err) != codes.NotFound {
+		t.Errorf("Got %v uploading to a missing blog, want NotFound", err)
+	}
+	// The attachments of a blog moved to the trash can't be downloaded anymore.
+	if _, _, err := e.download(ctx, attachment.GetId()); status.Code(err) != codes.NotFound {
+		t.Errorf("Got %v downloading from a trashed blog, want NotFound", err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrAuthorNotFound is returned by an AuthorStore when no author has the given ID.
+	ErrAuthorNotFound = errors.New("author not found")
+	// ErrAuthorExists is returned by AuthorStore.CreateAuthor when the ID is taken.
+	ErrAuthorExists = errors.New("author already exists")
+)
+
+// Limits of the author fields.
+const (
+	maxAuthorIDLength    = 32
+	maxDisplayNameLength = 100
+	maxBioLength         = 1000
+)
+
+// AuthorStore is the storage behind AuthorServiceServer, every BlogStore is one.
+type AuthorStore interface {
+	// CreateAuthor inserts a new author with the ID chosen by the client, or fails with ErrAuthorExists.
+	CreateAuthor(ctx context.Context, item *AuthorItem) (*AuthorItem, error)
+	// ReadAuthor returns the author with the given ID or ErrAuthorNotFound.
+	ReadAuthor(ctx context.Context, id string) (*AuthorItem, error)
+	// UpdateAuthor copies the given fields (see authorFields) and UpdateTime from item
+	// to the existing author and returns the updated author.
+	UpdateAuthor(ctx context.Context, item *AuthorItem, fields []string) (*AuthorItem, error)
+	// ListAuthors calls fn for every author selected by q in the order of their IDs,
+	// stopping at the first error.
+	ListAuthors(ctx context.Context, q AuthorQuery, fn func(*AuthorItem) error) error
+}
+
+type AuthorItem struct {
+	// ID is the handle the blogs refer to in their AuthorID.
+	ID          string `bson:"_id"`
+	DisplayName string `bson:"display_name"`
+	Bio         string `bson:"bio"`
+	Email       string `bson:"email"`
+	// Managed by the server, with millisecond precision like the blog timestamps.
+	CreateTime time.Time `bson:"create_time"`
+	UpdateTime time.Time `bson:"update_time"`
+}
+
+// authorFields are the fields UpdateAuthor may change, named like in the proto, BSON and SQL.
+var authorFields = []string{"display_name", "bio", "email"}
+
+// applyAuthorFields copies the given fields from src to dst.
+func applyAuthorFields(dst, src *AuthorItem, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "display_name":
+			dst.DisplayName = src.DisplayName
+		case "bio":
+			dst.Bio = src.Bio
+		case "email":
+			dst.Email = src.Email
+		}
+	}
+}
+
+// fieldValue returns the value of one of the authorFields, or nil for any other field.
+func (a *AuthorItem) fieldValue(field string) interface{} {
+	switch field {
+	case "display_name":
+		return a.DisplayName
+	case "bio":
+		return a.Bio
+	case "email":
+		return a.Email
+	}
+	return nil
+}
+
+// toProto converts a stored AuthorItem into its protobuf counterpart.
+func (a *AuthorItem) toProto() *blogpb.Author {
+	return &blogpb.Author{
+		Id:          a.ID,
+		DisplayName: a.DisplayName,
+		Bio:         a.Bio,
+		Email:       a.Email,
+		CreateTime:  timestampOrNil(a.CreateTime),
+		UpdateTime:  timestampOrNil(a.UpdateTime),
+	}
+}
+
+// AuthorQuery selects the authors returned by AuthorStore.ListAuthors.
+type AuthorQuery struct {
+	// The cursor of keyset pagination: authors up to and including the ID After are skipped.
+	After string
+	// Limit is the maximum number of authors, 0 means no limit.
+	Limit int
+}
+
+// listAuthorsInOrder sorts a snapshot of authors and calls fn for the ones selected by q.
+// Stores that keep everything in memory share it.
+func listAuthorsInOrder(ctx context.Context, items []AuthorItem, q AuthorQuery, fn func(*AuthorItem) error) error {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID < items[j].ID
+	})
+	start := sort.Search(len(items), func(i int) bool { return items[i].ID > q.After })
+	items = items[start:]
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+
+	for i := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAuthorID checks an author ID is a handle like "snow-dev", the same form as a slug.
+func validateAuthorID(id string) error {
+	if id == "" {
+		return errors.New("must not be empty")
+	}
+	if len(id) > maxAuthorIDLength {
+		return fmt.Errorf("is longer than %d characters", maxAuthorIDLength)
+	}
+	if slugify(id) != id {
+		return fmt.Errorf("%q may only contain lower case letters a-z, digits and single dashes between them, like %q", id, slugify(id))
+	}
+	return nil
+}
+
+// validateAuthorFields checks the given fields of an author in a request.
+func validateAuthorFields(v *fieldViolations, author *blogpb.Author, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "display_name":
+			v.requiredText("author.display_name", author.GetDisplayName(), maxDisplayNameLength)
+		case "bio":
+			v.text("author.bio", author.GetBio(), maxBioLength)
+		case "email":
+			if author.GetEmail() == "" {
+				continue
+			}
+			// Only a bare address, "Snow <snow@example.com>" would parse too.
+			addr, err := mail.ParseAddress(author.GetEmail())
+			if err != nil || addr.Address != author.GetEmail() {
+				v.add("author.email", "%q is not a valid address", author.GetEmail())
+			}
+		}
+	}
+}
+
+// checkAuthor makes sure a blog refers to a known author, the error is a gRPC error.
+func checkAuthor(ctx context.Context, store AuthorStore, id string) error {
+	_, err := store.ReadAuthor(ctx, id)
+	if err == ErrAuthorNotFound {
+		return status.Errorf(codes.FailedPrecondition, "Unknown author %q, create the author with CreateAuthor first", id)
+	}
+	if err != nil {
+		return storeError(err, "Could not read author %q", id)
+	}
+	return nil
+}
+
+// publicAuthor returns the author of a blog to show next to it, without the email address.
+// Blogs from before authors may refer to no known author, they get nil.
+func publicAuthor(ctx context.Context, store AuthorStore, id string) (*blogpb.Author, error) {
+	author, err := store.ReadAuthor(ctx, id)
+	if err == ErrAuthorNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read author %q", id)
+	}
+	info := author.toProto()
+	info.Email = ""
+	return info, nil
+}
+
+// AuthorServiceServer implements blogpb.AuthorServiceServer on top of the same store as the blogs.
+type AuthorServiceServer struct {
+	store BlogStore
+
+	pageTokens      *pageTokenCodec
+	defaultPageSize int
+	maxPageSize     int
+}
+
+func (s AuthorServiceServer) CreateAuthor(ctx context.Context, req *blogpb.CreateAuthorReq) (*blogpb.CreateAuthorRes, error) {
+	author := req.GetAuthor()
+	created := now()
+	data := &AuthorItem{
+		ID:          author.GetId(),
+		DisplayName: author.GetDisplayName(),
+		Bio:         author.GetBio(),
+		Email:       author.GetEmail(),
+		CreateTime:  created,
+		UpdateTime:  created,
+	}
+
+	result, err := s.store.CreateAuthor(ctx, data)
+	if err == ErrAuthorExists {
+		return nil, status.Errorf(codes.AlreadyExists, "Author %q already exists", author.GetId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not create author %q", author.GetId())
+	}
+	return &blogpb.CreateAuthorRes{Author: result.toProto()}, nil
+}
+
+func (s AuthorServiceServer) ReadAuthor(ctx context.Context, req *blogpb.ReadAuthorReq) (*blogpb.ReadAuthorRes, error) {
+	data, err := s.store.ReadAuthor(ctx, req.GetId())
+	if err == ErrAuthorNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find author %q", req.GetId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read author %q", req.GetId())
+	}
+	return &blogpb.ReadAuthorRes{Author: data.toProto()}, nil
+}
+
+func (s AuthorServiceServer) UpdateAuthor(ctx context.Context, req *blogpb.UpdateAuthorReq) (*blogpb.UpdateAuthorRes, error) {
+	author := req.GetAuthor()
+	// Only the fields named in the mask are updated, no mask means all of them.
+	fields := req.GetUpdateMask().GetPaths()
+	if len(fields) == 0 {
+		fields = authorFields
+	}
+	data := &AuthorItem{
+		ID:          author.GetId(),
+		DisplayName: author.GetDisplayName(),
+		Bio:         author.GetBio(),
+		Email:       author.GetEmail(),
+		UpdateTime:  now(),
+	}
+
+	updated, err := s.store.UpdateAuthor(ctx, data, fields)
+	if err == ErrAuthorNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find author %q", author.GetId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not update author %q", author.GetId())
+	}
+	return &blogpb.UpdateAuthorRes{Author: updated.toProto()}, nil
+}
+
+// authorsFingerprint is the query of every author page token, there are no filters.
+const authorsFingerprint = "authors"
+
+func (s AuthorServiceServer) ListAuthors(req *blogpb.ListAuthorsReq, stream blogpb.AuthorService_ListAuthorsServer) error {
+	p, err := s.pageTokens.newPage(req.GetPageSize(), s.defaultPageSize, s.maxPageSize, req.GetPageToken(),
+		authorsFingerprint, "was issued for another list")
+	if err != nil {
+		return err
+	}
+	query := AuthorQuery{Limit: p.limit()}
+	if p.start != nil {
+		query.After = p.start.Key
+	}
+
+	var last *AuthorItem
+	err = s.store.ListAuthors(stream.Context(), query, func(data *AuthorItem) error {
+		if !p.add() {
+			return nil
+		}
+		last = data
+		return stream.Send(&blogpb.ListAuthorsRes{Author: data.toProto()})
+	})
+	if err != nil {
+		return storeError(err, "Could not list authors")
+	}
+
+	token, err := p.nextToken(func() *pageToken { return &pageToken{Key: last.ID} })
+	if err != nil || token == "" {
+		return err
+	}
+	return stream.Send(&blogpb.ListAuthorsRes{NextPageToken: token})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/status"
+)
+
+// batchCreateSize is the number of blogs BatchCreateBlogs hands to the store at once.
+const batchCreateSize = 100
+
+// pendingBlog is a checked blog of a BatchCreateBlogs stream waiting for the next batch.
+type pendingBlog struct {
+	data   *BlogItem
+	slugs  []string
+	result *blogpb.BatchCreateResult
+}
+
+// BatchCreateBlogs creates the streamed blogs like CreateBlog does, but stores them in batches
+// of batchCreateSize instead of one by one. Every blog gets a result, failing blogs don't
+// abort the stream. Only a broken stream fails the RPC, the blogs stored until then stay.
+func (s BlogServiceServer) BatchCreateBlogs(stream blogpb.BlogService_BatchCreateBlogsServer) error {
+	ctx := stream.Context()
+	res := &blogpb.BatchCreateBlogsRes{}
+	var pending []*pendingBlog
+	// Imports usually have a handful of authors for thousands of blogs, each is looked up once.
+	authors := make(map[string]error)
+
+	for index := int32(0); ; index++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		result := &blogpb.BatchCreateResult{Index: index}
+		res.Results = append(res.Results, result)
+
+		blog := req.GetBlog()
+		// The validation layer leaves the blogs of a batch alone, they are checked one by one here.
+		v := &fieldViolations{}
+		validateNewBlog(v, "blog", blog)
+		if err := v.err(); err != nil {
+			setFailure(result, err)
+			continue
+		}
+		data, slugs, err := newBlogItem(blog)
+		if err != nil {
+			setFailure(result, err)
+			continue
+		}
+		authorErr, checked := authors[blog.GetAuthorId()]
+		if !checked {
+			authorErr = checkAuthor(ctx, s.store, blog.GetAuthorId())
+			authors[blog.GetAuthorId()] = authorErr
+		}
+		if authorErr != nil {
+			setFailure(result, authorErr)
+			continue
+		}
+
+		pending = append(pending, &pendingBlog{data: data, slugs: slugs, result: result})
+		if len(pending) == batchCreateSize {
+			s.createBatch(ctx, pending)
+			pending = pending[:0]
+		}
+	}
+	s.createBatch(ctx, pending)
+
+	for _, result := range res.Results {
+		if result.GetBlog() != nil {
+			res.Created++
+		} else {
+			res.Failed++
+		}
+	}
+	return stream.SendAndClose(res)
+}
+
+// createBatch stores a batch of blogs and fills in their results.
+func (s BlogServiceServer) createBatch(ctx context.Context, pending []*pendingBlog) {
+	if len(pending) == 0 {
+		return
+	}
+	items := make([]*BlogItem, len(pending))
+	for i, p := range pending {
+		p.data.Slug = p.slugs[0]
+		p.data.Slugs = []string{p.slugs[0]}
+		items[i] = p.data
+	}
+	created, errs := s.store.CreateMany(ctx, items)
+
+	for i, p := range pending {
+		result, err := created[i], errs[i]
+		if err == ErrSlugTaken && len(p.slugs) > 1 {
+			// The slug made from the title is taken, maybe by another blog of the batch,
+			// so the blog goes on its own with the next candidates like in CreateBlog.
+			result, err = firstFreeSlug(p.slugs[1:], func(slug string) (*BlogItem, error) {
+				p.data.Slug = slug
+				p.data.Slugs = []string{slug}
+				return s.store.Create(ctx, p.data)
+			})
+		}
+		if err != nil {
+			setFailure(p.result, createError(p.data.Slug, err))
+			continue
+		}
+		s.search.Add(result)
+		s.feed.publish(blogpb.BlogEventType_CREATED, result)
+		p.result.Blog = result.toProto()
+	}
+}
+
+// setFailure records why a blog of the batch wasn't created.
+func setFailure(result *blogpb.BatchCreateResult, err error) {
+	st := status.Convert(err)
+	result.Code = int32(st.Code())
+	result.Message = st.Message()
+}
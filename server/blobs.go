@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrBlobNotFound is returned by a BlobStore when it has no content with the given hash.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps the content of the attachments, addressed by its hex encoded SHA-256.
+// Content is immutable and stored once however many attachments share it.
+// Nothing is ever removed, content whose attachments are gone stays behind.
+type BlobStore interface {
+	// Has reports whether content with the given hash is stored.
+	Has(ctx context.Context, hash string) (bool, error)
+	// Create starts storing new content that is expected to have the given hash.
+	// It only becomes visible once the writer is committed.
+	Create(ctx context.Context, hash string) (BlobWriter, error)
+	// Open returns a reader for the content with the given hash or ErrBlobNotFound.
+	Open(ctx context.Context, hash string) (io.ReadCloser, error)
+}
+
+// BlobWriter receives new content for a BlobStore.
+type BlobWriter interface {
+	io.Writer
+	// Commit stores the content under its hash, which the caller has verified.
+	Commit() error
+	// Abort discards the content.
+	Abort()
+}
+
+// newBlobStore opens the attachment storage selected by the configuration.
+// GridFS shares the database of the mongo store.
+func newBlobStore(cfg AttachmentsConfig, store BlogStore, storeCfg StoreConfig) (BlobStore, error) {
+	switch cfg.Storage {
+	case "disk":
+		fmt.Printf("Storing attachments in %s\n", cfg.Dir)
+		return NewDiskBlobStore(cfg.Dir)
+	case "gridfs":
+		mongoStore, ok := store.(*MongoStore)
+		if !ok {
+			return nil, errors.New("gridfs requires the mongo store")
+		}
+		fmt.Println("Storing attachments in GridFS")
+		return NewGridFSBlobStore(mongoStore.database(), storeCfg.MongoCollection+"_blobs")
+	default:
+		return nil, fmt.Errorf("unknown attachment storage %q", cfg.Storage)
+	}
+}
+
+// DiskBlobStore keeps every blob in a file named by its hash below dir,
+// in subdirectories by the first two characters of the hash so no directory gets huge.
+type DiskBlobStore struct {
+	dir string
+}
+
+// NewDiskBlobStore creates dir if needed.
+func NewDiskBlobStore(dir string) (*DiskBlobStore, error) {
+	// Uploads are written next to the blobs, so committing them is a rename within the same file system.
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0755); err != nil {
+		return nil, err
+	}
+	return &DiskBlobStore{dir: dir}, nil
+}
+
+func (d *DiskBlobStore) path(hash string) string {
+	return filepath.Join(d.dir, hash[:2], hash)
+}
+
+func (d *DiskBlobStore) Has(ctx context.Context, hash string) (bool, error) {
+	_, err := os.Stat(d.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *DiskBlobStore) Create(ctx context.Context, hash string) (BlobWriter, error) {
+	tmp, err := os.CreateTemp(filepath.Join(d.dir, "tmp"), hash+"-*")
+	if err != nil {
+		return nil, err
+	}
+	return &diskBlobWriter{File: tmp, path: d.path(hash)}, nil
+}
+
+func (d *DiskBlobStore) Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	file, err := os.Open(d.path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return file, err
+}
+
+// diskBlobWriter writes to a temporary file that is renamed to the blob on commit.
+type diskBlobWriter struct {
+	*os.File
+	path string
+}
+
+func (w *diskBlobWriter) Commit() error {
+	if err := w.Sync(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	// Another upload of the same content may have won the race, both are the same bytes.
+	if err := os.Rename(w.Name(), w.path); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	syncDir(filepath.Dir(w.path))
+	return nil
+}
+
+func (w *diskBlobWriter) Abort() {
+	w.Close()
+	os.Remove(w.Name())
+}
+
+// GridFSBlobStore keeps the blobs in a GridFS bucket of the blogs database, each file named by its hash.
+type GridFSBlobStore struct {
+	bucket *gridfs.Bucket
+}
+
+// NewGridFSBlobStore uses the bucket with the given name, its collections are <name>.files and <name>.chunks.
+func NewGridFSBlobStore(db *mongo.Database, name string) (*GridFSBlobStore, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(name))
+	if err != nil {
+		return nil, err
+	}
+	return &GridFSBlobStore{bucket: bucket}, nil
+}
+
+func (g *GridFSBlobStore) Has(ctx context.Context, hash string) (bool, error) {
+	n, err := g.bucket.GetFilesCollection().CountDocuments(ctx, bson.M{"filename": hash}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (g *GridFSBlobStore) Create(ctx context.Context, hash string) (BlobWriter, error) {
+	// The file only shows up in the bucket once the stream is closed.
+	stream, err := g.bucket.OpenUploadStream(hash)
+	if err != nil {
+		return nil, err
+	}
+	return &gridFSBlobWriter{stream}, nil
+}
+
+func (g *GridFSBlobStore) Open(ctx context.Context, hash string) (io.ReadCloser, error) {
+	stream, err := g.bucket.OpenDownloadStreamByName(hash)
+	if err == gridfs.ErrFileNotFound {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+type gridFSBlobWriter struct {
+	*gridfs.UploadStream
+}
+
+func (w *gridFSBlobWriter) Commit() error {
+	return w.Close()
+}
+
+func (w *gridFSBlobWriter) Abort() {
+	// Removes the chunks written so far.
+	w.UploadStream.Abort()
+}
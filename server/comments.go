@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCommentNotFound is returned by a CommentStore when no comment matches the given ID.
+var ErrCommentNotFound = errors.New("comment not found")
+
+// maxCommentLength is the most characters a comment may have.
+const maxCommentLength = 5000
+
+// CommentStore is the storage behind CommentServiceServer, every BlogStore is one.
+// Comments belong to a blog, BlogStore.Delete removes them together with the blog.
+type CommentStore interface {
+	// CreateComment inserts a new comment, the ID is generated by the store and set on the returned item.
+	CreateComment(ctx context.Context, item *CommentItem) (*CommentItem, error)
+	// ReadComment returns the comment with the given ID or ErrCommentNotFound.
+	ReadComment(ctx context.Context, id primitive.ObjectID) (*CommentItem, error)
+	// UpdateComment copies the given fields (see commentFields) and UpdateTime from item
+	// to the existing comment and returns the updated comment.
+	UpdateComment(ctx context.Context, item *CommentItem, fields []string) (*CommentItem, error)
+	// DeleteComment removes the comment with the given ID, its replies are left alone.
+	DeleteComment(ctx context.Context, id primitive.ObjectID) error
+	// ListComments calls fn for every comment selected by q in the order they were created,
+	// stopping at the first error.
+	ListComments(ctx context.Context, q CommentQuery, fn func(*CommentItem) error) error
+}
+
+type CommentItem struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty"`
+	BlogID primitive.ObjectID `bson:"blog_id"`
+	// ParentID is the comment this one replies to, NilObjectID for comments on the blog itself.
+	ParentID primitive.ObjectID `bson:"parent_id"`
+	AuthorID string             `bson:"author_id"`
+	Content  string             `bson:"content"`
+	// Managed by the server, with millisecond precision like the blog timestamps.
+	CreateTime time.Time `bson:"create_time"`
+	UpdateTime time.Time `bson:"update_time"`
+	// DeleteTime is set when a comment with replies is deleted, it stays as a placeholder.
+	DeleteTime time.Time `bson:"delete_time,omitempty"`
+}
+
+// commentFields are the fields UpdateComment can change, named like in the BSON and SQL.
+var commentFields = []string{"author_id", "content", "delete_time"}
+
+// applyCommentFields copies the given fields from src to dst.
+func applyCommentFields(dst, src *CommentItem, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "author_id":
+			dst.AuthorID = src.AuthorID
+		case "content":
+			dst.Content = src.Content
+		case "delete_time":
+			dst.DeleteTime = src.DeleteTime
+		}
+	}
+}
+
+// fieldValue returns the value of one of the commentFields, or nil for any other field.
+func (c *CommentItem) fieldValue(field string) interface{} {
+	switch field {
+	case "author_id":
+		return c.AuthorID
+	case "content":
+		return c.Content
+	case "delete_time":
+		return c.DeleteTime
+	}
+	return nil
+}
+
+// toProto converts a stored CommentItem into its protobuf counterpart.
+func (c *CommentItem) toProto() *blogpb.Comment {
+	comment := &blogpb.Comment{
+		Id:         c.ID.Hex(),
+		BlogId:     c.BlogID.Hex(),
+		AuthorId:   c.AuthorID,
+		Content:    c.Content,
+		CreateTime: timestampOrNil(c.CreateTime),
+		UpdateTime: timestampOrNil(c.UpdateTime),
+		Deleted:    !c.DeleteTime.IsZero(),
+	}
+	if !c.ParentID.IsZero() {
+		comment.ParentId = c.ParentID.Hex()
+	}
+	return comment
+}
+
+// CommentQuery selects the comments returned by CommentStore.ListComments.
+type CommentQuery struct {
+	BlogID primitive.ObjectID
+	// Only the direct replies to ParentID, NilObjectID selects every comment of the blog.
+	ParentID primitive.ObjectID
+
+	// The cursor of keyset pagination: the comment with ID After and every comment before it are skipped.
+	// NilObjectID starts at the first comment.
+	After primitive.ObjectID
+	// Limit is the maximum number of comments, 0 means no limit.
+	Limit int
+}
+
+// matches reports whether c passes the filters of q, ignoring the cursor.
+func (q *CommentQuery) matches(c *CommentItem) bool {
+	if c.BlogID != q.BlogID {
+		return false
+	}
+	return q.ParentID.IsZero() || c.ParentID == q.ParentID
+}
+
+// fingerprint identifies the filters of q, a page token is only valid for the same ones.
+func (q *CommentQuery) fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "comments %s %s", q.BlogID.Hex(), q.ParentID.Hex())
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// listCommentsInOrder filters and sorts a snapshot of comments and calls fn for the ones selected by q.
+// Stores that keep everything in memory share it.
+func listCommentsInOrder(ctx context.Context, items []CommentItem, q CommentQuery, fn func(*CommentItem) error) error {
+	matching := items[:0]
+	for _, c := range items {
+		if q.matches(&c) && (q.After.IsZero() || bytes.Compare(c.ID[:], q.After[:]) > 0) {
+			matching = append(matching, c)
+		}
+	}
+	items = matching
+
+	// ObjectIDs start with their creation time, sorting by them gives the order comments were written in.
+	sort.Slice(items, func(i, j int) bool {
+		return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0
+	})
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+
+	for i := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommentServiceServer implements blogpb.CommentServiceServer on top of the same store as the blogs.
+type CommentServiceServer struct {
+	store BlogStore
+
+	pageTokens      *pageTokenCodec
+	defaultPageSize int
+	maxPageSize     int
+}
+
+// validateCommentContent checks the content of a new or updated comment.
+func validateCommentContent(content string) error {
+	if strings.TrimSpace(content) == "" {
+		return errors.New("must not be empty")
+	}
+	if !utf8.ValidString(content) {
+		return errors.New("must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(content) > maxCommentLength {
+		return fmt.Errorf("is longer than %d characters", maxCommentLength)
+	}
+	return nil
+}
+
+// readLiveBlog returns the blog with the given ID as a gRPC error if it doesn't exist or is in the trash,
+// the comments and attachments of trashed blogs are hidden with them.
+func readLiveBlog(ctx context.Context, store BlogStore, id primitive.ObjectID) (*BlogItem, error) {
+	data, err := store.Read(ctx, id)
+	if err == nil && !data.DeleteTime.IsZero() {
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find blog with Object Id %s", id.Hex())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read blog %s", id.Hex())
+	}
+	return data, nil
+}
+
+// readComment returns the comment with the given hex ID, the errors are gRPC errors.
+func (s CommentServiceServer) readComment(ctx context.Context, id string) (*CommentItem, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	data, err := s.store.ReadComment(ctx, oid)
+	if err == ErrCommentNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find comment with Object Id %s", id)
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read comment %s", id)
+	}
+	return data, nil
+}
+
+func (s CommentServiceServer) CreateComment(ctx context.Context, req *blogpb.CreateCommentReq) (*blogpb.CreateCommentRes, error) {
+	comment := req.GetComment()
+	blogID, err := primitive.ObjectIDFromHex(comment.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert blog_id to ObjectId: %v", err)
+	}
+
+	blog, err := readLiveBlog(ctx, s.store, blogID)
+	if err != nil {
+		return nil, err
+	}
+	if blog.status() != StatusPublished {
+		return nil, status.Errorf(codes.FailedPrecondition, "Blog %s is %s, only published blogs can be commented on", blogID.Hex(), blog.status())
+	}
+
+	// A reply must be to a comment of the same blog that is still there.
+	var parentID primitive.ObjectID
+	if comment.GetParentId() != "" {
+		parent, err := s.readComment(ctx, comment.GetParentId())
+		if err != nil {
+			return nil, err
+		}
+		if parent.BlogID != blogID {
+			return nil, invalidField("comment.parent_id", "comment %s is not a comment of blog %s", parent.ID.Hex(), blogID.Hex())
+		}
+		if !parent.DeleteTime.IsZero() {
+			return nil, status.Errorf(codes.FailedPrecondition, "Comment %s has been deleted", parent.ID.Hex())
+		}
+		parentID = parent.ID
+	}
+
+	created := now()
+	result, err := s.store.CreateComment(ctx, &CommentItem{
+		BlogID:     blogID,
+		ParentID:   parentID,
+		AuthorID:   comment.GetAuthorId(),
+		Content:    comment.GetContent(),
+		CreateTime: created,
+		UpdateTime: created,
+	})
+	if err != nil {
+		return nil, storeError(err, "Could not create comment")
+	}
+	return &blogpb.CreateCommentRes{Comment: result.toProto()}, nil
+}
+
+func (s CommentServiceServer) UpdateComment(ctx context.Context, req *blogpb.UpdateCommentReq) (*blogpb.UpdateCommentRes, error) {
+	data, err := s.readComment(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if !data.DeleteTime.IsZero() {
+		return nil, status.Errorf(codes.FailedPrecondition, "Comment %s has been deleted", req.GetId())
+	}
+	if _, err := readLiveBlog(ctx, s.store, data.BlogID); err != nil {
+		return nil, err
+	}
+
+	updated, err := s.store.UpdateComment(ctx, &CommentItem{ID: data.ID, Content: req.GetContent(), UpdateTime: now()}, []string{"content"})
+	if err == ErrCommentNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find comment with Object Id %s", req.GetId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not update comment %s", req.GetId())
+	}
+	return &blogpb.UpdateCommentRes{Comment: updated.toProto()}, nil
+}
+
+func (s CommentServiceServer) DeleteComment(ctx context.Context, req *blogpb.DeleteCommentReq) (*blogpb.DeleteCommentRes, error) {
+	data, err := s.readComment(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readLiveBlog(ctx, s.store, data.BlogID); err != nil {
+		return nil, err
+	}
+	if !data.DeleteTime.IsZero() {
+		// Already deleted, deleting again is a no-op.
+		return &blogpb.DeleteCommentRes{}, nil
+	}
+
+	// A comment without replies just goes away. One with replies keeps its place in
+	// the thread as a placeholder, otherwise the replies would answer nothing.
+	hasReplies := false
+	err = s.store.ListComments(ctx, CommentQuery{BlogID: data.BlogID, ParentID: data.ID, Limit: 1}, func(*CommentItem) error {
+		hasReplies = true
+		return nil
+	})
+	if err == nil && hasReplies {
+		deleted := now()
+		_, err = s.store.UpdateComment(ctx, &CommentItem{ID: data.ID, DeleteTime: deleted, UpdateTime: deleted}, commentFields)
+	} else if err == nil {
+		err = s.store.DeleteComment(ctx, data.ID)
+	}
+	if err != nil && err != ErrCommentNotFound {
+		return nil, storeError(err, "Could not delete comment %s", req.GetId())
+	}
+	return &blogpb.DeleteCommentRes{}, nil
+}
+
+func (s CommentServiceServer) ListComments(req *blogpb.ListCommentsReq, stream blogpb.CommentService_ListCommentsServer) error {
+	blogID, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "Could not convert blog_id to ObjectId: %v", err)
+	}
+	query := CommentQuery{BlogID: blogID}
+	if req.GetParentId() != "" {
+		query.ParentID, err = primitive.ObjectIDFromHex(req.GetParentId())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "Could not convert parent_id to ObjectId: %v", err)
+		}
+	}
+	if _, err := readLiveBlog(stream.Context(), s.store, blogID); err != nil {
+		return err
+	}
+
+	p, err := s.pageTokens.newPage(req.GetPageSize(), s.defaultPageSize, s.maxPageSize, req.GetPageToken(),
+		query.fingerprint(), "was issued for a different blog_id or parent_id")
+	if err != nil {
+		return err
+	}
+	query.Limit = p.limit()
+	if p.start != nil {
+		query.After, _ = primitive.ObjectIDFromHex(p.start.After)
+	}
+
+	var last *CommentItem
+	err = s.store.ListComments(stream.Context(), query, func(data *CommentItem) error {
+		if !p.add() {
+			return nil
+		}
+		last = data
+		return stream.Send(&blogpb.ListCommentsRes{Comment: data.toProto()})
+	})
+	if err != nil {
+		return storeError(err, "Could not list comments")
+	}
+
+	token, err := p.nextToken(func() *pageToken { return &pageToken{After: last.ID.Hex()} })
+	if err != nil || token == "" {
+		return err
+	}
+	return stream.Send(&blogpb.ListCommentsRes{NextPageToken: token})
+}
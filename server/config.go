@@ -0,0 +1,457 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the effective server configuration.
+//
+// Every setting can come from (lowest to highest precedence):
+// built-in defaults, the YAML config file, BLOG_* environment variables and command-line flags.
+type Config struct {
+	// Listen is the TCP address the gRPC server listens on.
+	Listen      string            `yaml:"listen"`
+	Store       StoreConfig       `yaml:"store"`
+	Timeouts    TimeoutsConfig    `yaml:"timeouts"`
+	Limits      LimitsConfig      `yaml:"limits"`
+	List        ListConfig        `yaml:"list"`
+	Scheduler   SchedulerConfig   `yaml:"scheduler"`
+	Trash       TrashConfig       `yaml:"trash"`
+	Watch       WatchConfig       `yaml:"watch"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Render      RenderConfig      `yaml:"render"`
+	Attachments AttachmentsConfig `yaml:"attachments"`
+}
+
+type StoreConfig struct {
+	// Kind is the storage backend: mongo, memory, file or sqlite.
+	Kind string `yaml:"kind"`
+	// Path is the data file of the file and sqlite stores.
+	Path            string `yaml:"path"`
+	MongoURI        string `yaml:"mongo_uri"`
+	MongoDatabase   string `yaml:"mongo_database"`
+	MongoCollection string `yaml:"mongo_collection"`
+}
+
+type TimeoutsConfig struct {
+	// Connect bounds opening the store, e.g. connecting to and pinging MongoDB.
+	Connect Duration `yaml:"connect"`
+	// Shutdown is how long in-flight RPCs get to finish after CTRL+C before they are cut off.
+	Shutdown Duration `yaml:"shutdown"`
+	// RPC are the deadlines of every RPC, unless Methods has an entry for it.
+	RPC RPCTimeouts `yaml:"rpc"`
+	// Methods holds the deadlines of single RPCs by method name, e.g. UploadAttachment.
+	// An entry replaces the built-in one of the method as a whole.
+	Methods map[string]RPCTimeouts `yaml:"methods"`
+}
+
+// RPCTimeouts bound how long an RPC may run, 0 means no limit.
+type RPCTimeouts struct {
+	// Default is the deadline of an RPC the client set none for.
+	Default Duration `yaml:"default"`
+	// Max is the latest deadline an RPC may have, later client deadlines are cut down to it.
+	Max Duration `yaml:"max"`
+}
+
+type LimitsConfig struct {
+	// Maximum size in bytes of a single gRPC message received or sent by the server.
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize int `yaml:"max_send_msg_size"`
+}
+
+type ListConfig struct {
+	// Page size of ListBlogs when the request doesn't set one, and the largest page size allowed.
+	DefaultPageSize int `yaml:"default_page_size"`
+	MaxPageSize     int `yaml:"max_page_size"`
+	// PageTokenSecret signs page tokens. Set it when running several servers behind a load balancer
+	// or to keep tokens valid across restarts, otherwise a random secret is used.
+	PageTokenSecret string `yaml:"page_token_secret"`
+}
+
+type SchedulerConfig struct {
+	// Interval is how often scheduled blogs are checked for being due, i.e. how late they may be published.
+	Interval Duration `yaml:"interval"`
+}
+
+type TrashConfig struct {
+	// Retention is how long deleted blogs stay in the trash before they are purged, 0 keeps them forever.
+	Retention Duration `yaml:"retention"`
+}
+
+type WatchConfig struct {
+	// History is how many of the latest changes are kept for WatchBlogs clients resuming with a resume token.
+	History int `yaml:"history"`
+}
+
+type IdempotencyConfig struct {
+	// Window is how long CreateBlog remembers an idempotency key and the blog it created.
+	Window Duration `yaml:"window"`
+}
+
+type RenderConfig struct {
+	// CacheSize is how many rendered blog versions RenderBlog keeps.
+	CacheSize int `yaml:"cache_size"`
+}
+
+type AttachmentsConfig struct {
+	// Storage is where the attachment bytes go: disk, or gridfs next to the blogs with the mongo store.
+	Storage string `yaml:"storage"`
+	// Dir is the directory of the disk storage.
+	Dir string `yaml:"dir"`
+	// MaxSize is the largest attachment in bytes UploadAttachment accepts.
+	MaxSize int `yaml:"max_size"`
+}
+
+// Duration is a time.Duration written as "10s", "1m30s", ... in the config file.
+type Duration time.Duration
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		// 50051 is the default port for gRPC
+		Listen: ":50051",
+		Store: StoreConfig{
+			Kind:            "mongo",
+			Path:            "blog.db",
+			MongoURI:        "mongodb://localhost:27017",
+			MongoDatabase:   "test",
+			MongoCollection: "blog",
+		},
+		Timeouts: TimeoutsConfig{
+			Connect:  Duration(10 * time.Second),
+			Shutdown: Duration(10 * time.Second),
+			RPC: RPCTimeouts{
+				Default: Duration(30 * time.Second),
+				Max:     Duration(5 * time.Minute),
+			},
+			Methods: map[string]RPCTimeouts{
+				// Watches run for as long as the client wants them.
+				"WatchBlogs": {},
+				// Imports and attachments move more data than the other RPCs.
+				"BatchCreateBlogs":   {Default: Duration(5 * time.Minute), Max: Duration(30 * time.Minute)},
+				"UploadAttachment":   {Default: Duration(10 * time.Minute), Max: Duration(time.Hour)},
+				"DownloadAttachment": {Default: Duration(10 * time.Minute), Max: Duration(time.Hour)},
+			},
+		},
+		Limits: LimitsConfig{
+			// Same as the gRPC defaults.
+			MaxRecvMsgSize: 4 << 20,
+			MaxSendMsgSize: 4 << 20,
+		},
+		List: ListConfig{
+			DefaultPageSize: 100,
+			MaxPageSize:     1000,
+		},
+		Scheduler: SchedulerConfig{
+			Interval: Duration(10 * time.Second),
+		},
+		Trash: TrashConfig{
+			Retention: Duration(30 * 24 * time.Hour),
+		},
+		Watch: WatchConfig{
+			History: 1000,
+		},
+		Idempotency: IdempotencyConfig{
+			Window: Duration(24 * time.Hour),
+		},
+		Render: RenderConfig{
+			CacheSize: 1000,
+		},
+		Attachments: AttachmentsConfig{
+			Storage: "disk",
+			Dir:     "attachments",
+			MaxSize: 10 << 20,
+		},
+	}
+}
+
+// setting ties one config field to its command-line flag and environment variable.
+type setting struct {
+	flag  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, v string) error
+}
+
+// env is the environment variable of a setting, --mongo-uri is read from BLOG_MONGO_URI.
+func (s setting) env() string {
+	return "BLOG_" + strings.ToUpper(strings.Replace(s.flag, "-", "_", -1))
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{
+		flag:  name,
+		usage: usage,
+		get:   func(c *Config) string { return *field(c) },
+		set:   func(c *Config, v string) error { *field(c) = v; return nil },
+	}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) setting {
+	return setting{
+		flag:  name,
+		usage: usage,
+		get:   func(c *Config) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("not a number: %q", v)
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *Duration) setting {
+	return setting{
+		flag:  name,
+		usage: usage,
+		get:   func(c *Config) string { return time.Duration(*field(c)).String() },
+		set: func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*field(c) = Duration(d)
+			return nil
+		},
+	}
+}
+
+var settings = []setting{
+	stringSetting("listen", "address the gRPC server listens on", func(c *Config) *string { return &c.Listen }),
+	stringSetting("store", "storage backend to use: mongo, memory, file or sqlite", func(c *Config) *string { return &c.Store.Kind }),
+	stringSetting("path", "path of the data file used by the file and sqlite stores", func(c *Config) *string { return &c.Store.Path }),
+	stringSetting("mongo-uri", "MongoDB connection URI", func(c *Config) *string { return &c.Store.MongoURI }),
+	stringSetting("mongo-database", "MongoDB database name", func(c *Config) *string { return &c.Store.MongoDatabase }),
+	stringSetting("mongo-collection", "MongoDB collection holding the blogs", func(c *Config) *string { return &c.Store.MongoCollection }),
+	durationSetting("connect-timeout", "timeout for opening the store", func(c *Config) *Duration { return &c.Timeouts.Connect }),
+	durationSetting("shutdown-timeout", "time given to in-flight RPCs on shutdown", func(c *Config) *Duration { return &c.Timeouts.Shutdown }),
+	durationSetting("rpc-timeout", "deadline of RPCs the client set none for, 0 for none", func(c *Config) *Duration { return &c.Timeouts.RPC.Default }),
+	durationSetting("max-rpc-timeout", "latest deadline an RPC may have, 0 for no limit", func(c *Config) *Duration { return &c.Timeouts.RPC.Max }),
+	intSetting("max-recv-msg-size", "maximum size in bytes of a received gRPC message", func(c *Config) *int { return &c.Limits.MaxRecvMsgSize }),
+	intSetting("max-send-msg-size", "maximum size in bytes of a sent gRPC message", func(c *Config) *int { return &c.Limits.MaxSendMsgSize }),
+	intSetting("default-page-size", "ListBlogs page size when the request has none", func(c *Config) *int { return &c.List.DefaultPageSize }),
+	intSetting("max-page-size", "largest ListBlogs page size", func(c *Config) *int { return &c.List.MaxPageSize }),
+	stringSetting("page-token-secret", "secret signing ListBlogs page tokens, random if empty", func(c *Config) *string { return &c.List.PageTokenSecret }),
+	durationSetting("publish-interval", "how often scheduled blogs are checked for being due", func(c *Config) *Duration { return &c.Scheduler.Interval }),
+	durationSetting("trash-retention", "how long deleted blogs stay in the trash, 0 keeps them forever", func(c *Config) *Duration { return &c.Trash.Retention }),
+	intSetting("watch-history", "number of latest changes kept for WatchBlogs clients to resume from", func(c *Config) *int { return &c.Watch.History }),
+	durationSetting("idempotency-window", "how long CreateBlog remembers an idempotency key", func(c *Config) *Duration { return &c.Idempotency.Window }),
+	intSetting("render-cache-size", "number of rendered blog versions kept by RenderBlog", func(c *Config) *int { return &c.Render.CacheSize }),
+	stringSetting("attachment-storage", "where attachments are stored: disk or gridfs", func(c *Config) *string { return &c.Attachments.Storage }),
+	stringSetting("attachment-dir", "directory of the disk attachment storage", func(c *Config) *string { return &c.Attachments.Dir }),
+	intSetting("max-attachment-size", "largest attachment in bytes accepted by UploadAttachment", func(c *Config) *int { return &c.Attachments.MaxSize }),
+}
+
+// loadConfig builds the effective configuration from the command-line arguments,
+// the environment and the config file named by --config or BLOG_CONFIG.
+// printConfig reports whether --print-config was given.
+func loadConfig(args []string) (cfg *Config, printConfig bool, err error) {
+	cfg = defaultConfig()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("BLOG_CONFIG"), "path of a YAML config file (env BLOG_CONFIG)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, s.get(cfg), fmt.Sprintf("%s (env %s)", s.usage, s.env()))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	if *configPath != "" {
+		if err := readConfigFile(*configPath, cfg); err != nil {
+			return nil, false, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, false, fmt.Errorf("%s: %v", s.env(), err)
+			}
+		}
+	}
+
+	// Only flags given on the command line override, the others still hold the defaults.
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(cfg, *values[s.flag]); err != nil {
+					flagErr = fmt.Errorf("--%s: %v", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, false, flagErr
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+func readConfigFile(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := yaml.NewDecoder(file)
+	// Catch typos like "mongo_url" instead of silently ignoring them.
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once, so they can all be fixed in one go.
+func (c *Config) validate() error {
+	var problems []string
+
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problems = append(problems, fmt.Sprintf("listen: %v", err))
+	}
+	switch c.Store.Kind {
+	case "mongo":
+		if c.Store.MongoURI == "" {
+			problems = append(problems, "store.mongo_uri: required by the mongo store")
+		}
+		if c.Store.MongoDatabase == "" || c.Store.MongoCollection == "" {
+			problems = append(problems, "store.mongo_database and store.mongo_collection: required by the mongo store")
+		}
+	case "file", "sqlite":
+		if c.Store.Path == "" {
+			problems = append(problems, fmt.Sprintf("store.path: required by the %s store", c.Store.Kind))
+		}
+	case "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("store.kind: unknown store %q, want mongo, memory, file or sqlite", c.Store.Kind))
+	}
+	if c.Timeouts.Connect <= 0 {
+		problems = append(problems, "timeouts.connect: must be positive")
+	}
+	if c.Timeouts.Shutdown < 0 {
+		problems = append(problems, "timeouts.shutdown: must not be negative")
+	}
+	problems = append(problems, c.Timeouts.RPC.validate("timeouts.rpc")...)
+	methods := make([]string, 0, len(c.Timeouts.Methods))
+	for method := range c.Timeouts.Methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		problems = append(problems, c.Timeouts.Methods[method].validate("timeouts.methods."+method)...)
+	}
+	if c.Limits.MaxRecvMsgSize <= 0 {
+		problems = append(problems, "limits.max_recv_msg_size: must be positive")
+	}
+	if c.Limits.MaxSendMsgSize <= 0 {
+		problems = append(problems, "limits.max_send_msg_size: must be positive")
+	}
+
+	if c.List.MaxPageSize <= 0 {
+		problems = append(problems, "list.max_page_size: must be positive")
+	}
+	if c.List.DefaultPageSize <= 0 || c.List.DefaultPageSize > c.List.MaxPageSize {
+		problems = append(problems, "list.default_page_size: must be positive and at most list.max_page_size")
+	}
+	if c.Scheduler.Interval <= 0 {
+		problems = append(problems, "scheduler.interval: must be positive")
+	}
+	if c.Trash.Retention < 0 {
+		problems = append(problems, "trash.retention: must not be negative")
+	}
+	if c.Watch.History <= 0 {
+		problems = append(problems, "watch.history: must be positive")
+	}
+	if c.Idempotency.Window <= 0 {
+		problems = append(problems, "idempotency.window: must be positive")
+	}
+	if c.Render.CacheSize <= 0 {
+		problems = append(problems, "render.cache_size: must be positive")
+	}
+	switch c.Attachments.Storage {
+	case "disk":
+		if c.Attachments.Dir == "" {
+			problems = append(problems, "attachments.dir: required by the disk storage")
+		}
+	case "gridfs":
+		if c.Store.Kind != "mongo" {
+			problems = append(problems, "attachments.storage: gridfs requires the mongo store")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("attachments.storage: unknown storage %q, want disk or gridfs", c.Attachments.Storage))
+	}
+	if c.Attachments.MaxSize <= 0 {
+		problems = append(problems, "attachments.max_size: must be positive")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (t RPCTimeouts) validate(name string) []string {
+	var problems []string
+	if t.Default < 0 || t.Max < 0 {
+		problems = append(problems, name+": must not be negative")
+	}
+	if t.Max > 0 && t.Default > t.Max {
+		problems = append(problems, name+".default: must be at most "+name+".max")
+	}
+	return problems
+}
+
+// print writes the configuration in the same YAML format the config file uses.
+// Secrets are masked.
+func (c *Config) print(w io.Writer) error {
+	masked := *c
+	if masked.List.PageTokenSecret != "" {
+		masked.List.PageTokenSecret = "********"
+	}
+	// The URI may carry the password of the database user.
+	if u, err := url.Parse(masked.Store.MongoURI); err == nil {
+		masked.Store.MongoURI = u.Redacted()
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&masked); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,795 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// The conformance suite runs every RPC against a server on an in-memory store and checks
+// the status code for each way it can fail: an invalid request, a missing document and
+// the store failing underneath it.
+
+// missingID is a well-formed ID no document has.
+const missingID = "5f0000000000000000000000"
+
+// faultyStore is a BlogStore that fails every call with err once it is set.
+type faultyStore struct {
+	BlogStore
+
+	mu  sync.Mutex
+	err error
+}
+
+func (f *faultyStore) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *faultyStore) fault() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *faultyStore) Create(ctx context.Context, item *BlogItem) (*BlogItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.Create(ctx, item)
+}
+
+func (f *faultyStore) CreateMany(ctx context.Context, items []*BlogItem) ([]*BlogItem, []error) {
+	if err := f.fault(); err != nil {
+		errs := make([]error, len(items))
+		for i := range errs {
+			errs[i] = err
+		}
+		return make([]*BlogItem, len(items)), errs
+	}
+	return f.BlogStore.CreateMany(ctx, items)
+}
+
+func (f *faultyStore) Read(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.Read(ctx, id)
+}
+
+func (f *faultyStore) ReadBySlug(ctx context.Context, slug string) (*BlogItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ReadBySlug(ctx, slug)
+}
+
+func (f *faultyStore) Update(ctx context.Context, item *BlogItem, fields []string, expectedVersion int64) (*BlogItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.Update(ctx, item, fields, expectedVersion)
+}
+
+func (f *faultyStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if err := f.fault(); err != nil {
+		return err
+	}
+	return f.BlogStore.Delete(ctx, id)
+}
+
+func (f *faultyStore) List(ctx context.Context, q ListQuery, fn func(*BlogItem) error) error {
+	if err := f.fault(); err != nil {
+		return err
+	}
+	return f.BlogStore.List(ctx, q, fn)
+}
+
+func (f *faultyStore) SaveRevision(ctx context.Context, rev *BlogItem) error {
+	if err := f.fault(); err != nil {
+		return err
+	}
+	return f.BlogStore.SaveRevision(ctx, rev)
+}
+
+func (f *faultyStore) ListRevisions(ctx context.Context, id primitive.ObjectID) ([]*BlogItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ListRevisions(ctx, id)
+}
+
+func (f *faultyStore) ReadRevision(ctx context.Context, id primitive.ObjectID, version int64) (*BlogItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ReadRevision(ctx, id, version)
+}
+
+func (f *faultyStore) ListTags(ctx context.Context) ([]TagCount, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ListTags(ctx)
+}
+
+func (f *faultyStore) CreateComment(ctx context.Context, item *CommentItem) (*CommentItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.CreateComment(ctx, item)
+}
+
+func (f *faultyStore) ReadComment(ctx context.Context, id primitive.ObjectID) (*CommentItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ReadComment(ctx, id)
+}
+
+func (f *faultyStore) UpdateComment(ctx context.Context, item *CommentItem, fields []string) (*CommentItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.UpdateComment(ctx, item, fields)
+}
+
+func (f *faultyStore) DeleteComment(ctx context.Context, id primitive.ObjectID) error {
+	if err := f.fault(); err != nil {
+		return err
+	}
+	return f.BlogStore.DeleteComment(ctx, id)
+}
+
+func (f *faultyStore) ListComments(ctx context.Context, q CommentQuery, fn func(*CommentItem) error) error {
+	if err := f.fault(); err != nil {
+		return err
+	}
+	return f.BlogStore.ListComments(ctx, q, fn)
+}
+
+func (f *faultyStore) CreateAuthor(ctx context.Context, item *AuthorItem) (*AuthorItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.CreateAuthor(ctx, item)
+}
+
+func (f *faultyStore) ReadAuthor(ctx context.Context, id string) (*AuthorItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ReadAuthor(ctx, id)
+}
+
+func (f *faultyStore) UpdateAuthor(ctx context.Context, item *AuthorItem, fields []string) (*AuthorItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.UpdateAuthor(ctx, item, fields)
+}
+
+func (f *faultyStore) ListAuthors(ctx context.Context, q AuthorQuery, fn func(*AuthorItem) error) error {
+	if err := f.fault(); err != nil {
+		return err
+	}
+	return f.BlogStore.ListAuthors(ctx, q, fn)
+}
+
+func (f *faultyStore) CreateAttachment(ctx context.Context, item *AttachmentItem) (*AttachmentItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.CreateAttachment(ctx, item)
+}
+
+func (f *faultyStore) ReadAttachment(ctx context.Context, id primitive.ObjectID) (*AttachmentItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ReadAttachment(ctx, id)
+}
+
+func (f *faultyStore) ListAttachments(ctx context.Context, blogID primitive.ObjectID) ([]*AttachmentItem, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	return f.BlogStore.ListAttachments(ctx, blogID)
+}
+
+// batchCreate creates one blog through BatchCreateBlogs, returning the error of its result.
+func (e *testEnv) batchCreate(ctx context.Context, blog *blogpb.Blog) error {
+	stream, err := e.blogs.BatchCreateBlogs(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&blogpb.BatchCreateBlogsReq{Blog: blog}); err != nil && err != io.EOF {
+		return err
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	result := res.GetResults()[0]
+	if result.GetBlog() == nil {
+		return status.Error(codes.Code(result.GetCode()), result.GetMessage())
+	}
+	return nil
+}
+
+// drain receives the messages of a server stream until it ends, nil if it ends without an error.
+func drain(recv func() error) error {
+	for {
+		err := recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// rpcCall makes one call of an RPC against the fixture of e.
+type rpcCall func(ctx context.Context, e *testEnv) error
+
+// rpcCase lists the calls of an RPC for each case, nil for the cases that don't apply.
+type rpcCase struct {
+	// method is the full name of the RPC, e.g. "blog.BlogService/CreateBlog".
+	method string
+	// valid succeeds, with the store working.
+	valid rpcCall
+	// missing refers to a document that doesn't exist.
+	missing rpcCall
+	// invalid breaks the validation rules of the request.
+	invalid rpcCall
+	// storeless RPCs never touch the store, so failures of the store don't apply.
+	storeless bool
+}
+
+func rpcCases() []rpcCase {
+	return []rpcCase{
+		// BlogService
+		{
+			method: "blog.BlogService/CreateBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("New")})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("")})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/ReadBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: e.blogID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: "nope"})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/ReadBlogBySlug",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ReadBlogBySlug(ctx, &blogpb.ReadBlogBySlugReq{Slug: e.blogSlug})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				// Longer than a client may choose, a lookup still only checks the form.
+				_, err := e.blogs.ReadBlogBySlug(ctx, &blogpb.ReadBlogBySlugReq{Slug: strings.Repeat("no-such-blog-", 5) + "2"})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ReadBlogBySlug(ctx, &blogpb.ReadBlogBySlugReq{Slug: "Not A Slug"})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/UpdateBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+					Blog:       &blogpb.Blog{Id: e.blogID, Content: "New content"},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"content"}},
+				})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+					Blog:       &blogpb.Blog{Id: missingID, Content: "New content"},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"content"}},
+				})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+					Blog:       &blogpb.Blog{Id: e.blogID},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"content"}},
+				})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/DeleteBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: e.blogID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/ListRevisions",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ListRevisions(ctx, &blogpb.ListRevisionsReq{BlogId: e.blogID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ListRevisions(ctx, &blogpb.ListRevisionsReq{BlogId: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ListRevisions(ctx, &blogpb.ListRevisionsReq{BlogId: "nope"})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/GetRevision",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.GetRevision(ctx, &blogpb.GetRevisionReq{BlogId: e.blogID, Number: 1})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.GetRevision(ctx, &blogpb.GetRevisionReq{BlogId: e.blogID, Number: 99})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.GetRevision(ctx, &blogpb.GetRevisionReq{BlogId: e.blogID})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/RevertBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RevertBlog(ctx, &blogpb.RevertBlogReq{BlogId: e.blogID, Number: 1})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RevertBlog(ctx, &blogpb.RevertBlogReq{BlogId: missingID, Number: 1})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RevertBlog(ctx, &blogpb.RevertBlogReq{BlogId: e.blogID, Number: 1, ExpectedVersion: -1})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/PublishBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.PublishBlog(ctx, &blogpb.PublishBlogReq{Id: e.blogID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.PublishBlog(ctx, &blogpb.PublishBlogReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.PublishBlog(ctx, &blogpb.PublishBlogReq{Id: "nope"})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/UnpublishBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{Id: e.blogID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{Id: "nope"})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/RestoreBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogReq{Id: e.trashedID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogReq{Id: "nope"})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/PurgeBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.PurgeBlog(ctx, &blogpb.PurgeBlogReq{Id: e.trashedID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.PurgeBlog(ctx, &blogpb.PurgeBlogReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.PurgeBlog(ctx, &blogpb.PurgeBlogReq{Id: "nope"})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/ListBlogs",
+			valid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.blogs.ListBlogs(ctx, &blogpb.ListBlogsReq{})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.blogs.ListBlogs(ctx, &blogpb.ListBlogsReq{OrderBy: "popularity"})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+		},
+		{
+			method: "blog.BlogService/ListDeletedBlogs",
+			valid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.blogs.ListDeletedBlogs(ctx, &blogpb.ListDeletedBlogsReq{})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.blogs.ListDeletedBlogs(ctx, &blogpb.ListDeletedBlogsReq{PageSize: -1})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+		},
+		{
+			method: "blog.BlogService/SearchBlogs",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.SearchBlogs(ctx, &blogpb.SearchBlogsReq{Query: "conformance"})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.SearchBlogs(ctx, &blogpb.SearchBlogsReq{})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/ListTags",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.ListTags(ctx, &blogpb.ListTagsReq{})
+				return err
+			},
+		},
+		{
+			method: "blog.BlogService/WatchBlogs",
+			valid: func(ctx context.Context, e *testEnv) error {
+				return e.watch(ctx, &blogpb.WatchBlogsReq{})
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				return e.watch(ctx, &blogpb.WatchBlogsReq{ResumeToken: "nope"})
+			},
+			storeless: true,
+		},
+		{
+			method: "blog.BlogService/BatchCreateBlogs",
+			valid: func(ctx context.Context, e *testEnv) error {
+				return e.batchCreate(ctx, e.newBlog("Batch"))
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				return e.batchCreate(ctx, e.newBlog(""))
+			},
+		},
+		{
+			method: "blog.BlogService/RenderBlog",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RenderBlog(ctx, &blogpb.RenderBlogReq{Id: e.blogID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RenderBlog(ctx, &blogpb.RenderBlogReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.blogs.RenderBlog(ctx, &blogpb.RenderBlogReq{Id: e.blogID, Version: -1})
+				return err
+			},
+		},
+
+		// CommentService
+		{
+			method: "blog.CommentService/CreateComment",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.CreateComment(ctx, &blogpb.CreateCommentReq{Comment: &blogpb.Comment{BlogId: e.blogID, Content: "Nice"}})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.CreateComment(ctx, &blogpb.CreateCommentReq{Comment: &blogpb.Comment{BlogId: missingID, Content: "Nice"}})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.CreateComment(ctx, &blogpb.CreateCommentReq{Comment: &blogpb.Comment{BlogId: e.blogID}})
+				return err
+			},
+		},
+		{
+			method: "blog.CommentService/UpdateComment",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.UpdateComment(ctx, &blogpb.UpdateCommentReq{Id: e.commentID, Content: "Edited"})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.UpdateComment(ctx, &blogpb.UpdateCommentReq{Id: missingID, Content: "Edited"})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.UpdateComment(ctx, &blogpb.UpdateCommentReq{Id: e.commentID})
+				return err
+			},
+		},
+		{
+			method: "blog.CommentService/DeleteComment",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.DeleteComment(ctx, &blogpb.DeleteCommentReq{Id: e.commentID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.DeleteComment(ctx, &blogpb.DeleteCommentReq{Id: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.comments.DeleteComment(ctx, &blogpb.DeleteCommentReq{Id: "nope"})
+				return err
+			},
+		},
+		{
+			method: "blog.CommentService/ListComments",
+			valid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.comments.ListComments(ctx, &blogpb.ListCommentsReq{BlogId: e.blogID})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.comments.ListComments(ctx, &blogpb.ListCommentsReq{BlogId: missingID})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.comments.ListComments(ctx, &blogpb.ListCommentsReq{BlogId: "nope"})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+		},
+
+		// AuthorService
+		{
+			method: "blog.AuthorService/CreateAuthor",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.CreateAuthor(ctx, &blogpb.CreateAuthorReq{Author: &blogpb.Author{Id: "bob", DisplayName: "Bob"}})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.CreateAuthor(ctx, &blogpb.CreateAuthorReq{Author: &blogpb.Author{Id: "Bob", DisplayName: "Bob"}})
+				return err
+			},
+		},
+		{
+			method: "blog.AuthorService/ReadAuthor",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.ReadAuthor(ctx, &blogpb.ReadAuthorReq{Id: "alice"})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.ReadAuthor(ctx, &blogpb.ReadAuthorReq{Id: "nobody"})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.ReadAuthor(ctx, &blogpb.ReadAuthorReq{})
+				return err
+			},
+		},
+		{
+			method: "blog.AuthorService/UpdateAuthor",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.UpdateAuthor(ctx, &blogpb.UpdateAuthorReq{
+					Author:     &blogpb.Author{Id: "alice", Bio: "Writes tests"},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"bio"}},
+				})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.UpdateAuthor(ctx, &blogpb.UpdateAuthorReq{
+					Author:     &blogpb.Author{Id: "nobody", Bio: "Writes tests"},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"bio"}},
+				})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.authors.UpdateAuthor(ctx, &blogpb.UpdateAuthorReq{
+					Author:     &blogpb.Author{Id: "alice"},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}},
+				})
+				return err
+			},
+		},
+		{
+			method: "blog.AuthorService/ListAuthors",
+			valid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.authors.ListAuthors(ctx, &blogpb.ListAuthorsReq{})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.authors.ListAuthors(ctx, &blogpb.ListAuthorsReq{PageToken: "nope"})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+		},
+
+		// AttachmentService
+		{
+			method: "blog.AttachmentService/UploadAttachment",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.upload(ctx, e.blogID, "other.txt", []byte("other"))
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.upload(ctx, missingID, "other.txt", []byte("other"))
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.upload(ctx, e.blogID, "../other.txt", []byte("other"))
+				return err
+			},
+		},
+		{
+			method: "blog.AttachmentService/DownloadAttachment",
+			valid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.attachments.DownloadAttachment(ctx, &blogpb.DownloadAttachmentReq{Id: e.attachmentID})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.attachments.DownloadAttachment(ctx, &blogpb.DownloadAttachmentReq{Id: missingID})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				stream, err := e.attachments.DownloadAttachment(ctx, &blogpb.DownloadAttachmentReq{Id: "nope"})
+				if err != nil {
+					return err
+				}
+				return drain(func() error { _, err := stream.Recv(); return err })
+			},
+		},
+		{
+			method: "blog.AttachmentService/ListAttachments",
+			valid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.attachments.ListAttachments(ctx, &blogpb.ListAttachmentsReq{BlogId: e.blogID})
+				return err
+			},
+			missing: func(ctx context.Context, e *testEnv) error {
+				_, err := e.attachments.ListAttachments(ctx, &blogpb.ListAttachmentsReq{BlogId: missingID})
+				return err
+			},
+			invalid: func(ctx context.Context, e *testEnv) error {
+				_, err := e.attachments.ListAttachments(ctx, &blogpb.ListAttachmentsReq{BlogId: "nope"})
+				return err
+			},
+		},
+	}
+}
+
+// storeFailures are the ways the store can fail and the code each one must turn into.
+var storeFailures = []struct {
+	name string
+	err  error
+	code codes.Code
+}{
+	{"unavailable", fmt.Errorf("connection refused: %w", ErrUnavailable), codes.Unavailable},
+	{"internal", errors.New("corrupt document"), codes.Internal},
+	{"canceled", context.Canceled, codes.Canceled},
+	{"deadline", context.DeadlineExceeded, codes.DeadlineExceeded},
+}
+
+func TestStatusCodes(t *testing.T) {
+	for _, rpc := range rpcCases() {
+		rpc := rpc
+		t.Run(rpc.method, func(t *testing.T) {
+			check := func(name string, call rpcCall, fault error, want codes.Code) {
+				if call == nil {
+					return
+				}
+				t.Run(name, func(t *testing.T) {
+					e := newTestEnv(t)
+					e.store.fail(fault)
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					defer cancel()
+					err := call(ctx, e)
+					if got := status.Code(err); got != want {
+						t.Errorf("Got %v, want %v: %v", got, want, err)
+					}
+				})
+			}
+
+			check("valid", rpc.valid, nil, codes.OK)
+			check("missing", rpc.missing, nil, codes.NotFound)
+			check("invalid", rpc.invalid, nil, codes.InvalidArgument)
+			if rpc.storeless {
+				return
+			}
+			for _, failure := range storeFailures {
+				check(failure.name, rpc.valid, failure.err, failure.code)
+			}
+		})
+	}
+}
+
+// TestStatusCodesCoverEveryRPC makes sure a new RPC doesn't go without conformance cases.
+func TestStatusCodesCoverEveryRPC(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, rpc := range rpcCases() {
+		covered[rpc.method] = true
+	}
+	e := newTestEnv(t)
+	for service, info := range e.server.GetServiceInfo() {
+		for _, method := range info.Methods {
+			if name := service + "/" + method.Name; !covered[name] {
+				t.Errorf("%s has no conformance cases", name)
+			}
+		}
+	}
+}
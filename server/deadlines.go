@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// rpcDeadlines gives every RPC a deadline, so a client that never sets one or sets one far
+// away can't keep the server busy forever. The handlers pass the context of the RPC on to
+// the store, which gives up once the deadline passes or the client cancels the call.
+type rpcDeadlines struct {
+	// rpc are the deadlines of the methods without an entry in methods.
+	rpc     RPCTimeouts
+	methods map[string]RPCTimeouts
+}
+
+func newRPCDeadlines(cfg TimeoutsConfig) *rpcDeadlines {
+	return &rpcDeadlines{rpc: cfg.RPC, methods: cfg.Methods}
+}
+
+// check reports methods with deadlines that none of the services has.
+func (d *rpcDeadlines) check(services map[string]grpc.ServiceInfo) error {
+	known := make(map[string]bool)
+	for _, info := range services {
+		for _, method := range info.Methods {
+			known[method.Name] = true
+		}
+	}
+	var unknown []string
+	for method := range d.methods {
+		if !known[method] {
+			unknown = append(unknown, method)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("timeouts.methods: unknown methods %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// bound returns ctx with the deadline of the method, fullMethod is like "/blog.BlogService/ReadBlog".
+func (d *rpcDeadlines) bound(ctx context.Context, fullMethod string) (context.Context, context.CancelFunc) {
+	timeouts, ok := d.methods[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]
+	if !ok {
+		timeouts = d.rpc
+	}
+	deadline, hasDeadline := ctx.Deadline()
+	switch {
+	case !hasDeadline && timeouts.Default > 0:
+		return context.WithTimeout(ctx, time.Duration(timeouts.Default))
+	case timeouts.Max > 0 && (!hasDeadline || time.Until(deadline) > time.Duration(timeouts.Max)):
+		return context.WithTimeout(ctx, time.Duration(timeouts.Max))
+	}
+	// The deadline of the client is fine, or there are no limits.
+	return ctx, func() {}
+}
+
+// unary is the grpc.UnaryServerInterceptor of the deadlines.
+func (d *rpcDeadlines) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel := d.bound(ctx, info.FullMethod)
+	defer cancel()
+	return handler(ctx, req)
+}
+
+// stream is the grpc.StreamServerInterceptor of the deadlines.
+func (d *rpcDeadlines) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel := d.bound(ss.Context(), info.FullMethod)
+	defer cancel()
+	return handler(srv, &deadlineStream{ServerStream: ss, ctx: ctx})
+}
+
+// deadlineStream is a server stream with the context of its deadline.
+type deadlineStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *deadlineStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storeError turns an unexpected error of a store into a gRPC error, the message describes
+// what failed. The code tells the client what to do about it:
+//   - Canceled and DeadlineExceeded when the RPC ran out of time or was given up, which is
+//     how the context errors of the stores show up
+//   - Unavailable when the database can't be reached, retrying later may work
+//   - Internal for anything else, retrying won't help
+//
+// Errors that are gRPC errors already, like those of stream.Send returned through a
+// List callback, are passed on as they are.
+func storeError(err error, format string, args ...interface{}) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	msg := fmt.Sprintf(format, args...)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Errorf(codes.Canceled, "%s: %v", msg, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Errorf(codes.DeadlineExceeded, "%s: %v", msg, err)
+	case isUnavailable(err):
+		return status.Errorf(codes.Unavailable, "%s: %v", msg, err)
+	}
+	return status.Errorf(codes.Internal, "%s: %v", msg, err)
+}
+
+// isUnavailable reports whether err means the store can't be reached right now,
+// as opposed to a request it failed to handle.
+func isUnavailable(err error) bool {
+	var selection topology.ServerSelectionError
+	switch {
+	case errors.Is(err, ErrUnavailable),
+		errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, mongo.ErrClientDisconnected),
+		errors.As(err, &selection),
+		mongo.IsNetworkError(err),
+		mongo.IsTimeout(err):
+		return true
+	}
+	return false
+}
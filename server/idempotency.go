@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// idempotencyKeyHeader is the gRPC metadata carrying the idempotency key of requests without one.
+const idempotencyKeyHeader = "idempotency-key"
+
+// maxIdempotencyKeyLength leaves plenty of room for UUIDs and the like.
+const maxIdempotencyKeyLength = 128
+
+// idempotencyKey returns the key of a CreateBlog request from the request or else its metadata, "" for none.
+func idempotencyKey(ctx context.Context, req *blogpb.CreateBlogReq) (string, error) {
+	key := req.GetIdempotencyKey()
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md.Get(idempotencyKeyHeader) {
+			if key == "" {
+				key = v
+			} else if v != key {
+				return "", invalidField("idempotency_key", "differs from the %s metadata", idempotencyKeyHeader)
+			}
+		}
+	}
+	// The metadata isn't checked by the validation layer, the field is.
+	if len(key) > maxIdempotencyKeyLength {
+		return "", invalidField("idempotency_key", "is longer than %d characters", maxIdempotencyKeyLength)
+	}
+	return key, nil
+}
+
+// idempotentCall is a CreateBlog request with an idempotency key, finished or still running.
+type idempotentCall struct {
+	key string
+	// fingerprint tells whether a retry sends the same blog.
+	fingerprint [sha256.Size]byte
+	start       time.Time
+	// done is closed once the request finished, res is only set if it succeeded.
+	done chan struct{}
+	res  *blogpb.CreateBlogRes
+}
+
+// idempotencyCache remembers the CreateBlog requests with an idempotency key for window.
+// It lives in memory: a retry after a dropped connection gets the blog created the first time,
+// a retry after the server restarted creates it again.
+type idempotencyCache struct {
+	mu     sync.Mutex
+	window time.Duration
+	calls  map[string]*idempotentCall
+	// order holds the calls oldest first, to forget them once their window has passed.
+	order []*idempotentCall
+}
+
+func newIdempotencyCache(window time.Duration) *idempotencyCache {
+	return &idempotencyCache{
+		window: window,
+		calls:  make(map[string]*idempotentCall),
+	}
+}
+
+// blogFingerprint hashes the blog of a CreateBlog request.
+func blogFingerprint(blog *blogpb.Blog) [sha256.Size]byte {
+	// Deterministic, so equal blogs always marshal to the same bytes.
+	buf, _ := proto.MarshalOptions{Deterministic: true}.Marshal(blog)
+	return sha256.Sum256(buf)
+}
+
+// do runs create once per key: retries with the key get the response of the first request, waiting for it
+// if it is still running. If it failed the key is free again and the next retry runs create.
+func (c *idempotencyCache) do(ctx context.Context, key string, fingerprint [sha256.Size]byte, create func() (*blogpb.CreateBlogRes, error)) (*blogpb.CreateBlogRes, error) {
+	for {
+		c.mu.Lock()
+		c.expire(time.Now())
+		call, ok := c.calls[key]
+		if !ok {
+			call = &idempotentCall{key: key, fingerprint: fingerprint, start: time.Now(), done: make(chan struct{})}
+			c.calls[key] = call
+			c.order = append(c.order, call)
+			c.mu.Unlock()
+
+			res, err := create()
+			c.mu.Lock()
+			if err != nil {
+				if c.calls[key] == call {
+					delete(c.calls, key)
+				}
+			} else {
+				call.res = res
+			}
+			close(call.done)
+			c.mu.Unlock()
+			return res, err
+		}
+		c.mu.Unlock()
+
+		if call.fingerprint != fingerprint {
+			return nil, invalidField("idempotency_key", "%q was already used for a different blog", key)
+		}
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		if call.res != nil {
+			return call.res, nil
+		}
+	}
+}
+
+// expire forgets the calls older than window. Callers must hold c.mu.
+func (c *idempotencyCache) expire(now time.Time) {
+	cutoff := now.Add(-c.window)
+	n := 0
+	for n < len(c.order) && c.order[n].start.Before(cutoff) {
+		// A failed call may have made room for a newer one with the same key already.
+		if call := c.order[n]; c.calls[call.key] == call {
+			delete(c.calls, call.key)
+		}
+		n++
+	}
+	c.order = c.order[n:]
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateBlogReplay(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	count := func() int {
+		t.Helper()
+		n := 0
+		if err := e.store.List(ctx, ListQuery{}, func(*BlogItem) error { n++; return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	before := count()
+
+	first, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Once"), IdempotencyKey: "key-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A retry, the key may also come as metadata.
+	withHeader := metadata.AppendToOutgoingContext(ctx, idempotencyKeyHeader, "key-1")
+	for _, call := range []func() (*blogpb.CreateBlogRes, error){
+		func() (*blogpb.CreateBlogRes, error) {
+			return e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Once"), IdempotencyKey: "key-1"})
+		},
+		func() (*blogpb.CreateBlogRes, error) {
+			return e.blogs.CreateBlog(withHeader, &blogpb.CreateBlogReq{Blog: e.newBlog("Once")})
+		},
+	} {
+		again, err := call()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.GetBlog().GetId() != first.GetBlog().GetId() {
+			t.Errorf("The retry created %s, want the blog %s of the first request", again.GetBlog().GetId(), first.GetBlog().GetId())
+		}
+	}
+	if got := count(); got != before+1 {
+		t.Errorf("Got %d blogs, want %d", got, before+1)
+	}
+
+	// Without a key every request creates a blog.
+	if _, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Once")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := count(); got != before+2 {
+		t.Errorf("Got %d blogs, want %d", got, before+2)
+	}
+}
+
+func TestCreateBlogKeyMismatch(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	if _, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("First"), IdempotencyKey: "key-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same key for another blog is a client bug, not a retry.
+	_, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Second"), IdempotencyKey: "key-1"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v reusing the key for another blog, want InvalidArgument", err)
+	}
+	withHeader := metadata.AppendToOutgoingContext(ctx, idempotencyKeyHeader, "key-2")
+	_, err = e.blogs.CreateBlog(withHeader, &blogpb.CreateBlogReq{Blog: e.newBlog("First"), IdempotencyKey: "key-1"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v for different keys in the request and metadata, want InvalidArgument", err)
+	}
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	ctx := context.Background()
+	cache := newIdempotencyCache(time.Minute)
+	fingerprint := blogFingerprint(&blogpb.Blog{Title: "Title"})
+	calls := 0
+	create := func(err error) func() (*blogpb.CreateBlogRes, error) {
+		return func() (*blogpb.CreateBlogRes, error) {
+			calls++
+			if err != nil {
+				return nil, err
+			}
+			return &blogpb.CreateBlogRes{Blog: &blogpb.Blog{Title: "Title"}}, nil
+		}
+	}
+
+	// A failed request frees the key for the retry.
+	if _, err := cache.do(ctx, "key", fingerprint, create(errors.New("failed"))); err == nil {
+		t.Fatal("The error of the request was lost")
+	}
+	first, err := cache.do(ctx, "key", fingerprint, create(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := cache.do(ctx, "key", fingerprint, create(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != first || calls != 2 {
+		t.Errorf("Got %d calls, want the retry to get the response of the second", calls)
+	}
+
+	// Once the window has passed the key is forgotten.
+	cache.mu.Lock()
+	cache.expire(time.Now().Add(2 * time.Minute))
+	cache.mu.Unlock()
+	if _, err := cache.do(ctx, "key", fingerprint, create(nil)); err != nil || calls != 3 {
+		t.Errorf("Got %d calls, %v after the window, want the request to run again", calls, err)
+	}
+}
+
+func TestIdempotencyCacheWaitsForTheFirstRequest(t *testing.T) {
+	ctx := context.Background()
+	cache := newIdempotencyCache(time.Minute)
+	fingerprint := blogFingerprint(&blogpb.Blog{Title: "Title"})
+	started, release := make(chan struct{}), make(chan struct{})
+	want := &blogpb.CreateBlogRes{Blog: &blogpb.Blog{Title: "Title"}}
+	go cache.do(ctx, "key", fingerprint, func() (*blogpb.CreateBlogRes, error) {
+		close(started)
+		<-release
+		return want, nil
+	})
+	<-started
+
+	// A retry while the first request runs waits for it instead of creating the blog again.
+	done := make(chan *blogpb.CreateBlogRes)
+	go func() {
+		res, _ := cache.do(ctx, "key", fingerprint, func() (*blogpb.CreateBlogRes, error) {
+			t.Error("The retry ran while the first request was still running")
+			return nil, nil
+		})
+		done <- res
+	}()
+	close(release)
+	if res := <-done; res != want {
+		t.Errorf("Got %v, want the response of the first request", res)
+	}
+
+	// A waiting retry gives up with its own context.
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	started, block := make(chan struct{}), make(chan struct{})
+	defer close(block)
+	go cache.do(ctx, "slow", fingerprint, func() (*blogpb.CreateBlogRes, error) {
+		close(started)
+		<-block
+		return want, nil
+	})
+	<-started
+	if _, err := cache.do(timeout, "slow", fingerprint, nil); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Got %v, want DeadlineExceeded", err)
+	}
+}
@@ -2,79 +2,179 @@ package main
 
 import (
 	blogpb "github.com/snow-dev/simple-api/proto"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"google.golang.org/grpc"
 
 	"os"
 	"os/signal"
 
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type BlogServiceServer struct{}
+// BlogServiceServer implements blogpb.BlogServiceServer on top of a BlogStore.
+type BlogServiceServer struct {
+	store  BlogStore
+	search *SearchIndex
+	// feed tells WatchBlogs about every change.
+	feed *changeFeed
+	// idempotency remembers the CreateBlog requests with an idempotency key.
+	idempotency *idempotencyCache
+	// renders caches RenderBlog results by version.
+	renders *renderCache
+
+	pageTokens      *pageTokenCodec
+	defaultPageSize int
+	maxPageSize     int
+}
 
 func (s BlogServiceServer) CreateBlog(ctx context.Context, req *blogpb.CreateBlogReq) (*blogpb.CreateBlogRes, error) {
-	// Essentially doing req.Blog to access the struct with a nil check
-	blog := req.GetBlog()
-	// Now we have to convert it into a BlogItem type to convert into BSON
-	data := BlogItem{
-		//ID:		empty so it gets omitted and MongoDB generates a unique Object ID upont insertion
-		AuthorID: blog.GetAuthorId(),
-		Content:  blog.GetContent(),
-		Title:    blog.GetTitle(),
+	key, err := idempotencyKey(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return s.createBlog(ctx, req.GetBlog())
 	}
+	// A retry with the same key gets the blog created by the first request instead of a duplicate.
+	return s.idempotency.do(ctx, key, blogFingerprint(req.GetBlog()), func() (*blogpb.CreateBlogRes, error) {
+		return s.createBlog(ctx, req.GetBlog())
+	})
+}
 
-	// Insert the data into the database, result contain the newly generated Object ID for de new document.
-	result, err := blogdb.InsertOne(mongoCtx, data)
+func (s BlogServiceServer) createBlog(ctx context.Context, blog *blogpb.Blog) (*blogpb.CreateBlogRes, error) {
+	data, slugs, err := newBlogItem(blog)
+	if err != nil {
+		return nil, err
+	}
+	// Every blog belongs to a known author, no more "Snow Dev" next to "snow-dev".
+	if err := checkAuthor(ctx, s.store, blog.GetAuthorId()); err != nil {
+		return nil, err
+	}
+
+	// Insert the data into the store, result contains the newly generated Object ID for the new blog.
+	result, err := firstFreeSlug(slugs, func(slug string) (*BlogItem, error) {
+		data.Slug = slug
+		data.Slugs = []string{slug}
+		return s.store.Create(ctx, data)
+	})
 	// Check for potential errors.
 	if err != nil {
-		// return internal gRPC error to be handled later.
-		return nil, status.Errorf(
-			codes.Internal,
-			fmt.Sprintf("Internal error: %v", err),
-		)
+		return nil, createError(data.Slug, err)
 	}
+	s.search.Add(result)
+	s.feed.publish(blogpb.BlogEventType_CREATED, result)
 
-	// Add the id to the blog, first cast the "generic type" (go doesn't have real generics yet)
-	oid := result.InsertedID.(primitive.ObjectID)
-	// Convert the object id to it's string counterpart.
-	blog.Id = oid.Hex()
 	// Return the blog in a CreateBlogRes type.
-	return &blogpb.CreateBlogRes{Blog: blog}, nil
+	return &blogpb.CreateBlogRes{Blog: result.toProto()}, nil
+}
+
+// newBlogItem converts a blog sent to CreateBlog, checked by validateNewBlog already, into a BlogItem
+// for the store, along with the slugs to try in turn. The error is a gRPC error.
+func newBlogItem(blog *blogpb.Blog) (*BlogItem, []string, error) {
+	tags, err := normalizeTags(blog.GetTags())
+	if err != nil {
+		return nil, nil, invalidField("blog.tags", "%v", err)
+	}
+	// Now we have to convert it into a BlogItem type for the store
+	// Timestamps are always set here, whatever the client sent.
+	created := now()
+	// New blogs are drafts unless the client publishes or schedules them right away.
+	blogStatus, publishTime := StatusDraft, time.Time{}
+	switch blog.GetStatus() {
+	case blogpb.BlogStatus_BLOG_STATUS_UNSPECIFIED, blogpb.BlogStatus_DRAFT:
+	case blogpb.BlogStatus_PUBLISHED, blogpb.BlogStatus_SCHEDULED:
+		blogStatus, publishTime = publishState(timeOrZero(blog.GetPublishTime()), created)
+	default:
+		return nil, nil, invalidField("blog.status", "a new blog cannot be %s", blog.GetStatus())
+	}
+	format, err := formatFromProto(blog.GetContentFormat())
+	if err != nil {
+		return nil, nil, err
+	}
+	data := &BlogItem{
+		//ID:		empty so the store generates a unique Object ID upon insertion
+		AuthorID:      blog.GetAuthorId(),
+		Content:       blog.GetContent(),
+		Title:         blog.GetTitle(),
+		Tags:          tags,
+		Editor:        blog.GetAuthorId(),
+		Status:        blogStatus,
+		PublishTime:   publishTime,
+		ContentFormat: format,
+		CreateTime:    created,
+		UpdateTime:    created,
+	}
+
+	// Without an explicit slug one is made from the title, with a number appended if it is taken.
+	slugs := []string{blog.GetSlug()}
+	if blog.GetSlug() == "" {
+		slugs = slugCandidates(blog.GetTitle())
+	}
+	return data, slugs, nil
+}
+
+// createError turns the error of the store creating a blog into a gRPC error.
+// slug is the last one tried, the one taken if err is ErrSlugTaken.
+func createError(slug string, err error) error {
+	if err == ErrSlugTaken {
+		return status.Errorf(codes.AlreadyExists, "Slug %q is already taken", slug)
+	}
+	return storeError(err, "Could not create blog")
 }
 
 func (s BlogServiceServer) ReadBlog(ctx context.Context, req *blogpb.ReadBlogReq) (*blogpb.ReadBlogRes, error) {
 	// convert string id (from proto) to MongoDB ObjectId
 	oid, err := primitive.ObjectIDFromHex(req.GetId())
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Could not convert to ObjectId: %v", err))
-	}
-	result := blogdb.FindOne(ctx, bson.M{"_id": oid})
-	// Create an empty BlogItem to write our decode result to
-	data := BlogItem{}
-	// decode and write to data
-	if err := result.Decode(&data); err != nil {
-		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Could not find blog with Object Id %s : %v", req.GetId(), err))
-	}
-	//cast to reaadBlogres type
-	response := &blogpb.ReadBlogRes{
-		Blog: &blogpb.Blog{
-			Id:       oid.Hex(),
-			AuthorId: data.AuthorID,
-			Title:    data.Title,
-			Content:  data.Content,
-		},
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	data, err := s.store.Read(ctx, oid)
+	if err == ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find blog with Object Id %s", req.GetId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read blog %s", req.GetId())
 	}
-	return response, nil
+	author, err := publicAuthor(ctx, s.store, data.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.ReadBlogRes{Blog: data.toProto(), Author: author}, nil
+}
+
+func (s BlogServiceServer) ReadBlogBySlug(ctx context.Context, req *blogpb.ReadBlogBySlugReq) (*blogpb.ReadBlogBySlugRes, error) {
+	data, err := s.store.ReadBySlug(ctx, req.GetSlug())
+	if err == nil && !data.DeleteTime.IsZero() {
+		// Slugs are public, trashed blogs aren't.
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find blog with slug %q", req.GetSlug())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read blog with slug %q", req.GetSlug())
+	}
+	author, err := publicAuthor(ctx, s.store, data.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+	return &blogpb.ReadBlogBySlugRes{
+		Blog: data.toProto(),
+		// An old slug, clients should redirect to the current one.
+		Moved:  data.Slug != req.GetSlug(),
+		Author: author,
+	}, nil
 }
 
 func (s BlogServiceServer) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlogReq) (*blogpb.UpdateBlogRes, error) {
@@ -84,46 +184,262 @@ func (s BlogServiceServer) UpdateBlog(ctx context.Context, req *blogpb.UpdateBlo
 	//convert the Id string to a MongoDB ObjectId
 	oid, err := primitive.ObjectIDFromHex(blog.GetId())
 	if err != nil {
-		return nil, status.Errorf(
-			codes.InvalidArgument,
-			fmt.Sprintf("Could not convert the supplied blog id to a MongoDB ObjectId: %v", err),
-		)
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert the supplied blog id to a MongoDB ObjectId: %v", err)
 	}
 
-	// Convert the data to be updated into an unordered Bson document.
-	update := bson.M{
-		"author_id": blog.GetAuthorId(),
-		"title":     blog.GetTitle(),
-		"content":   blog.GetContent(),
+	// Only the fields named in the mask are updated, no mask means all of them.
+	fields := req.GetUpdateMask().GetPaths()
+	if len(fields) == 0 {
+		fields = updatableFields
+	}
+	tags, err := normalizeTags(blog.GetTags())
+	if err != nil {
+		return nil, invalidField("blog.tags", "%v", err)
+	}
+	if hasField(fields, "author_id") {
+		if err := checkAuthor(ctx, s.store, blog.GetAuthorId()); err != nil {
+			return nil, err
+		}
+	}
+	format, err := formatFromProto(blog.GetContentFormat())
+	if err != nil {
+		return nil, err
 	}
 
-	// Convert the oid into an unordered bson document to search by id.
-	filter := bson.M{"_id": oid}
-
-	// Result is the BSON encoded result
-	// To return the updated document instead of original we have to add options.
-	result := blogdb.FindOneAndUpdate(ctx, filter, bson.M{"$set": update}, options.FindOneAndUpdate().SetReturnDocument(1))
+	// The slug follows the title unless the client sets one, the old slug keeps redirecting.
+	explicitSlug := hasField(fields, "slug") && blog.GetSlug() != ""
+	fields = withoutField(fields, "slug")
+	slugs := []string{""}
+	if explicitSlug {
+		slugs = []string{blog.GetSlug()}
+		fields = append(fields, "slug")
+	} else if hasField(fields, "title") {
+		slugs = slugCandidates(blog.GetTitle())
+		fields = append(fields, "slug")
+	}
 
-	// Decode result and write it to 'decoded'
-	decoded := BlogItem{}
+	// Without an editor the one of the current version stays.
+	if req.GetEditor() != "" {
+		fields = append(fields, "editor")
+	}
 
-	err = result.Decode(&decoded)
+	// The state before the update is kept as a revision.
+	var tried string
+	updated, err := updateWithRevision(ctx, s.store, oid, req.GetExpectedVersion(), notInTrash, func(version int64) (*BlogItem, error) {
+		return firstFreeSlug(slugs, func(slug string) (*BlogItem, error) {
+			tried = slug
+			return s.store.Update(ctx, &BlogItem{
+				ID:            oid,
+				AuthorID:      blog.GetAuthorId(),
+				Title:         blog.GetTitle(),
+				Content:       blog.GetContent(),
+				Tags:          tags,
+				Slug:          slug,
+				Editor:        req.GetEditor(),
+				ContentFormat: format,
+				UpdateTime:    now(),
+			}, fields, version)
+		})
+	})
+	if err == ErrSlugTaken {
+		return nil, status.Errorf(codes.AlreadyExists, "Slug %q is already taken", tried)
+	}
 	if err != nil {
-		return nil, status.Errorf(
-			codes.NotFound,
-			fmt.Sprintf("Could not find blog with supplied ID: %v", err),
-		)
+		return nil, statusUpdateError(blog.GetId(), req.GetExpectedVersion(), err)
+	}
+	s.search.Add(updated)
+	s.feed.publish(blogpb.BlogEventType_UPDATED, updated)
+
+	return &blogpb.UpdateBlogRes{Blog: updated.toProto()}, nil
+}
+
+// withoutField returns fields without field, leaving fields itself untouched.
+func withoutField(fields []string, field string) []string {
+	kept := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f != field {
+			kept = append(kept, f)
+		}
 	}
+	return kept
+}
 
-	return &blogpb.UpdateBlogRes{
-		Blog: &blogpb.Blog{
-			Id:       decoded.ID.Hex(),
-			AuthorId: decoded.AuthorID,
-			Title:    decoded.Title,
-			Content:  decoded.Content,
+// firstFreeSlug calls save with each slug in turn until one isn't taken.
+func firstFreeSlug(slugs []string, save func(slug string) (*BlogItem, error)) (*BlogItem, error) {
+	for _, slug := range slugs {
+		saved, err := save(slug)
+		if err != ErrSlugTaken {
+			return saved, err
+		}
+	}
+	return nil, ErrSlugTaken
+}
+
+// versionConflictStatus builds the ABORTED error of a rejected update.
+// The current version goes into an ErrorInfo detail so clients don't have to parse the message.
+func versionConflictStatus(id string, expected, current int64) error {
+	st := status.Newf(codes.Aborted, "Blog %s is at version %d, not %d", id, current, expected)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: "VERSION_MISMATCH",
+		Domain: "blog",
+		Metadata: map[string]string{
+			"current_version":  strconv.FormatInt(current, 10),
+			"expected_version": strconv.FormatInt(expected, 10),
 		},
-	}, nil
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+func (s BlogServiceServer) ListRevisions(ctx context.Context, req *blogpb.ListRevisionsReq) (*blogpb.ListRevisionsRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	current, err := s.store.Read(ctx, oid)
+	if err == ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find blog with Object Id %s", req.GetBlogId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read blog %s", req.GetBlogId())
+	}
+	revisions, err := s.store.ListRevisions(ctx, oid)
+	if err != nil {
+		return nil, storeError(err, "Could not list revisions of blog %s", req.GetBlogId())
+	}
+
+	res := &blogpb.ListRevisionsRes{}
+	for _, rev := range revisions {
+		// A revision saved by an update that failed afterwards is just the current version.
+		if rev.Version >= current.Version {
+			continue
+		}
+		res.Revisions = append(res.Revisions, rev.toRevisionProto(false))
+	}
+	return res, nil
+}
+
+func (s BlogServiceServer) GetRevision(ctx context.Context, req *blogpb.GetRevisionReq) (*blogpb.GetRevisionRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	rev, err := s.store.ReadRevision(ctx, oid, req.GetNumber())
+	if err == ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "Blog %s has no revision %d", req.GetBlogId(), req.GetNumber())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read revision %d of blog %s", req.GetNumber(), req.GetBlogId())
+	}
+	return &blogpb.GetRevisionRes{Revision: rev.toRevisionProto(true)}, nil
+}
+
+func (s BlogServiceServer) RevertBlog(ctx context.Context, req *blogpb.RevertBlogReq) (*blogpb.RevertBlogRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetBlogId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	rev, err := s.store.ReadRevision(ctx, oid, req.GetNumber())
+	if err == ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "Blog %s has no revision %d", req.GetBlogId(), req.GetNumber())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read revision %d of blog %s", req.GetNumber(), req.GetBlogId())
+	}
+
+	// Reverting is an update to the content of the revision, so it can be reverted in turn.
+	// The status stays as it is, publishing has its own RPCs. The slug of the revision
+	// is one of the blog's own, it can't be taken by another blog.
+	fields := []string{"author_id", "title", "content", "tags", "content_format"}
+	if rev.Slug != "" {
+		fields = append(fields, "slug")
+	}
+	if req.GetEditor() != "" {
+		fields = append(fields, "editor")
+	}
+	reverted, err := updateWithRevision(ctx, s.store, oid, req.GetExpectedVersion(), notInTrash, func(version int64) (*BlogItem, error) {
+		return s.store.Update(ctx, &BlogItem{
+			ID:            oid,
+			AuthorID:      rev.AuthorID,
+			Title:         rev.Title,
+			Content:       rev.Content,
+			Tags:          rev.Tags,
+			Slug:          rev.Slug,
+			Editor:        req.GetEditor(),
+			ContentFormat: rev.format(),
+			UpdateTime:    now(),
+		}, fields, version)
+	})
+	if err != nil {
+		return nil, statusUpdateError(req.GetBlogId(), req.GetExpectedVersion(), err)
+	}
+	s.search.Add(reverted)
+	s.feed.publish(blogpb.BlogEventType_UPDATED, reverted)
+	return &blogpb.RevertBlogRes{Blog: reverted.toProto()}, nil
+}
+
+func (s BlogServiceServer) PublishBlog(ctx context.Context, req *blogpb.PublishBlogReq) (*blogpb.PublishBlogRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	updated := now()
+	blogStatus, publishTime := publishState(timeOrZero(req.GetPublishTime()), updated)
+	data, err := updateWithRevision(ctx, s.store, oid, req.GetExpectedVersion(), notInTrash, func(version int64) (*BlogItem, error) {
+		return s.store.Update(ctx, &BlogItem{
+			ID:          oid,
+			Status:      blogStatus,
+			PublishTime: publishTime,
+			UpdateTime:  updated,
+		}, []string{"status", "publish_time"}, version)
+	})
+	if err != nil {
+		return nil, statusUpdateError(req.GetId(), req.GetExpectedVersion(), err)
+	}
+	s.search.Add(data)
+	s.feed.publish(blogpb.BlogEventType_UPDATED, data)
+	return &blogpb.PublishBlogRes{Blog: data.toProto()}, nil
+}
+
+func (s BlogServiceServer) UnpublishBlog(ctx context.Context, req *blogpb.UnpublishBlogReq) (*blogpb.UnpublishBlogRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	blogStatus := StatusDraft
+	if req.GetArchive() {
+		blogStatus = StatusArchived
+	}
+	// A zero publish time also cancels a scheduled publication.
+	data, err := updateWithRevision(ctx, s.store, oid, req.GetExpectedVersion(), notInTrash, func(version int64) (*BlogItem, error) {
+		return s.store.Update(ctx, &BlogItem{
+			ID:         oid,
+			Status:     blogStatus,
+			UpdateTime: now(),
+		}, []string{"status", "publish_time"}, version)
+	})
+	if err != nil {
+		return nil, statusUpdateError(req.GetId(), req.GetExpectedVersion(), err)
+	}
+	s.search.Add(data)
+	s.feed.publish(blogpb.BlogEventType_UPDATED, data)
+	return &blogpb.UnpublishBlogRes{Blog: data.toProto()}, nil
+}
 
+// statusUpdateError turns an error of a store update of a blog into a gRPC error.
+func statusUpdateError(id string, expectedVersion int64, err error) error {
+	if conflict, ok := err.(*VersionConflictError); ok {
+		return versionConflictStatus(id, expectedVersion, conflict.Current)
+	}
+	if err == ErrNotFound {
+		return status.Errorf(codes.NotFound, "Could not find blog with Object Id %s", id)
+	}
+	if err == errInTrash {
+		return status.Errorf(codes.FailedPrecondition, "Blog %s is in the trash, restore it first", id)
+	}
+	return storeError(err, "Could not update blog %s", id)
 }
 
 func (s BlogServiceServer) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlogReq) (*blogpb.DeleteBlogRes, error) {
@@ -131,70 +447,255 @@ func (s BlogServiceServer) DeleteBlog(ctx context.Context, req *blogpb.DeleteBlo
 	oid, err := primitive.ObjectIDFromHex(req.GetId())
 	// Check errors
 	if err != nil {
-		return nil, status.Errorf(codes.InvalidArgument, fmt.Sprintf("Could not convert to ObjectId: %v", err))
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	// Deleting only moves the blog to the trash, PurgeBlog removes it for good.
+	// Deleting it again keeps the first deletion time, so it isn't kept longer than the retention.
+	trashed, err := updateWithRevision(ctx, s.store, oid, 0, notInTrash, func(version int64) (*BlogItem, error) {
+		deleted := now()
+		return s.store.Update(ctx, &BlogItem{ID: oid, DeleteTime: deleted, UpdateTime: deleted}, []string{"delete_time"}, version)
+	})
+	if err == errInTrash {
+		// Already deleted, nothing to do.
+		trashed, err = nil, nil
 	}
-	// DeleteOne returns DeleteResult which is a struct containing the amount of deleted docs (in this case only 1 always)
-	// So we return a boolean instead
-	_, err = blogdb.DeleteOne(ctx, bson.M{"_id": oid})
 	// Check errors.
+	if _, ok := err.(*VersionConflictError); ok || err == ErrNotFound {
+		return nil, statusUpdateError(req.GetId(), 0, err)
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.NotFound, fmt.Sprintf("Could not found  find/delete blog with id %s: %v", req.GetId(), err))
+		return nil, storeError(err, "Could not delete blog %s", req.GetId())
+	}
+	s.search.Remove(oid)
+	if trashed != nil {
+		s.feed.publish(blogpb.BlogEventType_DELETED, trashed)
 	}
-	// Return response with success: true if no errors is thrown (and this document is removed)
+	// Return response with success: true if no errors is thrown (and this document is in the trash)
 	return &blogpb.DeleteBlogRes{
 		Success: true,
 	}, nil
 }
 
-func (s BlogServiceServer) ListBlogs(ctx *blogpb.ListBlogsReq, stream blogpb.BlogService_ListBlogsServer) error {
-	// Initiate a blog item type to write decoded data to
-	data := &BlogItem{}
+func (s BlogServiceServer) RestoreBlog(ctx context.Context, req *blogpb.RestoreBlogReq) (*blogpb.RestoreBlogRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	restored, err := updateWithRevision(ctx, s.store, oid, 0, func(current *BlogItem) error {
+		if current.DeleteTime.IsZero() {
+			return status.Errorf(codes.FailedPrecondition, "Blog %s is not in the trash", req.GetId())
+		}
+		return nil
+	}, func(version int64) (*BlogItem, error) {
+		return s.store.Update(ctx, &BlogItem{ID: oid, UpdateTime: now()}, []string{"delete_time"}, version)
+	})
+	if err != nil {
+		return nil, statusUpdateError(req.GetId(), 0, err)
+	}
+	s.search.Add(restored)
+	s.feed.publish(blogpb.BlogEventType_UPDATED, restored)
+	return &blogpb.RestoreBlogRes{Blog: restored.toProto()}, nil
+}
+
+func (s BlogServiceServer) PurgeBlog(ctx context.Context, req *blogpb.PurgeBlogReq) (*blogpb.PurgeBlogRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	// Only trashed blogs can be purged, so a typo in an ID can't destroy a live blog.
+	data, err := s.store.Read(ctx, oid)
+	if err == ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "Could not find blog with Object Id %s", req.GetId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not read blog %s", req.GetId())
+	}
+	if data.DeleteTime.IsZero() {
+		return nil, status.Errorf(codes.FailedPrecondition, "Blog %s is not in the trash, delete it first", req.GetId())
+	}
+	err = s.store.Delete(ctx, oid)
+	if err == ErrNotFound {
+		// Purged by someone else since it was read.
+		return nil, status.Errorf(codes.NotFound, "Could not find blog with Object Id %s", req.GetId())
+	}
+	if err != nil {
+		return nil, storeError(err, "Could not purge blog %s", req.GetId())
+	}
+	s.feed.publishAt(blogpb.BlogEventType_DELETED, data, now())
+	return &blogpb.PurgeBlogRes{}, nil
+}
+
+func (s BlogServiceServer) ListBlogs(req *blogpb.ListBlogsReq, stream blogpb.BlogService_ListBlogsServer) error {
+	query, err := listQueryFromRequest(req)
+	if err != nil {
+		return err
+	}
+	return s.sendPage(query, req.GetPageSize(), req.GetPageToken(), stream)
+}
 
-	// collection.Find returns a cursor for our (empty) query.
-	cursor, err := blogdb.Find(context.Background(), bson.M{})
+func (s BlogServiceServer) ListDeletedBlogs(req *blogpb.ListDeletedBlogsReq, stream blogpb.BlogService_ListDeletedBlogsServer) error {
+	// Trashed blogs of every status.
+	return s.sendPage(&ListQuery{Deleted: true}, req.GetPageSize(), req.GetPageToken(), stream)
+}
+
+// pageSender is the server stream of ListBlogs and ListDeletedBlogs.
+type pageSender interface {
+	Send(*blogpb.ListBlogsRes) error
+	Context() context.Context
+}
+
+// sendPage streams the page of the blogs selected by query that starts at the given page token.
+func (s BlogServiceServer) sendPage(query *ListQuery, requestedSize int32, requestedToken string, stream pageSender) error {
+	p, err := s.pageTokens.newPage(requestedSize, s.defaultPageSize, s.maxPageSize, requestedToken,
+		queryFingerprint(query), "was issued for different filters or order_by")
 	if err != nil {
-		return status.Errorf(codes.Internal, fmt.Sprintf("Unknow internal error: %v", err))
+		return err
+	}
+	query.Limit = p.limit()
+	if p.start != nil {
+		query.After, _ = primitive.ObjectIDFromHex(p.start.After)
+		query.AfterTitle = p.start.Title
+		query.AfterCreateTime = fromMillis(p.start.CreateTime)
 	}
-	// An expresion with defer will be called at the end of the function.
-	defer cursor.Close(context.Background())
-	//  cursor.Next() returns a boolean , it false there are not more items and loop will break.
-	for cursor.Next(context.Background()) {
-		// Decode the data at the current pointer and write it to data.
-		err := cursor.Decode(data)
 
-		if err != nil {
-			return status.Errorf(codes.Unavailable, fmt.Sprintf("Could not decode data: %v", err))
+	// The store calls us back once per blog, send each one over the stream.
+	var last *BlogItem
+	err = s.store.List(stream.Context(), *query, func(data *BlogItem) error {
+		if !p.add() {
+			return nil
 		}
+		last = data
+		// A failed send ends the listing, the client is gone.
+		return stream.Send(&blogpb.ListBlogsRes{Blog: data.toProto()})
+	})
+	if err != nil {
+		return storeError(err, "Could not list blogs")
+	}
 
-		// If no error is found send blog over stream.
-		stream.Send(&blogpb.ListBlogsRes{
-			Blog: &blogpb.Blog{
-				Id:       data.ID.Hex(),
-				AuthorId: data.AuthorID,
-				Title:    data.Title,
-				Content:  data.Content,
-			},
-		})
+	token, err := p.nextToken(func() *pageToken {
+		return &pageToken{After: last.ID.Hex(), Title: last.Title, CreateTime: toMillis(last.CreateTime)}
+	})
+	if err != nil || token == "" {
+		return err
 	}
+	return stream.Send(&blogpb.ListBlogsRes{NextPageToken: token})
+}
 
-	// Check if the cursor has any errors.
-	if err := cursor.Err(); err != nil {
-		return status.Errorf(codes.Internal, fmt.Sprintf("Unknow cursor error: %v", err))
+// Number of SearchBlogs results when the request doesn't set a limit, and the most it may ask for.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+func (s BlogServiceServer) SearchBlogs(ctx context.Context, req *blogpb.SearchBlogsReq) (*blogpb.SearchBlogsRes, error) {
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	preTag, postTag := req.GetPreTag(), req.GetPostTag()
+	if preTag == "" && postTag == "" {
+		preTag, postTag = "**", "**"
+	}
+
+	hits, err := s.search.Search(req.GetQuery(), limit, preTag, postTag)
+	if err != nil {
+		return nil, invalidField("query", "%v", err)
 	}
 
-	return nil
+	// The index only knows the text, the blogs themselves come from the store.
+	res := &blogpb.SearchBlogsRes{}
+	for _, hit := range hits {
+		data, err := s.store.Read(ctx, hit.ID)
+		if err == ErrNotFound {
+			// Deleted between the search and the read.
+			continue
+		}
+		if err == nil && (data.status() != StatusPublished || !data.DeleteTime.IsZero()) {
+			// Unpublished or deleted between the search and the read, the index only has published blogs.
+			continue
+		}
+		if err != nil {
+			return nil, storeError(err, "Could not read blog %s", hit.ID.Hex())
+		}
+		res.Results = append(res.Results, &blogpb.SearchResult{
+			Blog:           data.toProto(),
+			Score:          hit.Score,
+			TitleHighlight: hit.TitleHighlight,
+			Snippet:        hit.Snippet,
+		})
+	}
+	return res, nil
+}
 
+func (s BlogServiceServer) ListTags(ctx context.Context, req *blogpb.ListTagsReq) (*blogpb.ListTagsRes, error) {
+	tags, err := s.store.ListTags(ctx)
+	if err != nil {
+		return nil, storeError(err, "Could not list tags")
+	}
+	res := &blogpb.ListTagsRes{}
+	for _, tag := range tags {
+		res.Tags = append(res.Tags, &blogpb.TagCount{Tag: tag.Tag, Count: tag.Count})
+	}
+	return res, nil
 }
 
-var db *mongo.Client
-var blogdb *mongo.Collection
-var mongoCtx context.Context
+// listQueryFromRequest translates the filters and order_by of a ListBlogsReq into a ListQuery.
+func listQueryFromRequest(req *blogpb.ListBlogsReq) (*ListQuery, error) {
+	query := &ListQuery{
+		AuthorID:      req.GetAuthorId(),
+		TitlePrefix:   req.GetTitlePrefix(),
+		TitleContains: req.GetTitleContains(),
+	}
+	tags, err := normalizeTags(req.GetTags())
+	if err != nil {
+		return nil, invalidField("tags", "%v", err)
+	}
+	query.Tags = tags
+	// The public listing only shows published blogs, the others must be asked for.
+	query.Statuses = []string{StatusPublished}
+	if len(req.GetStatuses()) > 0 {
+		query.Statuses = nil
+		for _, st := range req.GetStatuses() {
+			blogStatus, ok := statusFromProto[st]
+			if !ok {
+				return nil, invalidField("statuses", "unknown status %s", st)
+			}
+			query.Statuses = append(query.Statuses, blogStatus)
+		}
+	}
+	if req.GetCreatedAfter() != nil {
+		query.CreatedAfter = req.GetCreatedAfter().AsTime()
+	}
+	if req.GetCreatedBefore() != nil {
+		query.CreatedBefore = req.GetCreatedBefore().AsTime()
+	}
 
-type BlogItem struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	AuthorID string             `bson:"author_id"`
-	Content  string             `bson:"content"`
-	Title    string             `bson"title"`
+	// order_by is "<field>" or "<field> asc|desc"
+	order := strings.Fields(req.GetOrderBy())
+	if len(order) > 0 {
+		switch order[0] {
+		case SortByTitle, SortByCreateTime:
+			query.SortBy = order[0]
+		default:
+			return nil, invalidField("order_by", "cannot order by %q, use title or create_time", order[0])
+		}
+	}
+	if len(order) > 1 {
+		switch strings.ToLower(order[1]) {
+		case "asc":
+		case "desc":
+			query.Desc = true
+		default:
+			return nil, invalidField("order_by", "invalid direction %q, use asc or desc", order[1])
+		}
+	}
+	if len(order) > 2 {
+		return nil, invalidField("order_by", "%q is not \"<field>\" or \"<field> asc|desc\"", req.GetOrderBy())
+	}
+	return query, nil
 }
 
 func main() {
@@ -204,41 +705,128 @@ func main() {
 	// Or add timestamps and pipe file name and line number to it:
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	fmt.Println("Starting server on port :50051...")
+	// Flags, BLOG_* environment variables and the config file, see config.go
+	cfg, printConfig, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printConfig {
+		if err := cfg.print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("Starting server on %s...\n", cfg.Listen)
 
-	// 50051 is the default port for gRPC
-	// Ideally we'd use 0.0.0.0 instead of localhost as well
-	listener, err := net.Listen("tcp", ":50051")
+	listener, err := net.Listen("tcp", cfg.Listen)
 
 	if err != nil {
-		log.Fatalf("Unable to listen on port :50051: %v", err)
+		log.Fatalf("Unable to listen on %s: %v", cfg.Listen, err)
 	}
 
 	// slice of gRPC options
 	// Here we can configure things like TLS
-	opts := []grpc.ServerOption{}
+	// Every request passes the validation layer before it reaches a handler.
+	// Before that every RPC gets its deadline, see deadlines.go.
+	deadlines := newRPCDeadlines(cfg.Timeouts)
+	validator := &requestValidator{maxAttachmentSize: int64(cfg.Attachments.MaxSize)}
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(cfg.Limits.MaxRecvMsgSize),
+		grpc.MaxSendMsgSize(cfg.Limits.MaxSendMsgSize),
+		grpc.ChainUnaryInterceptor(deadlines.unary, validator.unary),
+		grpc.ChainStreamInterceptor(deadlines.stream, validator.stream),
+	}
 	// var s *grpc.Server
 	s := grpc.NewServer(opts...)
-	// var srv *BlogServiceServer
-	srv := &BlogServiceServer{}
 
-	blogpb.RegisterBlogServiceServer(s, srv)
+	// Initialize the blog store
+	// Startup and shutdown aren't part of any RPC.
+	ctx := context.Background()
+	connectCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeouts.Connect))
+	store, err := newStore(connectCtx, cfg.Store)
+	cancel()
+	if err != nil {
+		log.Fatalf("Could not open %s store: %v\n", cfg.Store.Kind, err)
+	}
 
-	// Initialize MongoDb client
-	fmt.Println("Connecting to MongoDB...")
-	mongoCtx = context.Background()
-	db, err = mongo.Connect(mongoCtx, options.Client().ApplyURI("mongodb://localhost:27017"))
+	blobs, err := newBlobStore(cfg.Attachments, store, cfg.Store)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Could not open %s attachment storage: %v", cfg.Attachments.Storage, err)
 	}
-	err = db.Ping(mongoCtx, nil)
+
+	pageTokens, err := newPageTokenCodec(cfg.List.PageTokenSecret)
 	if err != nil {
-		log.Fatalf("Could not connect to MongoDB: %v\n", err)
-	} else {
-		fmt.Println("Connected to Mongodb")
+		log.Fatalf("Could not set up page tokens: %v", err)
+	}
+
+	// Index the published blogs for SearchBlogs, the RPCs keep the index up to date from now on.
+	search := NewSearchIndex()
+	if err := search.Build(ctx, store); err != nil {
+		log.Fatalf("Could not build the search index: %v", err)
+	}
+
+	// Changes made from now on are published to WatchBlogs.
+	feed := newChangeFeed(cfg.Watch.History)
+
+	// var srv *BlogServiceServer
+	srv := &BlogServiceServer{
+		store:           store,
+		search:          search,
+		feed:            feed,
+		idempotency:     newIdempotencyCache(time.Duration(cfg.Idempotency.Window)),
+		renders:         newRenderCache(cfg.Render.CacheSize),
+		pageTokens:      pageTokens,
+		defaultPageSize: cfg.List.DefaultPageSize,
+		maxPageSize:     cfg.List.MaxPageSize,
 	}
 
-	blogdb = db.Database("test").Collection("blog")
+	blogpb.RegisterBlogServiceServer(s, srv)
+	// Comments and authors live in the same store and page the same way as the blogs.
+	blogpb.RegisterCommentServiceServer(s, &CommentServiceServer{
+		store:           store,
+		pageTokens:      pageTokens,
+		defaultPageSize: cfg.List.DefaultPageSize,
+		maxPageSize:     cfg.List.MaxPageSize,
+	})
+	blogpb.RegisterAuthorServiceServer(s, &AuthorServiceServer{
+		store:           store,
+		pageTokens:      pageTokens,
+		defaultPageSize: cfg.List.DefaultPageSize,
+		maxPageSize:     cfg.List.MaxPageSize,
+	})
+	blogpb.RegisterAttachmentServiceServer(s, &AttachmentServiceServer{
+		store: store,
+		blobs: blobs,
+	})
+	// Catch per-method timeouts of methods that don't exist, e.g. misspelled ones.
+	if err := deadlines.check(s.GetServiceInfo()); err != nil {
+		log.Fatal(err)
+	}
+
+	// Background jobs run until the server stops.
+	// Publish scheduled blogs.
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	scheduler := &publishScheduler{store: store, search: search, feed: feed, interval: time.Duration(cfg.Scheduler.Interval)}
+	schedulerDone := make(chan struct{})
+	go func() {
+		scheduler.run(jobsCtx)
+		close(schedulerDone)
+	}()
+	// Purge blogs that have been in the trash longer than the retention.
+	purgerDone := make(chan struct{})
+	if cfg.Trash.Retention > 0 {
+		purger := &trashPurger{store: store, feed: feed, retention: time.Duration(cfg.Trash.Retention)}
+		go func() {
+			purger.run(jobsCtx)
+			close(purgerDone)
+		}()
+	} else {
+		close(purgerDone)
+	}
 
 	// Start the server in a child routine
 	go func() {
@@ -246,7 +834,7 @@ func main() {
 			log.Fatalf("Failed to serve: %v", err)
 		}
 	}()
-	fmt.Println("Server successfully started on port :50051")
+	fmt.Printf("Server successfully started on %s\n", cfg.Listen)
 
 	// Bad way to stop the server
 	// if err := s.Serve(listener); err != nil {
@@ -269,10 +857,25 @@ func main() {
 
 	// After receiving CTRL+C Properly stop the server
 	fmt.Println("\nStopping the server...")
-	s.Stop()
+	// Watches never end on their own, end them so they don't hold up the graceful stop.
+	feed.close()
+	// Let in-flight RPCs finish, but don't wait longer than the shutdown timeout.
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Duration(cfg.Timeouts.Shutdown)):
+		s.Stop()
+	}
 	listener.Close()
-	fmt.Println("Closing MongoDB connection")
-	db.Disconnect(mongoCtx)
+	stopJobs()
+	<-schedulerDone
+	<-purgerDone
+	fmt.Println("Closing the blog store")
+	store.Close(ctx)
 	fmt.Println("Done.")
 
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// testEnv is a server with every service on an in-memory store and a client for each,
+// plus a few documents to work on.
+type testEnv struct {
+	server      *grpc.Server
+	store       *faultyStore
+	feed        *changeFeed
+	blogs       blogpb.BlogServiceClient
+	comments    blogpb.CommentServiceClient
+	authors     blogpb.AuthorServiceClient
+	attachments blogpb.AttachmentServiceClient
+
+	// A published blog by "alice" with one revision, and a blog in the trash.
+	blogID    string
+	blogSlug  string
+	trashedID string
+	// A comment and an attachment of the published blog.
+	commentID    string
+	attachmentID string
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	// The conformance suite makes the store fail underneath the RPCs.
+	store := &faultyStore{BlogStore: NewMemoryStore()}
+	blobs, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageTokens, err := newPageTokenCodec("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed := newChangeFeed(100)
+
+	// Wired up like in main.
+	deadlines := newRPCDeadlines(defaultConfig().Timeouts)
+	validator := &requestValidator{maxAttachmentSize: 1 << 20}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(deadlines.unary, validator.unary),
+		grpc.ChainStreamInterceptor(deadlines.stream, validator.stream),
+	)
+	blogpb.RegisterBlogServiceServer(s, &BlogServiceServer{
+		store:           store,
+		search:          NewSearchIndex(),
+		feed:            feed,
+		idempotency:     newIdempotencyCache(time.Hour),
+		renders:         newRenderCache(10),
+		pageTokens:      pageTokens,
+		defaultPageSize: 10,
+		maxPageSize:     100,
+	})
+	blogpb.RegisterCommentServiceServer(s, &CommentServiceServer{store: store, pageTokens: pageTokens, defaultPageSize: 10, maxPageSize: 100})
+	blogpb.RegisterAuthorServiceServer(s, &AuthorServiceServer{store: store, pageTokens: pageTokens, defaultPageSize: 10, maxPageSize: 100})
+	blogpb.RegisterAttachmentServiceServer(s, &AttachmentServiceServer{store: store, blobs: blobs})
+
+	listener := bufconn.Listen(1 << 20)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	e := &testEnv{
+		server:      s,
+		store:       store,
+		feed:        feed,
+		blogs:       blogpb.NewBlogServiceClient(conn),
+		comments:    blogpb.NewCommentServiceClient(conn),
+		authors:     blogpb.NewAuthorServiceClient(conn),
+		attachments: blogpb.NewAttachmentServiceClient(conn),
+	}
+	if err := e.createFixture(context.Background()); err != nil {
+		t.Fatalf("Could not create the fixture: %v", err)
+	}
+	return e
+}
+
+func (e *testEnv) createFixture(ctx context.Context) error {
+	_, err := e.authors.CreateAuthor(ctx, &blogpb.CreateAuthorReq{Author: &blogpb.Author{Id: "alice", DisplayName: "Alice"}})
+	if err != nil {
+		return err
+	}
+	created, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Conformance")})
+	if err != nil {
+		return err
+	}
+	e.blogID = created.GetBlog().GetId()
+	updated, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+		Blog:       &blogpb.Blog{Id: e.blogID, Title: "Conformance suite"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	})
+	if err != nil {
+		return err
+	}
+	e.blogSlug = updated.GetBlog().GetSlug()
+
+	trashed, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Trashed")})
+	if err != nil {
+		return err
+	}
+	e.trashedID = trashed.GetBlog().GetId()
+	if _, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: e.trashedID}); err != nil {
+		return err
+	}
+
+	comment, err := e.comments.CreateComment(ctx, &blogpb.CreateCommentReq{Comment: &blogpb.Comment{BlogId: e.blogID, Content: "First"}})
+	if err != nil {
+		return err
+	}
+	e.commentID = comment.GetComment().GetId()
+	attachment, err := e.upload(ctx, e.blogID, "hello.txt", []byte("hello"))
+	if err != nil {
+		return err
+	}
+	e.attachmentID = attachment.GetId()
+	return nil
+}
+
+// newBlog returns a valid published blog of the fixture author.
+func (e *testEnv) newBlog(title string) *blogpb.Blog {
+	return &blogpb.Blog{AuthorId: "alice", Title: title, Content: "Some content", Status: blogpb.BlogStatus_PUBLISHED}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errInvalidPageToken is returned for page tokens that weren't issued by this server or were modified.
+var errInvalidPageToken = errors.New("invalid page token")
+
+// pageToken is the position of the next page. Pages are keyset based: a page starts right
+// after the last blog of the previous one, so blogs created or deleted between two calls
+// never shift the following pages.
+type pageToken struct {
+	// After is the hex ID of the last blog (or comment) of the previous page.
+	After string `json:"a,omitempty"`
+	// Key is the ID of the last item for lists not keyed by ObjectIDs, like authors.
+	Key string `json:"k,omitempty"`
+	// Title or CreateTime (in milliseconds) of that blog when sorting by them.
+	Title      string `json:"t,omitempty"`
+	CreateTime int64  `json:"c,omitempty"`
+	// Query is the fingerprint of the filters and order the token was issued for.
+	Query string `json:"q"`
+}
+
+// queryFingerprint identifies the filters and order of q, a page token is only valid for the same ones.
+func queryFingerprint(q *ListQuery) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %d %d %q %q %t %q %t",
+		q.AuthorID, q.TitlePrefix, q.TitleContains,
+		toMillis(q.CreatedAfter), toMillis(q.CreatedBefore), q.Tags, q.Statuses, q.Deleted,
+		q.SortBy, q.Desc,
+	)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// pageTokenCodec turns page tokens into opaque strings and back.
+// Tokens are signed with HMAC-SHA256 so clients can't craft or alter them.
+type pageTokenCodec struct {
+	secret []byte
+}
+
+// newPageTokenCodec uses secret to sign tokens. Without a secret a random one is generated,
+// tokens then stop working when the server restarts.
+func newPageTokenCodec(secret string) (*pageTokenCodec, error) {
+	if secret != "" {
+		return &pageTokenCodec{secret: []byte(secret)}, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &pageTokenCodec{secret: key}, nil
+}
+
+func (c *pageTokenCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// encode returns the opaque string of token: base64(payload || signature).
+func (c *pageTokenCodec) encode(token *pageToken) (string, error) {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, c.sign(payload)...)), nil
+}
+
+// decode verifies and parses a token made by encode.
+func (c *pageTokenCodec) decode(s string) (*pageToken, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) < sha256.Size {
+		return nil, errInvalidPageToken
+	}
+	payload, sig := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	if !hmac.Equal(sig, c.sign(payload)) {
+		return nil, errInvalidPageToken
+	}
+
+	token := &pageToken{}
+	if err := json.Unmarshal(payload, token); err != nil {
+		return nil, errInvalidPageToken
+	}
+	if token.Key == "" {
+		if _, err := primitive.ObjectIDFromHex(token.After); err != nil {
+			return nil, errInvalidPageToken
+		}
+	}
+	return token, nil
+}
+
+// page is one page of a list paged with page tokens, shared by ListBlogs, ListComments and ListAuthors.
+// The store is asked for one item more than the page size to know if there is a next page.
+type page struct {
+	codec *pageTokenCodec
+	size  int
+	// fingerprint identifies the query of the list, tokens are only valid for the same one.
+	fingerprint string
+	// start is the position the page starts after, nil for the first page.
+	start *pageToken
+	sent  int
+	more  bool
+}
+
+// newPage checks the page_size and page_token of a list request. The page size is clamped to
+// [1, maxSize], 0 is defaultSize. A token issued for another query is rejected, mismatch says
+// what differs. The errors are gRPC errors.
+func (c *pageTokenCodec) newPage(pageSize int32, defaultSize, maxSize int, token, fingerprint, mismatch string) (*page, error) {
+	p := &page{codec: c, size: int(pageSize), fingerprint: fingerprint}
+	switch {
+	case p.size < 0:
+		return nil, invalidField("page_size", "must not be negative")
+	case p.size == 0:
+		p.size = defaultSize
+	case p.size > maxSize:
+		p.size = maxSize
+	}
+	if token != "" {
+		start, err := c.decode(token)
+		if err != nil {
+			return nil, invalidField("page_token", "%v", err)
+		}
+		if start.Query != fingerprint {
+			return nil, invalidField("page_token", "%s", mismatch)
+		}
+		p.start = start
+	}
+	return p, nil
+}
+
+// limit is the number of items to ask the store for.
+func (p *page) limit() int {
+	return p.size + 1
+}
+
+// add is called for every item the store returns and reports whether it is on the page.
+func (p *page) add() bool {
+	if p.sent == p.size {
+		p.more = true
+		return false
+	}
+	p.sent++
+	return true
+}
+
+// nextToken returns the token of the next page, "" after the last page.
+// position is the position of the last item sent, only called if there is a next page.
+func (p *page) nextToken(position func() *pageToken) (string, error) {
+	if !p.more {
+		return "", nil
+	}
+	token := position()
+	token.Query = p.fingerprint
+	s, err := p.codec.encode(token)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Could not create page token: %v", err)
+	}
+	return s, nil
+}
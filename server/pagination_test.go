@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// listAll fetches page after page with list, which returns the IDs of a page and the next page token.
+// It fails the test if an ID comes twice or the pages don't end.
+func listAll(t *testing.T, list func(token string) ([]string, string, error)) []string {
+	t.Helper()
+	var all []string
+	seen := make(map[string]bool)
+	token := ""
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatal("The pages don't end")
+		}
+		ids, next, err := list(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range ids {
+			if seen[id] {
+				t.Errorf("%s is on two pages", id)
+			}
+			seen[id] = true
+		}
+		all = append(all, ids...)
+		if next == "" {
+			return all
+		}
+		token = next
+	}
+}
+
+// listBlogsPage sets the page token of req and fetches that page.
+func (e *testEnv) listBlogsPage(req *blogpb.ListBlogsReq, token string) ([]string, string, error) {
+	req.PageToken = token
+	stream, err := e.blogs.ListBlogs(context.Background(), req)
+	if err != nil {
+		return nil, "", err
+	}
+	var ids []string
+	next := ""
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return ids, next, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if res.GetNextPageToken() != "" {
+			next = res.GetNextPageToken()
+			continue
+		}
+		ids = append(ids, res.GetBlog().GetId())
+	}
+}
+
+func (e *testEnv) listCommentsPage(blogID string, size int32, token string) ([]string, string, error) {
+	stream, err := e.comments.ListComments(context.Background(), &blogpb.ListCommentsReq{BlogId: blogID, PageSize: size, PageToken: token})
+	if err != nil {
+		return nil, "", err
+	}
+	var ids []string
+	next := ""
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return ids, next, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if res.GetNextPageToken() != "" {
+			next = res.GetNextPageToken()
+			continue
+		}
+		ids = append(ids, res.GetComment().GetId())
+	}
+}
+
+func (e *testEnv) listAuthorsPage(size int32, token string) ([]string, string, error) {
+	stream, err := e.authors.ListAuthors(context.Background(), &blogpb.ListAuthorsReq{PageSize: size, PageToken: token})
+	if err != nil {
+		return nil, "", err
+	}
+	var ids []string
+	next := ""
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return ids, next, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if res.GetNextPageToken() != "" {
+			next = res.GetNextPageToken()
+			continue
+		}
+		ids = append(ids, res.GetAuthor().GetId())
+	}
+}
+
+func TestPageTokenCodec(t *testing.T) {
+	codec, err := newPageTokenCodec("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &pageToken{After: primitive.NewObjectID().Hex(), Title: "Title", Query: "fingerprint"}
+	token, err := codec.encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := codec.decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *want {
+		t.Errorf("Decoded %+v, want %+v", got, want)
+	}
+
+	raw, _ := base64.RawURLEncoding.DecodeString(token)
+	tampered := append([]byte(nil), raw...)
+	tampered[len(`{"a":"`)] ^= 1
+	other, _ := newPageTokenCodec("other secret")
+	otherToken, _ := other.encode(want)
+	unsigned, _ := json.Marshal(want)
+	badID, _ := codec.encode(&pageToken{After: "not an ID", Query: "fingerprint"})
+	bad := map[string]string{
+		"garbage":           "not a token!",
+		"truncated":         token[:len(token)/2],
+		"tampered":          base64.RawURLEncoding.EncodeToString(tampered),
+		"other secret":      otherToken,
+		"without signature": base64.RawURLEncoding.EncodeToString(unsigned),
+		"invalid ID":        badID,
+	}
+	for name, token := range bad {
+		if _, err := codec.decode(token); err != errInvalidPageToken {
+			t.Errorf("%s: got %v, want errInvalidPageToken", name, err)
+		}
+	}
+}
+
+func TestBlogPages(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	want := listAll(t, func(token string) ([]string, string, error) {
+		return e.listBlogsPage(&blogpb.ListBlogsReq{}, token)
+	})
+	for _, title := range []string{"Delta", "Alpha", "Charlie", "Bravo"} {
+		res, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog(title)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, res.GetBlog().GetId())
+	}
+	for _, orderBy := range []string{"", "title", "title desc", "create_time desc"} {
+		for _, size := range []int32{1, 2, 5} {
+			all := listAll(t, func(token string) ([]string, string, error) {
+				return e.listBlogsPage(&blogpb.ListBlogsReq{PageSize: size, OrderBy: orderBy}, token)
+			})
+			if orderBy == "" && !reflect.DeepEqual(all, want) {
+				t.Errorf("Page size %d: got %q, want %q", size, all, want)
+			}
+			if len(all) != len(want) {
+				t.Errorf("Order %q, page size %d: got %d blogs, want %d", orderBy, size, len(all), len(want))
+			}
+		}
+	}
+
+	// A token only continues the list it was issued for.
+	req := &blogpb.ListBlogsReq{PageSize: 2, OrderBy: "title"}
+	_, token, err := e.listBlogsPage(req, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.listBlogsPage(req, token); err != nil {
+		t.Errorf("Got %v for the next page, want it", err)
+	}
+	changed := map[string]*blogpb.ListBlogsReq{
+		"order":  {PageSize: 2, OrderBy: "title desc"},
+		"author": {PageSize: 2, OrderBy: "title", AuthorId: "bob"},
+		"title":  {PageSize: 2, OrderBy: "title", TitleContains: "a"},
+		"tags":   {PageSize: 2, OrderBy: "title", Tags: []string{"go"}},
+	}
+	for name, req := range changed {
+		if _, _, err := e.listBlogsPage(req, token); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Changed %s: got %v, want InvalidArgument", name, err)
+		}
+	}
+	// The page size may change.
+	if _, _, err := e.listBlogsPage(&blogpb.ListBlogsReq{PageSize: 3, OrderBy: "title"}, token); err != nil {
+		t.Errorf("Got %v with another page size, want the next page", err)
+	}
+	if _, _, err := e.listBlogsPage(req, token[:len(token)-2]+"xx"); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v for a tampered token, want InvalidArgument", err)
+	}
+	if _, _, err := e.listBlogsPage(&blogpb.ListBlogsReq{PageSize: -1}, ""); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v for a negative page size, want InvalidArgument", err)
+	}
+}
+
+func TestCommentPages(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	// The fixture has one comment already.
+	for i := 0; i < 4; i++ {
+		_, err := e.comments.CreateComment(ctx, &blogpb.CreateCommentReq{Comment: &blogpb.Comment{BlogId: e.blogID, Content: fmt.Sprintf("Comment %d", i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all := listAll(t, func(token string) ([]string, string, error) {
+		return e.listCommentsPage(e.blogID, 2, token)
+	})
+	if len(all) != 5 {
+		t.Errorf("Got %d comments, want 5", len(all))
+	}
+
+	// A token is only good for the blog it was issued for.
+	_, token, err := e.listCommentsPage(e.blogID, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Other")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := e.listCommentsPage(other.GetBlog().GetId(), 2, token); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v for a token of another blog, want InvalidArgument", err)
+	}
+}
+
+func TestAuthorPages(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	// The fixture has alice already.
+	for _, id := range []string{"bob", "carol", "dave", "erin"} {
+		if _, err := e.authors.CreateAuthor(ctx, &blogpb.CreateAuthorReq{Author: &blogpb.Author{Id: id, DisplayName: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, size := range []int32{1, 2, 5, 10} {
+		all := listAll(t, func(token string) ([]string, string, error) {
+			return e.listAuthorsPage(size, token)
+		})
+		if len(all) != 5 {
+			t.Errorf("Page size %d: got %d authors, want 5", size, len(all))
+		}
+	}
+
+	// Tokens of other lists are rejected.
+	if _, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Second")}); err != nil {
+		t.Fatal(err)
+	}
+	token := ""
+	stream, err := e.blogs.ListBlogs(ctx, &blogpb.ListBlogsReq{PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token = res.GetNextPageToken(); token != "" {
+			break
+		}
+	}
+	if _, _, err := e.listAuthorsPage(2, token); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v for a ListBlogs token, want InvalidArgument", err)
+	}
+}
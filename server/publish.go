@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+)
+
+// Statuses of a blog, stored as these strings. Blogs from before statuses were added have none
+// and count as published, they were visible to everyone already.
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+var statusToProto = map[string]blogpb.BlogStatus{
+	StatusDraft:     blogpb.BlogStatus_DRAFT,
+	StatusScheduled: blogpb.BlogStatus_SCHEDULED,
+	StatusPublished: blogpb.BlogStatus_PUBLISHED,
+	StatusArchived:  blogpb.BlogStatus_ARCHIVED,
+}
+
+var statusFromProto = map[blogpb.BlogStatus]string{
+	blogpb.BlogStatus_DRAFT:     StatusDraft,
+	blogpb.BlogStatus_SCHEDULED: StatusScheduled,
+	blogpb.BlogStatus_PUBLISHED: StatusPublished,
+	blogpb.BlogStatus_ARCHIVED:  StatusArchived,
+}
+
+// status is the status of the blog, StatusPublished for blogs from before statuses.
+func (b *BlogItem) status() string {
+	if b.Status == "" {
+		return StatusPublished
+	}
+	return b.Status
+}
+
+// publishState is the status and publish time of a blog published at publishTime:
+// scheduled if that is still in the future, published right away otherwise.
+func publishState(publishTime, now time.Time) (string, time.Time) {
+	if publishTime.After(now) {
+		// Same millisecond precision as every other timestamp.
+		return StatusScheduled, publishTime.UTC().Truncate(time.Millisecond)
+	}
+	return StatusPublished, now
+}
+
+// publishScheduler publishes scheduled blogs once their publish time has come.
+// The schedule lives in the store, so scheduled blogs that came due while the server
+// was down are published on the first run after a restart.
+type publishScheduler struct {
+	store    BlogStore
+	search   *SearchIndex
+	feed     *changeFeed
+	interval time.Duration
+}
+
+// run checks for due blogs right away and then every interval, until ctx is done.
+func (p *publishScheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if n, err := p.publishDue(ctx); err != nil {
+			log.Printf("Could not publish scheduled blogs: %v", err)
+		} else if n > 0 {
+			log.Printf("Published %d scheduled blogs", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishDue publishes every scheduled blog whose publish time has passed and returns how many.
+func (p *publishScheduler) publishDue(ctx context.Context) (int, error) {
+	var due []*BlogItem
+	now := now()
+	err := p.store.List(ctx, ListQuery{Statuses: []string{StatusScheduled}}, func(data *BlogItem) error {
+		if !data.PublishTime.After(now) {
+			due = append(due, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, data := range due {
+		// Expecting the listed version skips blogs unpublished or rescheduled in the meantime.
+		updated, err := updateWithRevision(ctx, p.store, data.ID, data.Version, notInTrash, func(version int64) (*BlogItem, error) {
+			return p.store.Update(ctx, &BlogItem{
+				ID:         data.ID,
+				Status:     StatusPublished,
+				UpdateTime: now,
+			}, []string{"status"}, version)
+		})
+		if _, ok := err.(*VersionConflictError); ok || err == ErrNotFound || err == errInTrash {
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+		p.search.Add(updated)
+		p.feed.publish(blogpb.BlogEventType_UPDATED, updated)
+		published++
+	}
+	return published, nil
+}
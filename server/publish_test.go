@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+)
+
+func TestPublishDue(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	create := func(title, status string, publishTime time.Time) *BlogItem {
+		t.Helper()
+		data, err := store.Create(ctx, &BlogItem{
+			AuthorID:    "alice",
+			Title:       title,
+			Content:     "Scheduled content",
+			Status:      status,
+			PublishTime: publishTime,
+			CreateTime:  now(),
+			UpdateTime:  now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	due := create("Due", StatusScheduled, now().Add(-time.Minute))
+	later := create("Later", StatusScheduled, now().Add(time.Hour))
+	draft := create("Draft", StatusDraft, now().Add(-time.Minute))
+
+	search := NewSearchIndex()
+	scheduler := &publishScheduler{store: store, search: search, feed: newChangeFeed(10)}
+	n, err := scheduler.publishDue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Got %d published, want 1", n)
+	}
+
+	want := map[*BlogItem]string{due: StatusPublished, later: StatusScheduled, draft: StatusDraft}
+	for data, status := range want {
+		current, err := store.Read(ctx, data.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current.status() != status {
+			t.Errorf("%s: got status %s, want %s", data.Title, current.status(), status)
+		}
+	}
+
+	// The published blog can be found now.
+	hits, err := search.Search("scheduled", 10, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].ID != due.ID {
+		t.Errorf("Got hits %v, want only the published blog", hits)
+	}
+
+	// Nothing is due anymore.
+	if n, err := scheduler.publishDue(ctx); err != nil || n != 0 {
+		t.Errorf("Got %d published, %v on the second run, want none", n, err)
+	}
+}
+
+func TestSearchLimitCountsOnlyPublishedBlogs(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	// Drafts that match the query better than the published fixture blog.
+	for i := 0; i < 3; i++ {
+		blog := e.newBlog("Conformance conformance conformance")
+		blog.Status = blogpb.BlogStatus_DRAFT
+		if _, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: blog}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := e.blogs.SearchBlogs(ctx, &blogpb.SearchBlogsReq{Query: "conformance", Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetResults()) != 1 || res.GetResults()[0].GetBlog().GetId() != e.blogID {
+		t.Errorf("Got %d results, want the published blog", len(res.GetResults()))
+	}
+
+	// Unpublishing takes it out of the results, publishing brings it back.
+	if _, err := e.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	res, err = e.blogs.SearchBlogs(ctx, &blogpb.SearchBlogsReq{Query: "conformance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetResults()) != 0 {
+		t.Errorf("Got %d results without published blogs, want none", len(res.GetResults()))
+	}
+	if _, err := e.blogs.PublishBlog(ctx, &blogpb.PublishBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	res, err = e.blogs.SearchBlogs(ctx, &blogpb.SearchBlogsReq{Query: "conformance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.GetResults()) != 1 {
+		t.Errorf("Got %d results after publishing again, want 1", len(res.GetResults()))
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"html"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Content formats of a blog, stored as these strings. Blogs from before formats were added
+// have none and are plain text.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+)
+
+var formatToProto = map[string]blogpb.ContentFormat{
+	FormatPlain:    blogpb.ContentFormat_PLAIN,
+	FormatMarkdown: blogpb.ContentFormat_MARKDOWN,
+}
+
+// formatFromProto converts the format of a request, unset is plain text. The error is a gRPC error.
+func formatFromProto(format blogpb.ContentFormat) (string, error) {
+	switch format {
+	case blogpb.ContentFormat_CONTENT_FORMAT_UNSPECIFIED, blogpb.ContentFormat_PLAIN:
+		return FormatPlain, nil
+	case blogpb.ContentFormat_MARKDOWN:
+		return FormatMarkdown, nil
+	}
+	return "", invalidField("blog.content_format", "unknown format %v", format)
+}
+
+// format is the content format of the blog, FormatPlain for blogs from before formats.
+func (b *BlogItem) format() string {
+	if b.ContentFormat == "" {
+		return FormatPlain
+	}
+	return b.ContentFormat
+}
+
+const (
+	// maxExcerptLength is the length of an excerpt in characters.
+	maxExcerptLength = 200
+	// wordsPerMinute is the reading speed behind the reading time.
+	wordsPerMinute = 200
+)
+
+var (
+	// markdown renders with GitHub's extensions and gives every heading an id for the table of contents.
+	// Raw HTML in the content is dropped, which is goldmark's default.
+	markdown = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+	)
+	// sanitizer is the last line of defence against script injection, whatever the renderer lets through.
+	// It keeps the formatting, links (rel="nofollow") and images of user generated content,
+	// as well as the disabled checkboxes of task lists.
+	sanitizer = func() *bluemonday.Policy {
+		p := bluemonday.UGCPolicy()
+		p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+		p.AllowAttrs("checked", "disabled").OnElements("input")
+		return p
+	}()
+	// paragraphBreak separates the paragraphs of plain text.
+	paragraphBreak = regexp.MustCompile(`\n[ \t]*\n`)
+)
+
+// renderBlog renders the content of a blog. The result only depends on the content and its format,
+// so it can be cached for a version of a blog.
+func renderBlog(data *BlogItem) (*blogpb.RenderBlogRes, error) {
+	res := &blogpb.RenderBlogRes{BlogId: data.ID.Hex(), Version: data.Version}
+	// blocks are the paragraphs and such without markup, for the excerpt and word count.
+	var blocks []string
+
+	if data.format() == FormatMarkdown {
+		source := []byte(data.Content)
+		doc := markdown.Parser().Parse(text.NewReader(source))
+		ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			switch n := n.(type) {
+			case *ast.Heading:
+				entry := &blogpb.TocEntry{Level: int32(n.Level), Text: inlineText(n, source)}
+				if id, ok := n.AttributeString("id"); ok {
+					if b, ok := id.([]byte); ok {
+						entry.Anchor = string(b)
+					}
+				}
+				res.Toc = append(res.Toc, entry)
+				return ast.WalkSkipChildren, nil
+			case *ast.FencedCodeBlock, *ast.CodeBlock:
+				return ast.WalkSkipChildren, nil
+			}
+			// Paragraphs, table cells, ... hold the text as inline nodes.
+			if n.Type() == ast.TypeBlock && n.FirstChild() != nil && n.FirstChild().Type() == ast.TypeInline {
+				blocks = append(blocks, inlineText(n, source))
+				return ast.WalkSkipChildren, nil
+			}
+			return ast.WalkContinue, nil
+		})
+
+		var buf bytes.Buffer
+		if err := markdown.Renderer().Render(&buf, source, doc); err != nil {
+			return nil, err
+		}
+		res.Html = sanitizer.Sanitize(buf.String())
+	} else {
+		var buf strings.Builder
+		for _, para := range paragraphBreak.Split(strings.TrimSpace(data.Content), -1) {
+			if para == "" {
+				continue
+			}
+			blocks = append(blocks, para)
+			lines := strings.Split(html.EscapeString(strings.TrimSpace(para)), "\n")
+			buf.WriteString("<p>" + strings.Join(lines, "<br>\n") + "</p>\n")
+		}
+		res.Html = buf.String()
+	}
+
+	words := strings.Fields(strings.Join(blocks, " "))
+	res.WordCount = int32(len(words))
+	res.ReadingTimeMinutes = int32((len(words) + wordsPerMinute - 1) / wordsPerMinute)
+	res.Excerpt = excerpt(words)
+	return res, nil
+}
+
+// inlineText is the text of the inline nodes below n without any markup.
+func inlineText(n ast.Node, source []byte) string {
+	var buf strings.Builder
+	ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			buf.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				buf.WriteByte(' ')
+			}
+		case *ast.String:
+			buf.Write(n.Value)
+		case *ast.AutoLink:
+			buf.Write(n.Label(source))
+		case *ast.RawHTML:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(buf.String())
+}
+
+// excerpt joins the first words up to maxExcerptLength characters, "…" marks that more follow.
+func excerpt(words []string) string {
+	var buf strings.Builder
+	length := 0
+	for i, word := range words {
+		n := utf8.RuneCountInString(word)
+		if i > 0 {
+			n++
+		}
+		// Room is left for the "…" unless this is the last word.
+		if length+n > maxExcerptLength || (length+n == maxExcerptLength && i < len(words)-1) {
+			if i == 0 {
+				// A single word longer than the excerpt is cut.
+				buf.WriteString(string([]rune(word)[:maxExcerptLength-1]))
+			}
+			buf.WriteString("…")
+			break
+		}
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(word)
+		length += n
+	}
+	return buf.String()
+}
+
+// renderKey identifies a version of a blog, whose content never changes.
+type renderKey struct {
+	id      primitive.ObjectID
+	version int64
+}
+
+type renderEntry struct {
+	key renderKey
+	res *blogpb.RenderBlogRes
+}
+
+// renderCache keeps the latest rendered versions, dropping the least recently used ones beyond size.
+// Versions of purged blogs just age out.
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[renderKey]*list.Element
+	// lru holds the entries most recently used first.
+	lru *list.List
+}
+
+func newRenderCache(size int) *renderCache {
+	return &renderCache{
+		size:    size,
+		entries: make(map[renderKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *renderCache) get(key renderKey) (*blogpb.RenderBlogRes, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*renderEntry).res, true
+}
+
+func (c *renderCache) add(key renderKey, res *blogpb.RenderBlogRes) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&renderEntry{key: key, res: res})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*renderEntry).key)
+	}
+}
+
+func (s BlogServiceServer) RenderBlog(ctx context.Context, req *blogpb.RenderBlogReq) (*blogpb.RenderBlogRes, error) {
+	oid, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Could not convert to ObjectId: %v", err)
+	}
+	// Blogs in the trash aren't rendered, like they can't be read by slug or commented on.
+	data, err := readLiveBlog(ctx, s.store, oid)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetVersion() != 0 && req.GetVersion() != data.Version {
+		data, err = s.store.ReadRevision(ctx, oid, req.GetVersion())
+		if err == ErrNotFound {
+			return nil, status.Errorf(codes.NotFound, "Blog %s has no revision %d", req.GetId(), req.GetVersion())
+		}
+		if err != nil {
+			return nil, storeError(err, "Could not read revision %d of blog %s", req.GetVersion(), req.GetId())
+		}
+	}
+
+	// Every version is rendered once, a version never changes.
+	key := renderKey{id: oid, version: data.Version}
+	if res, ok := s.renders.get(key); ok {
+		return res, nil
+	}
+	res, err := renderBlog(data)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not render blog %s: %v", req.GetId(), err)
+	}
+	s.renders.add(key, res)
+	return res, nil
+}
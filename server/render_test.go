@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRenderSanitizesMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// banned must not be in the HTML, kept must.
+		banned []string
+		kept   []string
+	}{
+		{
+			name:    "script tag",
+			content: "Hello\n\n<script>alert(1)</script>\n\nworld",
+			banned:  []string{"<script", "alert(1)"},
+			kept:    []string{"Hello", "world"},
+		},
+		{
+			name:    "inline script tag",
+			content: "Hello <script>alert(1)</script> world",
+			banned:  []string{"<script"},
+		},
+		{
+			name:    "javascript link",
+			content: "[click](javascript:alert(1))",
+			banned:  []string{"javascript:"},
+			kept:    []string{"click"},
+		},
+		{
+			name:    "javascript link in raw HTML",
+			content: `<a href="javascript:alert(1)">click</a>`,
+			banned:  []string{"javascript:"},
+		},
+		{
+			name:    "event handler",
+			content: `<img src="x.png" onerror="alert(1)">`,
+			banned:  []string{"onerror"},
+		},
+		{
+			name:    "image with a data URL",
+			content: "![x](data:text/html;base64,PHNjcmlwdD4=)",
+			banned:  []string{"data:text/html"},
+		},
+		{
+			name:    "links stay",
+			content: "[home](https://example.com)",
+			kept:    []string{`href="https://example.com"`, `rel="nofollow"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := renderBlog(&BlogItem{ID: primitive.NewObjectID(), Content: tt.content, ContentFormat: FormatMarkdown})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.banned {
+				if strings.Contains(strings.ToLower(res.GetHtml()), s) {
+					t.Errorf("%q is in the HTML: %s", s, res.GetHtml())
+				}
+			}
+			for _, s := range tt.kept {
+				if !strings.Contains(res.GetHtml(), s) {
+					t.Errorf("%q is missing from the HTML: %s", s, res.GetHtml())
+				}
+			}
+		})
+	}
+}
+
+func TestRenderEscapesPlainText(t *testing.T) {
+	res, err := renderBlog(&BlogItem{ID: primitive.NewObjectID(), Content: "<script>alert(1)</script>\n\nSecond paragraph"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n<p>Second paragraph</p>\n"
+	if res.GetHtml() != want {
+		t.Errorf("Got HTML %q, want %q", res.GetHtml(), want)
+	}
+}
+
+func TestRenderHidesTrashedBlogs(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	if _, err := e.blogs.RenderBlog(ctx, &blogpb.RenderBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	// Not even a version rendered before.
+	for _, version := range []int64{0, 1, 2} {
+		_, err := e.blogs.RenderBlog(ctx, &blogpb.RenderBlogReq{Id: e.blogID, Version: version})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("Version %d: got %v, want NotFound", version, err)
+		}
+	}
+}
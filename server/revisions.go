@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// A revision is a snapshot of a blog at one version, saved before every change that makes a new
+// version: UpdateBlog, RevertBlog, publishing, moving to and out of the trash and the scheduler.
+// Revisions are BlogItems: the revision number is the version, its time the update time and the
+// editor the one who made that version.
+
+// maxUpdateAttempts bounds the retries of an unconditional update racing with other updates.
+const maxUpdateAttempts = 3
+
+// updateWithRevision saves the current state of a blog as a revision, then calls update
+// with the version of that state so no change slips in between unrecorded.
+// Unless expectedVersion is 0 the blog must be at that version. Otherwise updates
+// racing with this one are retried, the client asked to overwrite anyway.
+// check, if not nil, is called with the current state first, its error ends the update.
+func updateWithRevision(ctx context.Context, store BlogStore, id primitive.ObjectID, expectedVersion int64,
+	check func(current *BlogItem) error, update func(version int64) (*BlogItem, error)) (*BlogItem, error) {
+	for attempt := 1; ; attempt++ {
+		current, err := store.Read(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if expectedVersion != 0 && current.Version != expectedVersion {
+			return nil, &VersionConflictError{Current: current.Version}
+		}
+		if check != nil {
+			if err := check(current); err != nil {
+				return nil, err
+			}
+		}
+		if err := store.SaveRevision(ctx, current); err != nil {
+			return nil, err
+		}
+		updated, err := update(current.Version)
+		if _, ok := err.(*VersionConflictError); ok && expectedVersion == 0 && attempt < maxUpdateAttempts {
+			continue
+		}
+		return updated, err
+	}
+}
+
+// toRevisionProto converts a revision, the snapshot itself is only included with withBlog.
+func (b *BlogItem) toRevisionProto(withBlog bool) *blogpb.Revision {
+	rev := &blogpb.Revision{
+		BlogId: b.ID.Hex(),
+		Number: b.Version,
+		Time:   timestampOrNil(b.UpdateTime),
+		Editor: b.Editor,
+	}
+	if withBlog {
+		rev.Blog = b.toProto()
+	}
+	return rev
+}
+
+// addRevision adds rev to revisions, kept oldest first, unless its version is there already.
+// Stores that keep the revisions in memory share it.
+func addRevision(revisions []BlogItem, rev BlogItem) []BlogItem {
+	i := sort.Search(len(revisions), func(i int) bool { return revisions[i].Version >= rev.Version })
+	if i < len(revisions) && revisions[i].Version == rev.Version {
+		return revisions
+	}
+	revisions = append(revisions, BlogItem{})
+	copy(revisions[i+1:], revisions[i:])
+	revisions[i] = rev
+	return revisions
+}
+
+// newestFirst copies revisions kept oldest first into a new slice, newest first.
+func newestFirst(revisions []BlogItem) []*BlogItem {
+	list := make([]*BlogItem, 0, len(revisions))
+	for i := len(revisions) - 1; i >= 0; i-- {
+		rev := revisions[i]
+		list = append(list, &rev)
+	}
+	return list
+}
+
+// findRevision returns a copy of the revision at the given version or ErrNotFound.
+func findRevision(revisions []BlogItem, version int64) (*BlogItem, error) {
+	i := sort.Search(len(revisions), func(i int) bool { return revisions[i].Version >= version })
+	if i == len(revisions) || revisions[i].Version != version {
+		return nil, ErrNotFound
+	}
+	rev := revisions[i]
+	return &rev, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestEveryVersionHasARevision makes a new version of the blog each way there is and
+// checks each of the old versions can still be read.
+func TestEveryVersionHasARevision(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	id := e.blogID
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"update", func() error {
+			_, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+				Blog:       &blogpb.Blog{Id: id, Content: "Edited"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"content"}},
+				Editor:     "bob",
+			})
+			return err
+		}},
+		{"unpublish", func() error {
+			_, err := e.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{Id: id})
+			return err
+		}},
+		{"publish", func() error {
+			_, err := e.blogs.PublishBlog(ctx, &blogpb.PublishBlogReq{Id: id})
+			return err
+		}},
+		{"delete", func() error {
+			_, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: id})
+			return err
+		}},
+		{"restore", func() error {
+			_, err := e.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogReq{Id: id})
+			return err
+		}},
+		{"revert", func() error {
+			_, err := e.blogs.RevertBlog(ctx, &blogpb.RevertBlogReq{BlogId: id, Number: 1})
+			return err
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+	}
+
+	current, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture made version 2, every step one more.
+	if got, want := current.GetBlog().GetVersion(), int64(2+len(steps)); got != want {
+		t.Fatalf("Got version %d, want %d", got, want)
+	}
+	res, err := e.blogs.ListRevisions(ctx, &blogpb.ListRevisionsReq{BlogId: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(res.GetRevisions()), int(current.GetBlog().GetVersion()-1); got != want {
+		t.Errorf("Got %d revisions, want %d", got, want)
+	}
+	for number := int64(1); number < current.GetBlog().GetVersion(); number++ {
+		if _, err := e.blogs.GetRevision(ctx, &blogpb.GetRevisionReq{BlogId: id, Number: number}); err != nil {
+			t.Errorf("Revision %d: %v", number, err)
+		}
+	}
+}
+
+func TestScheduledPublishingSavesARevision(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	// The fixture blog is published, schedule it instead.
+	if _, err := e.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	scheduled, err := e.blogs.PublishBlog(ctx, &blogpb.PublishBlogReq{Id: e.blogID, PublishTime: timestamppb.New(time.Now().Add(50 * time.Millisecond))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	scheduler := &publishScheduler{store: e.store, search: NewSearchIndex(), feed: newChangeFeed(10)}
+	if n, err := scheduler.publishDue(ctx); err != nil || n != 1 {
+		t.Fatalf("Got %d published, %v, want 1", n, err)
+	}
+	if _, err := e.blogs.GetRevision(ctx, &blogpb.GetRevisionReq{BlogId: e.blogID, Number: scheduled.GetBlog().GetVersion()}); err != nil {
+		t.Errorf("The scheduled version has no revision: %v", err)
+	}
+}
+
+func TestUpdateKeepsTheEditor(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	update := func(title, editor string) *blogpb.Blog {
+		t.Helper()
+		res, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+			Blog:       &blogpb.Blog{Id: e.blogID, Title: title},
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+			Editor:     editor,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.GetBlog()
+	}
+
+	if got := update("By Bob", "bob").GetEditor(); got != "bob" {
+		t.Errorf("Got editor %q, want bob", got)
+	}
+	if got := update("Still Bob", "").GetEditor(); got != "bob" {
+		t.Errorf("An update without editor changed it to %q", got)
+	}
+	if got := update("By Carol", "carol").GetEditor(); got != "carol" {
+		t.Errorf("Got editor %q, want carol", got)
+	}
+}
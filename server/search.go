@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Search fields, a query term without prefix searches both.
+const (
+	searchFieldTitle   = 0
+	searchFieldContent = 1
+	searchFieldCount   = 2
+)
+
+var searchFieldNames = map[string]int{"title": searchFieldTitle, "content": searchFieldContent}
+
+// A match in the title is worth more than one in the content.
+var searchFieldBoost = [searchFieldCount]float64{2.0, 1.0}
+
+// BM25 parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// token is a word of a field, lower cased, with its byte offsets in the original text for highlighting.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into words made of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// indexedDoc is what the index keeps of a blog: the text and tokens of every field.
+type indexedDoc struct {
+	text   [searchFieldCount]string
+	tokens [searchFieldCount][]token
+}
+
+// SearchIndex is an in-process inverted index over the title and content of the published blogs.
+// It is filled from the store at startup and kept up to date by the mutating RPCs,
+// so it works the same whatever store is used. Drafts, scheduled, archived and trashed blogs
+// aren't indexed, so the best hits of a query are blogs SearchBlogs can return.
+type SearchIndex struct {
+	mu   sync.RWMutex
+	docs map[primitive.ObjectID]*indexedDoc
+	// postings maps a term to the positions (token indexes) it has in each field of each doc.
+	postings map[string]map[primitive.ObjectID]*[searchFieldCount][]int
+	// totalLen is the number of tokens per field over all docs, for the average field length of BM25.
+	totalLen [searchFieldCount]int
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		docs:     make(map[primitive.ObjectID]*indexedDoc),
+		postings: make(map[string]map[primitive.ObjectID]*[searchFieldCount][]int),
+	}
+}
+
+// Build indexes the published blogs of the store.
+func (idx *SearchIndex) Build(ctx context.Context, store BlogStore) error {
+	return store.List(ctx, ListQuery{Statuses: []string{StatusPublished}}, func(data *BlogItem) error {
+		idx.Add(data)
+		return nil
+	})
+}
+
+// Add indexes a blog, replacing its previous version if it was indexed already.
+// A blog that can't be found by searching, e.g. one that was unpublished, is removed instead.
+func (idx *SearchIndex) Add(data *BlogItem) {
+	if data.status() != StatusPublished || !data.DeleteTime.IsZero() {
+		idx.Remove(data.ID)
+		return
+	}
+	doc := &indexedDoc{text: [searchFieldCount]string{data.Title, data.Content}}
+	for field := range doc.text {
+		doc.tokens[field] = tokenize(doc.text[field])
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(data.ID)
+	idx.docs[data.ID] = doc
+	for field, tokens := range doc.tokens {
+		idx.totalLen[field] += len(tokens)
+		for pos, tok := range tokens {
+			docs := idx.postings[tok.term]
+			if docs == nil {
+				docs = make(map[primitive.ObjectID]*[searchFieldCount][]int)
+				idx.postings[tok.term] = docs
+			}
+			positions := docs[data.ID]
+			if positions == nil {
+				positions = &[searchFieldCount][]int{}
+				docs[data.ID] = positions
+			}
+			positions[field] = append(positions[field], pos)
+		}
+	}
+}
+
+// Remove drops a blog from the index.
+func (idx *SearchIndex) Remove(id primitive.ObjectID) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+// remove must be called with idx.mu held.
+func (idx *SearchIndex) remove(id primitive.ObjectID) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for field, tokens := range doc.tokens {
+		idx.totalLen[field] -= len(tokens)
+		for _, tok := range tokens {
+			if docs := idx.postings[tok.term]; docs != nil {
+				delete(docs, id)
+				if len(docs) == 0 {
+					delete(idx.postings, tok.term)
+				}
+			}
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// SearchHit is a blog matching a query.
+type SearchHit struct {
+	ID    primitive.ObjectID
+	Score float64
+	// Title and content snippet with the matched words wrapped in the highlight tags.
+	TitleHighlight string
+	Snippet        string
+}
+
+// Search returns the best limit blogs matching query, best first.
+func (idx *SearchIndex) Search(query string, limit int, preTag, postTag string) ([]SearchHit, error) {
+	q, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	// Union of the OR groups, within a group every clause must match.
+	scores := map[primitive.ObjectID]float64{}
+	matched := map[primitive.ObjectID]*[searchFieldCount]map[int]bool{}
+	for _, group := range q {
+		groupScores, groupMatched := idx.evalGroup(group)
+		for id, score := range groupScores {
+			scores[id] += score
+			if matched[id] == nil {
+				matched[id] = &[searchFieldCount]map[int]bool{{}, {}}
+			}
+			for field := range groupMatched[id] {
+				for pos := range groupMatched[id][field] {
+					matched[id][field][pos] = true
+				}
+			}
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, SearchHit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return bytes.Compare(hits[i].ID[:], hits[j].ID[:]) < 0
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	for i := range hits {
+		doc := idx.docs[hits[i].ID]
+		m := matched[hits[i].ID]
+		hits[i].TitleHighlight = highlight(doc.text[searchFieldTitle], doc.tokens[searchFieldTitle], m[searchFieldTitle], 0, len(doc.tokens[searchFieldTitle]), preTag, postTag)
+		hits[i].Snippet = snippet(doc.text[searchFieldContent], doc.tokens[searchFieldContent], m[searchFieldContent], preTag, postTag)
+	}
+	return hits, nil
+}
+
+// evalGroup scores the docs matching every clause of an AND group
+// and collects the token positions that matched, for highlighting.
+func (idx *SearchIndex) evalGroup(group []searchClause) (map[primitive.ObjectID]float64, map[primitive.ObjectID]*[searchFieldCount]map[int]bool) {
+	var scores map[primitive.ObjectID]float64
+	matched := map[primitive.ObjectID]*[searchFieldCount]map[int]bool{}
+	for _, clause := range group {
+		clauseScores := idx.evalClause(clause, matched)
+		if scores == nil {
+			scores = clauseScores
+			continue
+		}
+		for id := range scores {
+			if score, ok := clauseScores[id]; ok {
+				scores[id] += score
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+	return scores, matched
+}
+
+// evalClause scores the docs matching a word or phrase and records the matched positions.
+func (idx *SearchIndex) evalClause(clause searchClause, matched map[primitive.ObjectID]*[searchFieldCount]map[int]bool) map[primitive.ObjectID]float64 {
+	scores := map[primitive.ObjectID]float64{}
+	first := idx.postings[clause.terms[0]]
+	for id, positions := range first {
+		for field := 0; field < searchFieldCount; field++ {
+			if clause.field >= 0 && clause.field != field {
+				continue
+			}
+			// Phrase starts: positions of the first term followed by the other terms in order.
+			var starts []int
+			for _, pos := range positions[field] {
+				if idx.phraseAt(id, field, clause.terms, pos) {
+					starts = append(starts, pos)
+				}
+			}
+			if len(starts) == 0 {
+				continue
+			}
+
+			for _, term := range clause.terms {
+				scores[id] += searchFieldBoost[field] * idx.bm25(term, id, field, len(starts))
+			}
+			if matched[id] == nil {
+				matched[id] = &[searchFieldCount]map[int]bool{{}, {}}
+			}
+			for _, start := range starts {
+				for i := range clause.terms {
+					matched[id][field][start+i] = true
+				}
+			}
+		}
+	}
+	return scores
+}
+
+// phraseAt reports whether terms appear in order starting at token pos of the field.
+func (idx *SearchIndex) phraseAt(id primitive.ObjectID, field int, terms []string, pos int) bool {
+	tokens := idx.docs[id].tokens[field]
+	if pos+len(terms) > len(tokens) {
+		return false
+	}
+	for i, term := range terms {
+		if tokens[pos+i].term != term {
+			return false
+		}
+	}
+	return true
+}
+
+// bm25 is the relevance of a term occurring freq times in a field of a doc.
+func (idx *SearchIndex) bm25(term string, id primitive.ObjectID, field int, freq int) float64 {
+	n := float64(len(idx.docs))
+	df := float64(len(idx.postings[term]))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+	avgLen := float64(idx.totalLen[field]) / n
+	docLen := float64(len(idx.docs[id].tokens[field]))
+	tf := float64(freq)
+	norm := 1 - bm25B
+	if avgLen > 0 {
+		norm += bm25B * docLen / avgLen
+	}
+	return idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+}
+
+// snippetWords is the number of words around the first match shown in a content snippet.
+const snippetWords = 30
+
+// snippet cuts the part of the content around the first match and highlights the matches in it.
+// Without a match in the content it shows the beginning of the content.
+func snippet(text string, tokens []token, matched map[int]bool, preTag, postTag string) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	first := len(tokens)
+	for pos := range matched {
+		if pos < first {
+			first = pos
+		}
+	}
+	if first == len(tokens) {
+		first = 0
+	}
+
+	from := first - snippetWords/3
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords
+	if to > len(tokens) {
+		to = len(tokens)
+	}
+
+	out := highlight(text, tokens, matched, from, to, preTag, postTag)
+	if from > 0 {
+		out = "..." + out
+	}
+	if to < len(tokens) {
+		out += "..."
+	}
+	return out
+}
+
+// highlight returns the text from token from to token to (exclusive) with the matched tokens wrapped in tags.
+func highlight(text string, tokens []token, matched map[int]bool, from, to int, preTag, postTag string) string {
+	if from >= to {
+		return ""
+	}
+	var b strings.Builder
+	offset := tokens[from].start
+	if from == 0 {
+		offset = 0
+	}
+	for pos := from; pos < to; pos++ {
+		tok := tokens[pos]
+		// Matches only separated by spaces, like a phrase, share a single pair of tags.
+		b.WriteString(text[offset:tok.start])
+		if matched[pos] && !(pos > from && matched[pos-1] && joined(text, tokens[pos-1], tok)) {
+			b.WriteString(preTag)
+		}
+		b.WriteString(text[tok.start:tok.end])
+		if matched[pos] && !(pos+1 < to && matched[pos+1] && joined(text, tok, tokens[pos+1])) {
+			b.WriteString(postTag)
+		}
+		offset = tok.end
+	}
+	if to == len(tokens) {
+		b.WriteString(text[offset:])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// joined reports whether only spaces separate two tokens.
+func joined(text string, a, b token) bool {
+	return strings.TrimSpace(text[a.end:b.start]) == ""
+}
+
+// searchClause is a word or a phrase, optionally restricted to one field.
+type searchClause struct {
+	field int // -1 for any field
+	terms []string
+}
+
+// A parsed query is a list of OR'ed groups of AND'ed clauses.
+type searchQuery [][]searchClause
+
+// parseSearchQuery parses queries like
+//
+//	go grpc                  both words (AND is implied)
+//	"protocol buffers"       the exact phrase
+//	title:mongo OR sqlite    either of them, mongo only in the title
+//	title:"hello world" AND content:go
+//
+// Words are matched case insensitively, AND binds stronger than OR.
+func parseSearchQuery(query string) (searchQuery, error) {
+	var q searchQuery
+	var group []searchClause
+	rest := strings.TrimSpace(query)
+	for rest != "" {
+		var word string
+		word, rest = nextSearchWord(rest)
+
+		switch word {
+		case "AND":
+			continue
+		case "OR":
+			if len(group) == 0 {
+				return nil, fmt.Errorf("OR without a search term before it")
+			}
+			q = append(q, group)
+			group = nil
+			continue
+		}
+
+		clause := searchClause{field: -1}
+		if i := strings.Index(word, ":"); i > 0 {
+			if field, ok := searchFieldNames[strings.ToLower(word[:i])]; ok {
+				clause.field = field
+				word = word[i+1:]
+			}
+		}
+		if strings.HasPrefix(word, `"`) {
+			word = strings.Trim(word, `"`)
+		}
+		for _, tok := range tokenize(word) {
+			clause.terms = append(clause.terms, tok.term)
+		}
+		// Words made of punctuation only can't match anything, ignore them.
+		if len(clause.terms) > 0 {
+			group = append(group, clause)
+		}
+	}
+	if len(group) == 0 {
+		if len(q) == 0 {
+			return nil, fmt.Errorf("empty search query")
+		}
+		return nil, fmt.Errorf("OR without a search term after it")
+	}
+	return append(q, group), nil
+}
+
+// nextSearchWord splits off the first word of s, a quoted phrase (possibly after a field prefix) is one word.
+func nextSearchWord(s string) (word, rest string) {
+	end := 0
+	quoted := false
+	for end < len(s) {
+		c := s[end]
+		if c == '"' {
+			quoted = !quoted
+		} else if (c == ' ' || c == '\t' || c == '\n') && !quoted {
+			break
+		}
+		end++
+	}
+	return s[:end], strings.TrimSpace(s[end:])
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestIndex indexes a published blog for every title/content pair and returns the index
+// with the titles by blog ID.
+func newTestIndex(docs [][2]string) (*SearchIndex, map[primitive.ObjectID]string) {
+	idx := NewSearchIndex()
+	titles := make(map[primitive.ObjectID]string)
+	for _, doc := range docs {
+		data := &BlogItem{ID: primitive.NewObjectID(), Title: doc[0], Content: doc[1], Status: StatusPublished}
+		idx.Add(data)
+		titles[data.ID] = data.Title
+	}
+	return idx, titles
+}
+
+func hitTitles(t *testing.T, idx *SearchIndex, titles map[primitive.ObjectID]string, query string) []string {
+	t.Helper()
+	hits, err := idx.Search(query, 0, "", "")
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	got := []string{}
+	for _, hit := range hits {
+		got = append(got, titles[hit.ID])
+	}
+	return got
+}
+
+func TestSearchQueries(t *testing.T) {
+	idx, titles := newTestIndex([][2]string{
+		{"Go and gRPC", "Serving protocol buffers over HTTP/2."},
+		{"SQLite notes", "Buffers of the protocol are not involved."},
+		{"Mongo in production", "Go drivers for MongoDB and SQLite."},
+		{"Hello world", "A first program in Go."},
+	})
+	tests := []struct {
+		query string
+		// want is sorted, the ranking is checked separately.
+		want []string
+	}{
+		{"grpc", []string{"Go and gRPC"}},
+		{"GRPC", []string{"Go and gRPC"}},
+		{"go grpc", []string{"Go and gRPC"}},
+		{"go AND grpc", []string{"Go and gRPC"}},
+		{"grpc OR sqlite", []string{"Go and gRPC", "Mongo in production", "SQLite notes"}},
+		{"grpc OR hello world", []string{"Go and gRPC", "Hello world"}},
+		{`"protocol buffers"`, []string{"Go and gRPC"}},
+		{"protocol buffers", []string{"Go and gRPC", "SQLite notes"}},
+		{"title:sqlite", []string{"SQLite notes"}},
+		{"content:sqlite", []string{"Mongo in production"}},
+		{`title:"hello world" AND content:go`, []string{"Hello world"}},
+		{`title:"world hello"`, []string{}},
+		{"rust", []string{}},
+	}
+	for _, tt := range tests {
+		got := hitTitles(t, idx, titles, tt.query)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	for _, query := range []string{"", "   ", "OR go", "go OR", "go OR OR sqlite"} {
+		if _, err := idx.Search(query, 0, "", ""); err == nil {
+			t.Errorf("%q: no error", query)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	filler := strings.Repeat("other words ", 20)
+	idx, titles := newTestIndex([][2]string{
+		{"Nothing here", "Once about mongo. " + filler},
+		{"Mongo", "Not in the content."},
+		{"Still nothing", "Mongo mongo mongo. " + filler},
+		{"Short one", "Mongo."},
+	})
+	// A title match is worth more than the content, then more and denser matches rank higher.
+	want := []string{"Mongo", "Short one", "Still nothing", "Nothing here"}
+	if got := hitTitles(t, idx, titles, "mongo"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	hits, err := idx.Search("mongo", 2, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 || titles[hits[0].ID] != "Mongo" {
+		t.Errorf("Got %d hits with limit 2, want the best 2", len(hits))
+	}
+}
+
+func TestSearchIndexUpdates(t *testing.T) {
+	idx := NewSearchIndex()
+	data := &BlogItem{ID: primitive.NewObjectID(), Title: "Mongo", Content: "Old content", Status: StatusPublished}
+	idx.Add(data)
+	count := func(query string) int {
+		t.Helper()
+		hits, err := idx.Search(query, 0, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(hits)
+	}
+
+	changed := *data
+	changed.Title = "SQLite"
+	idx.Add(&changed)
+	if count("mongo") != 0 || count("sqlite") != 1 {
+		t.Error("The old title is still indexed after an update")
+	}
+	changed.Status = StatusDraft
+	idx.Add(&changed)
+	if count("sqlite") != 0 {
+		t.Error("A draft is indexed")
+	}
+	idx.Add(data)
+	idx.Remove(data.ID)
+	if count("mongo") != 0 || len(idx.postings) != 0 {
+		t.Errorf("Got %d terms left after removing the only blog", len(idx.postings))
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	idx, _ := newTestIndex([][2]string{
+		{"Go and gRPC", "Some text first. Serving protocol buffers with Go."},
+	})
+	hits, err := idx.Search(`go "protocol buffers"`, 0, "<b>", "</b>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 {
+		t.Fatalf("Got %d hits, want 1", len(hits))
+	}
+	if want := "<b>Go</b> and gRPC"; hits[0].TitleHighlight != want {
+		t.Errorf("Got title %q, want %q", hits[0].TitleHighlight, want)
+	}
+	for _, s := range []string{"<b>protocol buffers</b>", "<b>Go</b>."} {
+		if !strings.Contains(hits[0].Snippet, s) {
+			t.Errorf("%q is missing from the snippet %q", s, hits[0].Snippet)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrSlugTaken is returned by a BlogStore when a slug already belongs to another blog.
+var ErrSlugTaken = errors.New("slug already taken")
+
+const (
+	// maxSlugLength keeps generated slugs readable, long titles are cut at a word boundary.
+	maxSlugLength = 60
+	// Suffixes tried before falling back to a random one: my-title, my-title-2, ..., my-title-20.
+	maxSlugSuffix = 20
+)
+
+// slugFolder turns common accented Latin letters into their ASCII base letter.
+var slugFolder = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "œ", "oe",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y", "ß", "ss",
+)
+
+// slugify makes a URL friendly slug out of a title: "Hello, Wörld!" becomes "hello-world".
+// Only a-z, 0-9 and single dashes between words remain, which may leave nothing at all.
+func slugify(title string) string {
+	folded := slugFolder.Replace(strings.ToLower(title))
+	var b strings.Builder
+	dash := false
+	for _, r := range folded {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		if i := strings.LastIndexByte(slug, '-'); i > 0 {
+			slug = slug[:i]
+		}
+	}
+	return slug
+}
+
+// validateSlug checks a slug chosen by a client is already in the form slugify produces.
+func validateSlug(slug string) error {
+	if utf8.RuneCountInString(slug) > maxSlugLength {
+		return fmt.Errorf("is longer than %d characters", maxSlugLength)
+	}
+	if !isSlug(slug) {
+		return fmt.Errorf("%q may only contain lower case letters a-z, digits and single dashes between them, like %q", slug, slugify(slug))
+	}
+	return nil
+}
+
+// isSlug reports whether s is in the form slugify produces, whatever its length.
+func isSlug(s string) bool {
+	prev := byte('-')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-' && prev != '-':
+		default:
+			return false
+		}
+		prev = c
+	}
+	return prev != '-'
+}
+
+// slugCandidates returns the slugs to try in order for a blog titled title.
+// A title without any usable character falls back to "blog".
+func slugCandidates(title string) []string {
+	base := slugify(title)
+	if base == "" {
+		base = "blog"
+	}
+	candidates := []string{base}
+	for i := 2; i <= maxSlugSuffix; i++ {
+		candidates = append(candidates, withSlugSuffix(base, strconv.Itoa(i)))
+	}
+	// Everything taken, e.g. a very common title: a random suffix won't collide.
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return append(candidates, withSlugSuffix(base, hex.EncodeToString(suffix)))
+}
+
+// withSlugSuffix appends "-suffix" to base, cutting base short so the slug stays within maxSlugLength.
+func withSlugSuffix(base, suffix string) string {
+	if max := maxSlugLength - len(suffix) - 1; len(base) > max {
+		base = strings.TrimRight(base[:max], "-")
+	}
+	return base + "-" + suffix
+}
+
+// addSlug adds slug to the slugs a blog ever had, unless it is already one of them.
+func addSlug(slugs []string, slug string) []string {
+	for _, s := range slugs {
+		if s == slug {
+			return slugs
+		}
+	}
+	// Full slice expression: never append into an array shared with a stored blog.
+	return append(slugs[:len(slugs):len(slugs)], slug)
+}
+
+// slugIndex maps every slug, current or old, to its blog for the stores keeping everything in memory.
+type slugIndex map[string]primitive.ObjectID
+
+// available reports whether none of the slugs of data belong to another blog.
+func (idx slugIndex) available(data *BlogItem) bool {
+	for _, slug := range data.Slugs {
+		if id, ok := idx[slug]; ok && id != data.ID {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx slugIndex) add(data *BlogItem) {
+	for _, slug := range data.Slugs {
+		idx[slug] = data.ID
+	}
+}
+
+func (idx slugIndex) remove(data *BlogItem) {
+	for _, slug := range data.Slugs {
+		delete(idx, slug)
+	}
+}
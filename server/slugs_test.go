@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Hello, Wörld!":             "hello-world",
+		"  Leading and trailing ":   "leading-and-trailing",
+		"Straße à la crème":         "strasse-a-la-creme",
+		"gRPC -- the _basics_":      "grpc-the-basics",
+		"2024 in review":            "2024-in-review",
+		"日本語":                       "",
+		strings.Repeat("word ", 20): strings.TrimSuffix(strings.Repeat("word-", 12), "-"),
+	}
+	for title, want := range tests {
+		if got := slugify(title); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", title, got, want)
+		}
+	}
+
+	for _, slug := range []string{"Upper", "two--dashes", "-leading", "space here", strings.Repeat("a", maxSlugLength+1)} {
+		if err := validateSlug(slug); err == nil {
+			t.Errorf("%q is valid", slug)
+		}
+	}
+	if err := validateSlug("hello-world-2"); err != nil {
+		t.Error(err)
+	}
+	// Suffixes cut the base short instead of going over the limit.
+	for _, slug := range slugCandidates(strings.Repeat("a", maxSlugLength)) {
+		if err := validateSlug(slug); err != nil {
+			t.Errorf("Candidate %q: %v", slug, err)
+		}
+	}
+}
+
+// renamedBlog creates a blog titled "Old title" and renames it to "New title".
+func (e *testEnv) renamedBlog(ctx context.Context, t *testing.T) *blogpb.Blog {
+	t.Helper()
+	created, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Old title")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+		Blog:       &blogpb.Blog{Id: created.GetBlog().GetId(), Title: "New title"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return updated.GetBlog()
+}
+
+func TestSlugCollisions(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	create := func(blog *blogpb.Blog) (string, error) {
+		res, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: blog})
+		return res.GetBlog().GetSlug(), err
+	}
+
+	for _, want := range []string{"hello-world", "hello-world-2", "hello-world-3"} {
+		if got, err := create(e.newBlog("Hello, World")); err != nil || got != want {
+			t.Errorf("Got slug %q, %v, want %q", got, err, want)
+		}
+	}
+	// Titles without a usable character still get a slug.
+	if got, err := create(e.newBlog("!!!")); err != nil || got != "blog" {
+		t.Errorf("Got slug %q, %v, want blog", got, err)
+	}
+	// The first slug of a renamed blog still belongs to it.
+	renamed := e.renamedBlog(ctx, t)
+	if got, err := create(e.newBlog("Old title")); err != nil || got != "old-title-2" {
+		t.Errorf("Got slug %q, %v, want old-title-2", got, err)
+	}
+
+	// An explicit slug is never suffixed.
+	blog := e.newBlog("Anything")
+	blog.Slug = "hello-world"
+	if _, err := create(blog); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Got %v for a taken explicit slug, want AlreadyExists", err)
+	}
+	blog.Slug = "Not A Slug"
+	if _, err := create(blog); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Got %v for an invalid explicit slug, want InvalidArgument", err)
+	}
+	// Colliding titles as long as a slug may be still get slugs that can be read back.
+	long := strings.Repeat("word ", 12)
+	for i := 0; i < 2; i++ {
+		res, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog(long)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := e.blogs.ReadBlogBySlug(ctx, &blogpb.ReadBlogBySlugReq{Slug: res.GetBlog().GetSlug()})
+		if err != nil || read.GetBlog().GetId() != res.GetBlog().GetId() {
+			t.Errorf("Reading %q: %v", res.GetBlog().GetSlug(), err)
+		}
+	}
+
+	_, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+		Blog:       &blogpb.Blog{Id: renamed.GetId(), Slug: "hello-world-2"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"slug"}},
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Got %v updating to a taken slug, want AlreadyExists", err)
+	}
+}
+
+func TestSlugRedirects(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	read := func(slug string) (*blogpb.ReadBlogBySlugRes, error) {
+		return e.blogs.ReadBlogBySlug(ctx, &blogpb.ReadBlogBySlugReq{Slug: slug})
+	}
+	blog := e.renamedBlog(ctx, t)
+
+	res, err := read("new-title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetBlog().GetId() != blog.GetId() || res.GetMoved() {
+		t.Errorf("Got blog %s moved %v for the current slug", res.GetBlog().GetId(), res.GetMoved())
+	}
+	res, err = read("old-title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetBlog().GetId() != blog.GetId() || !res.GetMoved() || res.GetBlog().GetSlug() != "new-title" {
+		t.Errorf("Got blog %s moved %v to %q for the old slug, want a move to new-title", res.GetBlog().GetId(), res.GetMoved(), res.GetBlog().GetSlug())
+	}
+
+	// An explicit slug replaces the current one, the others keep redirecting.
+	_, err = e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+		Blog:       &blogpb.Blog{Id: blog.GetId(), Slug: "chosen"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"slug"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slug := range []string{"old-title", "new-title"} {
+		if res, err := read(slug); err != nil || res.GetBlog().GetSlug() != "chosen" || !res.GetMoved() {
+			t.Errorf("%s: got %v, want a move to chosen", slug, err)
+		}
+	}
+
+	if _, err := read("missing"); status.Code(err) != codes.NotFound {
+		t.Errorf("Got %v for a missing slug, want NotFound", err)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ErrNotFound is returned by a BlogStore when no blog matches the given ID.
+var ErrNotFound = errors.New("blog not found")
+
+// ErrUnavailable is wrapped by the errors of a store that can't be reached right now,
+// clients may retry later. The errors of the MongoDB and SQL drivers are recognized
+// without it, see isUnavailable.
+var ErrUnavailable = errors.New("store unavailable")
+
+// VersionConflictError is returned by BlogStore.Update when the blog
+// is no longer at the version the update was based on.
+type VersionConflictError struct {
+	Current int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("blog has been modified, current version is %d", e.Current)
+}
+
+// BlogStore is the storage behind BlogServiceServer.
+// Every backend (MongoDB, in-memory, ...) implements the same CRUD semantics,
+// so the gRPC handlers never have to know where the blogs actually live.
+type BlogStore interface {
+	// Create inserts a new blog, the ID is generated by the store and set on the returned item.
+	// Create and Update fail with ErrSlugTaken if one of the slugs of the blog belongs to another blog.
+	Create(ctx context.Context, item *BlogItem) (*BlogItem, error)
+	// CreateMany inserts a batch of new blogs like Create, in one round trip where the backend allows it.
+	// created and errs line up with items: created[i] is the new blog, or nil with the reason in errs[i].
+	// A failing blog, e.g. with ErrSlugTaken, doesn't keep the others from being created.
+	CreateMany(ctx context.Context, items []*BlogItem) (created []*BlogItem, errs []error)
+	// Read returns the blog with the given ID or ErrNotFound.
+	Read(ctx context.Context, id primitive.ObjectID) (*BlogItem, error)
+	// ReadBySlug returns the blog that has or once had the given slug, or ErrNotFound.
+	ReadBySlug(ctx context.Context, slug string) (*BlogItem, error)
+	// Update copies the given fields (see updatableFields) and UpdateTime from item to the existing blog,
+	// increments its version and returns the updated blog. Unless expectedVersion is 0 the update
+	// only happens if the blog is still at that version, otherwise a *VersionConflictError is returned.
+	Update(ctx context.Context, item *BlogItem, fields []string, expectedVersion int64) (*BlogItem, error)
+	// Delete removes the blog with the given ID, its revisions, comments and attachments for good,
+	// or fails with ErrNotFound. Moving a blog to the trash is an Update of delete_time.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// List calls fn for every blog selected by q in the order of q, stopping at the first error.
+	List(ctx context.Context, q ListQuery, fn func(*BlogItem) error) error
+	// SaveRevision keeps a snapshot of a blog, identified by its ID and version.
+	// Saving the same version again is a no-op, a version never changes.
+	SaveRevision(ctx context.Context, rev *BlogItem) error
+	// ListRevisions returns the saved revisions of a blog, newest first.
+	ListRevisions(ctx context.Context, id primitive.ObjectID) ([]*BlogItem, error)
+	// ReadRevision returns the revision of a blog at the given version or ErrNotFound.
+	ReadRevision(ctx context.Context, id primitive.ObjectID, version int64) (*BlogItem, error)
+	// ListTags returns every tag with the number of live blogs carrying it, most used first.
+	ListTags(ctx context.Context) ([]TagCount, error)
+	// Close releases the resources held by the store.
+	Close(ctx context.Context) error
+
+	CommentStore
+	AuthorStore
+	AttachmentStore
+}
+
+// Fields ListBlogs can sort by. Ties are always broken by ID, so the order is total
+// and keyset pagination never skips or repeats a blog.
+const (
+	SortByID         = "" // insertion order
+	SortByTitle      = "title"
+	SortByCreateTime = "create_time"
+)
+
+// ListQuery selects the blogs returned by BlogStore.List.
+type ListQuery struct {
+	// Filters, zero values match every blog.
+	AuthorID string
+	// TitlePrefix is case sensitive so stores can use their title index, TitleContains ignores case.
+	TitlePrefix   string
+	TitleContains string
+	// Only blogs created in [CreatedAfter, CreatedBefore).
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Only blogs carrying all of these (normalized) tags.
+	Tags []string
+	// Only blogs in one of these statuses.
+	Statuses []string
+	// Deleted lists the blogs in the trash instead of the live ones.
+	Deleted bool
+
+	// SortBy is one of the SortBy* constants.
+	SortBy string
+	Desc   bool
+
+	// The cursor of keyset pagination: the blog with ID After (and its sort key in AfterTitle or
+	// AfterCreateTime) and every blog before it in the sort order are skipped.
+	// NilObjectID starts at the first blog.
+	After           primitive.ObjectID
+	AfterTitle      string
+	AfterCreateTime time.Time
+
+	// Limit is the maximum number of blogs, 0 means no limit.
+	Limit int
+}
+
+type BlogItem struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	AuthorID string             `bson:"author_id"`
+	Content  string             `bson:"content"`
+	Title    string             `bson:"title"`
+	Version  int64              `bson:"version"`
+	// Tags are normalized, see normalizeTags.
+	Tags []string `bson:"tags"`
+	// Slug is the current slug, Slugs every slug the blog ever had including the current one,
+	// the old ones keep working as redirects. Blogs from before slugs were added have none.
+	Slug  string   `bson:"slug,omitempty"`
+	Slugs []string `bson:"slugs,omitempty"`
+	// Status is one of the Status* constants, see BlogItem.status for blogs without one.
+	// PublishTime is when the blog was or will be published.
+	Status      string    `bson:"status,omitempty"`
+	PublishTime time.Time `bson:"publish_time,omitempty"`
+	// DeleteTime is when the blog was moved to the trash, zero for live blogs.
+	DeleteTime time.Time `bson:"delete_time,omitempty"`
+	// Editor made the last change, the author for new blogs.
+	Editor string `bson:"editor,omitempty"`
+	// ContentFormat is one of the Format* constants, see BlogItem.format for blogs without one.
+	ContentFormat string `bson:"content_format,omitempty"`
+	// Managed by the server, with millisecond precision like MongoDB stores them.
+	CreateTime time.Time `bson:"create_time"`
+	UpdateTime time.Time `bson:"update_time"`
+}
+
+// updatableFields are the fields UpdateBlog may change, named like in the proto, BSON and SQL.
+// status, publish_time, delete_time and editor can be updated too, but only by the server itself.
+var updatableFields = []string{"author_id", "title", "content", "tags", "slug", "content_format"}
+
+// hasField reports whether field is one of fields.
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFields copies the given fields from src to dst.
+func applyFields(dst, src *BlogItem, fields []string) {
+	for _, field := range fields {
+		switch field {
+		case "author_id":
+			dst.AuthorID = src.AuthorID
+		case "title":
+			dst.Title = src.Title
+		case "content":
+			dst.Content = src.Content
+		case "tags":
+			dst.Tags = src.Tags
+		case "slug":
+			dst.Slug = src.Slug
+			dst.Slugs = addSlug(dst.Slugs, src.Slug)
+		case "status":
+			dst.Status = src.Status
+		case "publish_time":
+			dst.PublishTime = src.PublishTime
+		case "delete_time":
+			dst.DeleteTime = src.DeleteTime
+		case "editor":
+			dst.Editor = src.Editor
+		case "content_format":
+			dst.ContentFormat = src.ContentFormat
+		}
+	}
+}
+
+// fieldValue returns the value of one of the updatableFields, or nil for any other field.
+func (b *BlogItem) fieldValue(field string) interface{} {
+	switch field {
+	case "author_id":
+		return b.AuthorID
+	case "title":
+		return b.Title
+	case "content":
+		return b.Content
+	case "tags":
+		return b.Tags
+	case "slug":
+		return b.Slug
+	case "status":
+		return b.Status
+	case "publish_time":
+		return b.PublishTime
+	case "delete_time":
+		return b.DeleteTime
+	case "editor":
+		return b.Editor
+	case "content_format":
+		return b.ContentFormat
+	}
+	return nil
+}
+
+// toProto converts a stored BlogItem into its protobuf counterpart.
+func (b *BlogItem) toProto() *blogpb.Blog {
+	return &blogpb.Blog{
+		Id:       b.ID.Hex(),
+		AuthorId: b.AuthorID,
+		Title:    b.Title,
+		Content:  b.Content,
+		Version:  b.Version,
+		Tags:     b.Tags,
+		Slug:     b.Slug,
+		Status:   statusToProto[b.status()],
+		Editor:   b.Editor,
+		// Blogs from before content formats were added are plain text.
+		ContentFormat: formatToProto[b.format()],
+		// Blogs from before timestamps were added have none.
+		CreateTime: timestampOrNil(b.CreateTime),
+		UpdateTime: timestampOrNil(b.UpdateTime),
+		// Unset for drafts and for blogs from before statuses were added.
+		PublishTime: timestampOrNil(b.PublishTime),
+		DeleteTime:  timestampOrNil(b.DeleteTime),
+	}
+}
+
+func timestampOrNil(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// timeOrZero converts an optional timestamp, unset is the zero time.
+func timeOrZero(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// toMillis and fromMillis convert timestamps to milliseconds since the epoch and back,
+// the zero time is 0.
+func toMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// now is the timestamp for the blog being created or updated.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// newStore opens the BlogStore selected by the store configuration.
+func newStore(ctx context.Context, cfg StoreConfig) (BlogStore, error) {
+	switch cfg.Kind {
+	case "mongo":
+		fmt.Println("Connecting to MongoDB...")
+		store, err := NewMongoStore(ctx, cfg.MongoURI, cfg.MongoDatabase, cfg.MongoCollection)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Println("Connected to Mongodb")
+		return store, nil
+	case "memory":
+		fmt.Println("Using in-memory store, blogs will be lost on shutdown")
+		return NewMemoryStore(), nil
+	case "file":
+		fmt.Printf("Using file store %s\n", cfg.Path)
+		return NewFileStore(cfg.Path)
+	case "sqlite":
+		fmt.Printf("Using SQLite database %s\n", cfg.Path)
+		return NewSQLiteStore(ctx, cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.Kind)
+	}
+}
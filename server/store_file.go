@@ -0,0 +1,663 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The file store is an append-only log. It starts with fileMagic, followed by records:
+//
+//	[4 bytes payload length][4 bytes CRC-32 of payload][payload]
+//
+// The payload is a BSON encoded fileRecord. Replaying all records from the start
+// rebuilds the current state, the last record for an ID wins.
+const (
+	fileMagic = "BLOGLOG1"
+	// fileMaxRecord protects recovery against a garbage length in a torn header.
+	fileMaxRecord = 16 << 20
+	// Compact once the log holds this many records and more than half of them are stale.
+	fileCompactMinRecords = 1024
+)
+
+const (
+	fileOpPut    = "put"
+	fileOpDelete = "delete"
+	// A revision record holds the snapshot in Blog.
+	fileOpRevision = "revision"
+	// Comment records use Comment instead of Blog.
+	fileOpPutComment    = "put_comment"
+	fileOpDeleteComment = "delete_comment"
+	// Author records use Author, authors are never deleted.
+	fileOpPutAuthor = "put_author"
+	// Attachment records use Attachment, attachments go with their blog.
+	fileOpPutAttachment = "put_attachment"
+)
+
+type fileRecord struct {
+	Op         string          `bson:"op"`
+	Blog       BlogItem        `bson:"blog"`
+	Comment    *CommentItem    `bson:"comment,omitempty"`
+	Author     *AuthorItem     `bson:"author,omitempty"`
+	Attachment *AttachmentItem `bson:"attachment,omitempty"`
+}
+
+// FileStore keeps the blogs in a single append-only file, so the server can run without MongoDB.
+// The whole state is also kept in memory, reads never touch the disk.
+type FileStore struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	blogs   map[primitive.ObjectID]BlogItem
+	slugs   slugIndex
+	records int   // records in the log, live and stale
+	size    int64 // end of the last complete record
+
+	// revisions of every blog, oldest first, revisionCount is their total.
+	revisions     map[primitive.ObjectID][]BlogItem
+	revisionCount int
+	comments      map[primitive.ObjectID]CommentItem
+	authors       map[string]AuthorItem
+	attachments   map[primitive.ObjectID]AttachmentItem
+}
+
+// NewFileStore opens (or creates) the log at path and replays it.
+// A torn or corrupt tail, left behind by a crash in the middle of a write, is truncated.
+func NewFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileStore{
+		path:        path,
+		file:        file,
+		blogs:       make(map[primitive.ObjectID]BlogItem),
+		slugs:       make(slugIndex),
+		revisions:   make(map[primitive.ObjectID][]BlogItem),
+		comments:    make(map[primitive.ObjectID]CommentItem),
+		authors:     make(map[string]AuthorItem),
+		attachments: make(map[primitive.ObjectID]AttachmentItem),
+	}
+	if err := f.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := f.maybeCompact(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// recover replays the log into memory and leaves the file offset at its end.
+func (f *FileStore) recover() error {
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	// A brand new file only gets the magic header.
+	if info.Size() == 0 {
+		if _, err := f.file.Write([]byte(fileMagic)); err != nil {
+			return err
+		}
+		f.size = int64(len(fileMagic))
+		return f.file.Sync()
+	}
+
+	r := bufio.NewReader(f.file)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fileMagic {
+		return fmt.Errorf("%s is not a blog store file", f.path)
+	}
+
+	offset := int64(len(fileMagic))
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Everything after the last good record is lost, cut it off so new records aren't appended after garbage.
+			log.Printf("Truncating %s at offset %d: %v", f.path, offset, err)
+			if err := f.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := f.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		f.apply(rec)
+		offset += n
+	}
+
+	f.size = offset
+	_, err = f.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readRecord reads a single record and returns it with its size on disk.
+// io.EOF is only returned on a clean record boundary.
+func readRecord(r io.Reader) (*fileRecord, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, fmt.Errorf("torn record header: %v", err)
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	sum := binary.LittleEndian.Uint32(header[4:8])
+	if size > fileMaxRecord {
+		return nil, 0, fmt.Errorf("record size %d too large", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, fmt.Errorf("torn record: %v", err)
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+
+	rec := &fileRecord{}
+	if err := bson.Unmarshal(payload, rec); err != nil {
+		return nil, 0, fmt.Errorf("could not decode record: %v", err)
+	}
+	return rec, int64(len(header)) + int64(size), nil
+}
+
+// encodeRecord returns the on disk representation of rec.
+func encodeRecord(rec *fileRecord) ([]byte, error) {
+	payload, err := bson.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[8:], payload)
+	return buf, nil
+}
+
+// apply updates the in-memory state with a record from the log.
+func (f *FileStore) apply(rec *fileRecord) {
+	switch rec.Op {
+	case fileOpPut:
+		f.blogs[rec.Blog.ID] = rec.Blog
+		f.slugs.add(&rec.Blog)
+	case fileOpDelete:
+		if data, ok := f.blogs[rec.Blog.ID]; ok {
+			f.slugs.remove(&data)
+		}
+		delete(f.blogs, rec.Blog.ID)
+		f.revisionCount -= len(f.revisions[rec.Blog.ID])
+		delete(f.revisions, rec.Blog.ID)
+		// The comments and attachments go with the blog, no need for a record per comment.
+		for id, c := range f.comments {
+			if c.BlogID == rec.Blog.ID {
+				delete(f.comments, id)
+			}
+		}
+		for id, a := range f.attachments {
+			if a.BlogID == rec.Blog.ID {
+				delete(f.attachments, id)
+			}
+		}
+	case fileOpRevision:
+		before := len(f.revisions[rec.Blog.ID])
+		f.revisions[rec.Blog.ID] = addRevision(f.revisions[rec.Blog.ID], rec.Blog)
+		f.revisionCount += len(f.revisions[rec.Blog.ID]) - before
+	case fileOpPutComment:
+		f.comments[rec.Comment.ID] = *rec.Comment
+	case fileOpDeleteComment:
+		delete(f.comments, rec.Comment.ID)
+	case fileOpPutAuthor:
+		f.authors[rec.Author.ID] = *rec.Author
+	case fileOpPutAttachment:
+		f.attachments[rec.Attachment.ID] = *rec.Attachment
+	}
+	f.records++
+}
+
+// write appends rec to the log, syncs it to disk and only then applies it in memory.
+// Callers must hold f.mu.
+func (f *FileStore) write(recs ...*fileRecord) error {
+	// Several records are appended with a single write and sync.
+	var buf []byte
+	for _, rec := range recs {
+		b, err := encodeRecord(rec)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, b...)
+	}
+	if _, err := f.file.Write(buf); err != nil {
+		// Drop whatever part of the record made it to the file, or the next
+		// recovery would stop at it and lose every record written after.
+		f.rollback()
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		// The record is in the file but not applied, drop it too so the log and
+		// memory agree and the next write starts at f.size again.
+		f.rollback()
+		return err
+	}
+	f.size += int64(len(buf))
+	for _, rec := range recs {
+		f.apply(rec)
+	}
+
+	// The record is durable at this point, a failed compaction only costs disk space.
+	if err := f.maybeCompact(); err != nil {
+		log.Printf("Could not compact %s: %v", f.path, err)
+	}
+	return nil
+}
+
+// rollback cuts the log back to the end of the last complete record after a failed write.
+// Callers must hold f.mu.
+func (f *FileStore) rollback() {
+	f.file.Truncate(f.size)
+	f.file.Seek(f.size, io.SeekStart)
+}
+
+// maybeCompact rewrites the log with only the live blogs, their revisions, comments and attachments and the authors
+// once it is mostly stale records.
+// The new log is written to a temporary file and renamed over the old one, so a crash
+// during compaction leaves either the old or the new log, never a mix of both.
+// Callers must hold f.mu.
+func (f *FileStore) maybeCompact() error {
+	live := len(f.blogs) + f.revisionCount + len(f.comments) + len(f.authors) + len(f.attachments)
+	if f.records < fileCompactMinRecords || f.records < 2*live {
+		return nil
+	}
+
+	tmpPath := f.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(fileMagic)
+	size := int64(len(fileMagic))
+	var recs []*fileRecord
+	for _, data := range f.blogs {
+		recs = append(recs, &fileRecord{Op: fileOpPut, Blog: data})
+	}
+	for _, revs := range f.revisions {
+		for _, rev := range revs {
+			recs = append(recs, &fileRecord{Op: fileOpRevision, Blog: rev})
+		}
+	}
+	for _, c := range f.comments {
+		c := c
+		recs = append(recs, &fileRecord{Op: fileOpPutComment, Comment: &c})
+	}
+	for _, a := range f.authors {
+		a := a
+		recs = append(recs, &fileRecord{Op: fileOpPutAuthor, Author: &a})
+	}
+	for _, a := range f.attachments {
+		a := a
+		recs = append(recs, &fileRecord{Op: fileOpPutAttachment, Attachment: &a})
+	}
+	for _, rec := range recs {
+		buf, err := encodeRecord(rec)
+		if err != nil {
+			return fail(err)
+		}
+		w.Write(buf)
+		size += int64(len(buf))
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return fail(err)
+	}
+	syncDir(filepath.Dir(f.path))
+
+	f.file.Close()
+	f.file = tmp
+	f.records = live
+	f.size = size
+	return nil
+}
+
+// syncDir makes a rename in dir durable, errors are ignored since not every platform supports it.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+func (f *FileStore) Create(ctx context.Context, item *BlogItem) (*BlogItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+	data.Version = 1
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.slugs.available(&data) {
+		return nil, ErrSlugTaken
+	}
+	if err := f.write(&fileRecord{Op: fileOpPut, Blog: data}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileStore) CreateMany(ctx context.Context, items []*BlogItem) ([]*BlogItem, []error) {
+	created := make([]*BlogItem, len(items))
+	errs := make([]error, len(items))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// The records are only applied once written, batch tracks the slugs taken by the blogs before.
+	batch := make(slugIndex)
+	var recs []*fileRecord
+	for i, item := range items {
+		data := *item
+		data.ID = primitive.NewObjectID()
+		data.Version = 1
+		if !f.slugs.available(&data) || !batch.available(&data) {
+			errs[i] = ErrSlugTaken
+			continue
+		}
+		batch.add(&data)
+		recs = append(recs, &fileRecord{Op: fileOpPut, Blog: data})
+		created[i] = &data
+	}
+	if len(recs) == 0 {
+		return created, errs
+	}
+	if err := f.write(recs...); err != nil {
+		for i := range created {
+			if created[i] != nil {
+				created[i], errs[i] = nil, err
+			}
+		}
+	}
+	return created, errs
+}
+
+func (f *FileStore) Read(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	f.mu.RLock()
+	data, ok := f.blogs[id]
+	f.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &data, nil
+}
+
+func (f *FileStore) ReadBySlug(ctx context.Context, slug string) (*BlogItem, error) {
+	f.mu.RLock()
+	data, ok := f.blogs[f.slugs[slug]]
+	f.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &data, nil
+}
+
+func (f *FileStore) Update(ctx context.Context, item *BlogItem, fields []string, expectedVersion int64) (*BlogItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.blogs[item.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if expectedVersion != 0 && data.Version != expectedVersion {
+		return nil, &VersionConflictError{Current: data.Version}
+	}
+	data.Version++
+	applyFields(&data, item, fields)
+	data.UpdateTime = item.UpdateTime
+	if !f.slugs.available(&data) {
+		return nil, ErrSlugTaken
+	}
+	if err := f.write(&fileRecord{Op: fileOpPut, Blog: data}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.blogs[id]; !ok {
+		return ErrNotFound
+	}
+	return f.write(&fileRecord{Op: fileOpDelete, Blog: BlogItem{ID: id}})
+}
+
+func (f *FileStore) SaveRevision(ctx context.Context, rev *BlogItem) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, err := findRevision(f.revisions[rev.ID], rev.Version); err == nil {
+		return nil
+	}
+	return f.write(&fileRecord{Op: fileOpRevision, Blog: *rev})
+}
+
+func (f *FileStore) ListRevisions(ctx context.Context, id primitive.ObjectID) ([]*BlogItem, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return newestFirst(f.revisions[id]), nil
+}
+
+func (f *FileStore) ReadRevision(ctx context.Context, id primitive.ObjectID, version int64) (*BlogItem, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return findRevision(f.revisions[id], version)
+}
+
+func (f *FileStore) List(ctx context.Context, q ListQuery, fn func(*BlogItem) error) error {
+	f.mu.RLock()
+	items := make([]BlogItem, 0, len(f.blogs))
+	for _, data := range f.blogs {
+		items = append(items, data)
+	}
+	f.mu.RUnlock()
+
+	return listInOrder(ctx, items, q, fn)
+}
+
+func (f *FileStore) ListTags(ctx context.Context) ([]TagCount, error) {
+	f.mu.RLock()
+	items := make([]BlogItem, 0, len(f.blogs))
+	for _, data := range f.blogs {
+		items = append(items, data)
+	}
+	f.mu.RUnlock()
+
+	return countTags(items), nil
+}
+
+func (f *FileStore) CreateComment(ctx context.Context, item *CommentItem) (*CommentItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.write(&fileRecord{Op: fileOpPutComment, Comment: &data}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileStore) ReadComment(ctx context.Context, id primitive.ObjectID) (*CommentItem, error) {
+	f.mu.RLock()
+	data, ok := f.comments[id]
+	f.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	return &data, nil
+}
+
+func (f *FileStore) UpdateComment(ctx context.Context, item *CommentItem, fields []string) (*CommentItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.comments[item.ID]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	applyCommentFields(&data, item, fields)
+	data.UpdateTime = item.UpdateTime
+	if err := f.write(&fileRecord{Op: fileOpPutComment, Comment: &data}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileStore) DeleteComment(ctx context.Context, id primitive.ObjectID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.comments[id]; !ok {
+		return nil
+	}
+	return f.write(&fileRecord{Op: fileOpDeleteComment, Comment: &CommentItem{ID: id}})
+}
+
+func (f *FileStore) ListComments(ctx context.Context, q CommentQuery, fn func(*CommentItem) error) error {
+	f.mu.RLock()
+	var items []CommentItem
+	for _, c := range f.comments {
+		if q.matches(&c) {
+			items = append(items, c)
+		}
+	}
+	f.mu.RUnlock()
+
+	return listCommentsInOrder(ctx, items, q, fn)
+}
+
+func (f *FileStore) CreateAuthor(ctx context.Context, item *AuthorItem) (*AuthorItem, error) {
+	data := *item
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.authors[data.ID]; ok {
+		return nil, ErrAuthorExists
+	}
+	if err := f.write(&fileRecord{Op: fileOpPutAuthor, Author: &data}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileStore) ReadAuthor(ctx context.Context, id string) (*AuthorItem, error) {
+	f.mu.RLock()
+	data, ok := f.authors[id]
+	f.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+	return &data, nil
+}
+
+func (f *FileStore) UpdateAuthor(ctx context.Context, item *AuthorItem, fields []string) (*AuthorItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.authors[item.ID]
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+	applyAuthorFields(&data, item, fields)
+	data.UpdateTime = item.UpdateTime
+	if err := f.write(&fileRecord{Op: fileOpPutAuthor, Author: &data}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileStore) ListAuthors(ctx context.Context, q AuthorQuery, fn func(*AuthorItem) error) error {
+	f.mu.RLock()
+	items := make([]AuthorItem, 0, len(f.authors))
+	for _, data := range f.authors {
+		items = append(items, data)
+	}
+	f.mu.RUnlock()
+
+	return listAuthorsInOrder(ctx, items, q, fn)
+}
+
+func (f *FileStore) CreateAttachment(ctx context.Context, item *AttachmentItem) (*AttachmentItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.write(&fileRecord{Op: fileOpPutAttachment, Attachment: &data}); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (f *FileStore) ReadAttachment(ctx context.Context, id primitive.ObjectID) (*AttachmentItem, error) {
+	f.mu.RLock()
+	data, ok := f.attachments[id]
+	f.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrAttachmentNotFound
+	}
+	return &data, nil
+}
+
+func (f *FileStore) ListAttachments(ctx context.Context, blogID primitive.ObjectID) ([]*AttachmentItem, error) {
+	f.mu.RLock()
+	var items []*AttachmentItem
+	for _, a := range f.attachments {
+		if a.BlogID == blogID {
+			a := a
+			items = append(items, &a)
+		}
+	}
+	f.mu.RUnlock()
+
+	return sortAttachments(items), nil
+}
+
+func (f *FileStore) Close(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
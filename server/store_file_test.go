@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openFileStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+	return store
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage breaks the last record of the log, which is size bytes long.
+		damage func(t *testing.T, path string, size int64)
+	}{
+		{
+			name: "torn record",
+			damage: func(t *testing.T, path string, size int64) {
+				if err := os.Truncate(path, size-3); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "torn header",
+			damage: func(t *testing.T, path string, size int64) {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				f.Write([]byte{1, 2, 3})
+			},
+		},
+		{
+			name: "checksum mismatch",
+			damage: func(t *testing.T, path string, size int64) {
+				f, err := os.OpenFile(path, os.O_RDWR, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				b := make([]byte, 1)
+				f.ReadAt(b, size-1)
+				b[0] ^= 0xff
+				f.WriteAt(b, size-1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "blogs.log")
+			store, err := NewFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blogs := createBlogs(t, store, 3)
+			store.Close(ctx)
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(t, path, info.Size())
+
+			store = openFileStore(t, path)
+			for _, data := range blogs[:2] {
+				if _, err := store.Read(ctx, data.ID); err != nil {
+					t.Errorf("%s is lost: %v", data.Title, err)
+				}
+			}
+			// The checksum mismatch and the torn record damage the last blog, the torn header comes after it.
+			_, err = store.Read(ctx, blogs[2].ID)
+			if tt.name == "torn header" && err != nil {
+				t.Errorf("The last blog is lost: %v", err)
+			}
+			if tt.name != "torn header" && err != ErrNotFound {
+				t.Errorf("Got %v reading the damaged blog, want ErrNotFound", err)
+			}
+
+			// Records written after the recovery survive the next one.
+			more := createBlogs(t, store, 1)
+			store.Close(ctx)
+			store = openFileStore(t, path)
+			if _, err := store.Read(ctx, more[0].ID); err != nil {
+				t.Errorf("The blog written after the recovery is lost: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileStoreRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blogs.log")
+	if err := os.WriteFile(path, []byte("not a blog log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(path); err == nil {
+		t.Error("Opened a file without the magic header")
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "blogs.log")
+	store := openFileStore(t, path)
+	blogs := createBlogs(t, store, 2)
+	deleted := createBlogs(t, store, 1)[0]
+	if err := store.Delete(ctx, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every update leaves a stale record behind, until there are enough to compact.
+	var last *BlogItem
+	for i := 0; i < fileCompactMinRecords; i++ {
+		var err error
+		last, err = store.Update(ctx, &BlogItem{ID: blogs[0].ID, Title: fmt.Sprintf("Edit %d", i), UpdateTime: now()}, []string{"title"}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.records >= fileCompactMinRecords {
+		t.Errorf("Got %d records for %d blogs, the log wasn't compacted", store.records, len(store.blogs))
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("The temporary file is left behind: %v", err)
+	}
+
+	// The compacted log holds the same state, and takes new records.
+	more := createBlogs(t, store, 1)
+	store.Close(ctx)
+	store = openFileStore(t, path)
+	current, err := store.Read(ctx, blogs[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Title != last.Title || current.Version != last.Version {
+		t.Errorf("Got %q version %d, want %q version %d", current.Title, current.Version, last.Title, last.Version)
+	}
+	for _, data := range []*BlogItem{blogs[1], more[0]} {
+		if _, err := store.Read(ctx, data.ID); err != nil {
+			t.Errorf("%s is lost: %v", data.Title, err)
+		}
+	}
+	if _, err := store.Read(ctx, deleted.ID); err != ErrNotFound {
+		t.Errorf("Got %v reading the deleted blog, want ErrNotFound", err)
+	}
+}
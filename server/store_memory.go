@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryStore keeps the blogs in a map guarded by a RWMutex.
+// Nothing is persisted, which makes it handy for demos and tests.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	blogs map[primitive.ObjectID]BlogItem
+	slugs slugIndex
+	// revisions of every blog, oldest first.
+	revisions map[primitive.ObjectID][]BlogItem
+	comments  map[primitive.ObjectID]CommentItem
+	authors   map[string]AuthorItem
+	// attachments of every blog, the content is in a BlobStore.
+	attachments map[primitive.ObjectID]AttachmentItem
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blogs:       make(map[primitive.ObjectID]BlogItem),
+		slugs:       make(slugIndex),
+		revisions:   make(map[primitive.ObjectID][]BlogItem),
+		comments:    make(map[primitive.ObjectID]CommentItem),
+		authors:     make(map[string]AuthorItem),
+		attachments: make(map[primitive.ObjectID]AttachmentItem),
+	}
+}
+
+func (m *MemoryStore) Create(ctx context.Context, item *BlogItem) (*BlogItem, error) {
+	data := *item
+	// Generate the same kind of IDs MongoDB would, so clients can't tell the difference.
+	data.ID = primitive.NewObjectID()
+	data.Version = 1
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.slugs.available(&data) {
+		return nil, ErrSlugTaken
+	}
+	m.blogs[data.ID] = data
+	m.slugs.add(&data)
+
+	return &data, nil
+}
+
+func (m *MemoryStore) CreateMany(ctx context.Context, items []*BlogItem) ([]*BlogItem, []error) {
+	created := make([]*BlogItem, len(items))
+	errs := make([]error, len(items))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, item := range items {
+		data := *item
+		data.ID = primitive.NewObjectID()
+		data.Version = 1
+		// Blogs earlier in the batch are in the index already, so they can't share a slug either.
+		if !m.slugs.available(&data) {
+			errs[i] = ErrSlugTaken
+			continue
+		}
+		m.blogs[data.ID] = data
+		m.slugs.add(&data)
+		created[i] = &data
+	}
+	return created, errs
+}
+
+func (m *MemoryStore) Read(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	m.mu.RLock()
+	data, ok := m.blogs[id]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &data, nil
+}
+
+func (m *MemoryStore) ReadBySlug(ctx context.Context, slug string) (*BlogItem, error) {
+	m.mu.RLock()
+	data, ok := m.blogs[m.slugs[slug]]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &data, nil
+}
+
+func (m *MemoryStore) Update(ctx context.Context, item *BlogItem, fields []string, expectedVersion int64) (*BlogItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.blogs[item.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if expectedVersion != 0 && data.Version != expectedVersion {
+		return nil, &VersionConflictError{Current: data.Version}
+	}
+	data.Version++
+	applyFields(&data, item, fields)
+	data.UpdateTime = item.UpdateTime
+	if !m.slugs.available(&data) {
+		return nil, ErrSlugTaken
+	}
+	m.blogs[item.ID] = data
+	m.slugs.add(&data)
+
+	return &data, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.blogs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	m.slugs.remove(&data)
+	delete(m.blogs, id)
+	delete(m.revisions, id)
+	for cid, c := range m.comments {
+		if c.BlogID == id {
+			delete(m.comments, cid)
+		}
+	}
+	for aid, a := range m.attachments {
+		if a.BlogID == id {
+			delete(m.attachments, aid)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SaveRevision(ctx context.Context, rev *BlogItem) error {
+	m.mu.Lock()
+	m.revisions[rev.ID] = addRevision(m.revisions[rev.ID], *rev)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) ListRevisions(ctx context.Context, id primitive.ObjectID) ([]*BlogItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return newestFirst(m.revisions[id]), nil
+}
+
+func (m *MemoryStore) ReadRevision(ctx context.Context, id primitive.ObjectID, version int64) (*BlogItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return findRevision(m.revisions[id], version)
+}
+
+func (m *MemoryStore) List(ctx context.Context, q ListQuery, fn func(*BlogItem) error) error {
+	// Take a snapshot so fn can be slow (e.g. a stream.Send) without holding the lock.
+	m.mu.RLock()
+	items := make([]BlogItem, 0, len(m.blogs))
+	for _, data := range m.blogs {
+		items = append(items, data)
+	}
+	m.mu.RUnlock()
+
+	return listInOrder(ctx, items, q, fn)
+}
+
+func (m *MemoryStore) ListTags(ctx context.Context) ([]TagCount, error) {
+	m.mu.RLock()
+	items := make([]BlogItem, 0, len(m.blogs))
+	for _, data := range m.blogs {
+		items = append(items, data)
+	}
+	m.mu.RUnlock()
+
+	return countTags(items), nil
+}
+
+func (m *MemoryStore) CreateComment(ctx context.Context, item *CommentItem) (*CommentItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+
+	m.mu.Lock()
+	m.comments[data.ID] = data
+	m.mu.Unlock()
+	return &data, nil
+}
+
+func (m *MemoryStore) ReadComment(ctx context.Context, id primitive.ObjectID) (*CommentItem, error) {
+	m.mu.RLock()
+	data, ok := m.comments[id]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	return &data, nil
+}
+
+func (m *MemoryStore) UpdateComment(ctx context.Context, item *CommentItem, fields []string) (*CommentItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.comments[item.ID]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	applyCommentFields(&data, item, fields)
+	data.UpdateTime = item.UpdateTime
+	m.comments[item.ID] = data
+	return &data, nil
+}
+
+func (m *MemoryStore) DeleteComment(ctx context.Context, id primitive.ObjectID) error {
+	m.mu.Lock()
+	delete(m.comments, id)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryStore) ListComments(ctx context.Context, q CommentQuery, fn func(*CommentItem) error) error {
+	m.mu.RLock()
+	var items []CommentItem
+	for _, c := range m.comments {
+		if q.matches(&c) {
+			items = append(items, c)
+		}
+	}
+	m.mu.RUnlock()
+
+	return listCommentsInOrder(ctx, items, q, fn)
+}
+
+func (m *MemoryStore) CreateAuthor(ctx context.Context, item *AuthorItem) (*AuthorItem, error) {
+	data := *item
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.authors[data.ID]; ok {
+		return nil, ErrAuthorExists
+	}
+	m.authors[data.ID] = data
+	return &data, nil
+}
+
+func (m *MemoryStore) ReadAuthor(ctx context.Context, id string) (*AuthorItem, error) {
+	m.mu.RLock()
+	data, ok := m.authors[id]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+	return &data, nil
+}
+
+func (m *MemoryStore) UpdateAuthor(ctx context.Context, item *AuthorItem, fields []string) (*AuthorItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.authors[item.ID]
+	if !ok {
+		return nil, ErrAuthorNotFound
+	}
+	applyAuthorFields(&data, item, fields)
+	data.UpdateTime = item.UpdateTime
+	m.authors[item.ID] = data
+	return &data, nil
+}
+
+func (m *MemoryStore) ListAuthors(ctx context.Context, q AuthorQuery, fn func(*AuthorItem) error) error {
+	m.mu.RLock()
+	items := make([]AuthorItem, 0, len(m.authors))
+	for _, data := range m.authors {
+		items = append(items, data)
+	}
+	m.mu.RUnlock()
+
+	return listAuthorsInOrder(ctx, items, q, fn)
+}
+
+func (m *MemoryStore) CreateAttachment(ctx context.Context, item *AttachmentItem) (*AttachmentItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+
+	m.mu.Lock()
+	m.attachments[data.ID] = data
+	m.mu.Unlock()
+	return &data, nil
+}
+
+func (m *MemoryStore) ReadAttachment(ctx context.Context, id primitive.ObjectID) (*AttachmentItem, error) {
+	m.mu.RLock()
+	data, ok := m.attachments[id]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrAttachmentNotFound
+	}
+	return &data, nil
+}
+
+func (m *MemoryStore) ListAttachments(ctx context.Context, blogID primitive.ObjectID) ([]*AttachmentItem, error) {
+	m.mu.RLock()
+	var items []*AttachmentItem
+	for _, a := range m.attachments {
+		if a.BlogID == blogID {
+			a := a
+			items = append(items, &a)
+		}
+	}
+	m.mu.RUnlock()
+
+	return sortAttachments(items), nil
+}
+
+// listInOrder filters and sorts a snapshot of blogs and calls fn for the ones selected by q.
+// Stores that keep everything in memory share it.
+func listInOrder(ctx context.Context, items []BlogItem, q ListQuery, fn func(*BlogItem) error) error {
+	matching := items[:0]
+	for _, data := range items {
+		if q.matches(&data) {
+			matching = append(matching, data)
+		}
+	}
+	items = matching
+
+	sort.Slice(items, func(i, j int) bool {
+		return q.compare(&items[i], &items[j]) < 0
+	})
+
+	// Skip to the first blog after the cursor.
+	if !q.After.IsZero() {
+		cursor := &BlogItem{ID: q.After, Title: q.AfterTitle, CreateTime: q.AfterCreateTime}
+		start := sort.Search(len(items), func(i int) bool {
+			return q.compare(&items[i], cursor) > 0
+		})
+		items = items[start:]
+	}
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+	}
+
+	for i := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(&items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether data passes the filters of q.
+func (q *ListQuery) matches(data *BlogItem) bool {
+	if q.Deleted == data.DeleteTime.IsZero() {
+		return false
+	}
+	if q.AuthorID != "" && data.AuthorID != q.AuthorID {
+		return false
+	}
+	if q.TitlePrefix != "" && !strings.HasPrefix(data.Title, q.TitlePrefix) {
+		return false
+	}
+	if q.TitleContains != "" && !strings.Contains(strings.ToLower(data.Title), strings.ToLower(q.TitleContains)) {
+		return false
+	}
+	if !q.CreatedAfter.IsZero() && data.CreateTime.Before(q.CreatedAfter) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && !data.CreateTime.Before(q.CreatedBefore) {
+		return false
+	}
+	if !hasTags(data.Tags, q.Tags) {
+		return false
+	}
+	if len(q.Statuses) > 0 && !hasField(q.Statuses, data.status()) {
+		return false
+	}
+	return true
+}
+
+// compare orders two blogs by the sort key of q, then by ID.
+// ObjectIDs start with their creation time, sorting by them gives insertion order.
+func (q *ListQuery) compare(a, b *BlogItem) int {
+	c := 0
+	switch q.SortBy {
+	case SortByTitle:
+		c = strings.Compare(a.Title, b.Title)
+	case SortByCreateTime:
+		if a.CreateTime.Before(b.CreateTime) {
+			c = -1
+		} else if a.CreateTime.After(b.CreateTime) {
+			c = 1
+		}
+	}
+	if c == 0 {
+		c = bytes.Compare(a.ID[:], b.ID[:])
+	}
+	if q.Desc {
+		return -c
+	}
+	return c
+}
+
+func (m *MemoryStore) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps the blogs in a MongoDB collection.
+type MongoStore struct {
+	client *mongo.Client
+	blogdb *mongo.Collection
+	// revisions, comments, authors and attachments live next to the blogs collection, named <collection>_revisions,
+	// <collection>_comments, <collection>_authors and <collection>_attachments.
+	revisions   *mongo.Collection
+	comments    *mongo.Collection
+	authors     *mongo.Collection
+	attachments *mongo.Collection
+}
+
+// mongoRevision is a document of the revisions collection.
+type mongoRevision struct {
+	BlogID  primitive.ObjectID `bson:"blog_id"`
+	Version int64              `bson:"version"`
+	Blog    BlogItem           `bson:"blog"`
+}
+
+// NewMongoStore connects to the MongoDB server at uri and checks the connection with a ping.
+func NewMongoStore(ctx context.Context, uri, database, collection string) (*MongoStore, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	m := &MongoStore{
+		client:      client,
+		blogdb:      client.Database(database).Collection(collection),
+		revisions:   client.Database(database).Collection(collection + "_revisions"),
+		comments:    client.Database(database).Collection(collection + "_comments"),
+		authors:     client.Database(database).Collection(collection + "_authors"),
+		attachments: client.Database(database).Collection(collection + "_attachments"),
+	}
+	if err := m.createIndexes(ctx); err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+// createIndexes makes sure the indexes behind ListBlogs filters and sort orders exist.
+// Creating an index that already exists is a no-op.
+func (m *MongoStore) createIndexes(ctx context.Context) error {
+	_, err := m.blogdb.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "author_id", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "create_time", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "publish_time", Value: 1}}},
+		{Keys: bson.D{{Key: "delete_time", Value: 1}}},
+		// Unique across blogs even for old slugs. Sparse because blogs from before slugs have none.
+		{Keys: bson.D{{Key: "slugs", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.revisions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "blog_id", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.comments.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "parent_id", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.attachments.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "blog_id", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	return err
+}
+
+func (m *MongoStore) Create(ctx context.Context, item *BlogItem) (*BlogItem, error) {
+	// ID is left empty so it gets omitted and MongoDB generates a unique Object ID upon insertion.
+	data := *item
+	data.ID = primitive.NilObjectID
+	data.Version = 1
+
+	// Insert the data into the database, result contain the newly generated Object ID for de new document.
+	result, err := m.blogdb.InsertOne(ctx, data)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrSlugTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Add the id to the blog, first cast the "generic type".
+	data.ID = result.InsertedID.(primitive.ObjectID)
+	return &data, nil
+}
+
+// duplicateKeyCode is the code of the write errors of an insert that violates a unique index.
+const duplicateKeyCode = 11000
+
+func (m *MongoStore) CreateMany(ctx context.Context, items []*BlogItem) ([]*BlogItem, []error) {
+	created := make([]*BlogItem, len(items))
+	errs := make([]error, len(items))
+	docs := make([]interface{}, len(items))
+	for i, item := range items {
+		// The IDs are generated here instead of by MongoDB, so every blog knows its ID whatever happens to the others.
+		data := *item
+		data.ID = primitive.NewObjectID()
+		data.Version = 1
+		created[i] = &data
+		docs[i] = data
+	}
+
+	// Unordered, so MongoDB keeps inserting after a failing blog and reports every failure.
+	_, err := m.blogdb.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			created[we.Index] = nil
+			if we.Code == duplicateKeyCode {
+				errs[we.Index] = ErrSlugTaken
+			} else {
+				errs[we.Index] = we
+			}
+		}
+		return created, errs
+	}
+	if err != nil {
+		// Nothing is known about the single blogs, fail them all.
+		for i := range created {
+			created[i], errs[i] = nil, err
+		}
+	}
+	return created, errs
+}
+
+func (m *MongoStore) Read(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	data := &BlogItem{}
+	if err := m.blogdb.FindOne(ctx, bson.M{"_id": id}).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *MongoStore) ReadBySlug(ctx context.Context, slug string) (*BlogItem, error) {
+	// slugs holds the current slug too.
+	data := &BlogItem{}
+	if err := m.blogdb.FindOne(ctx, bson.M{"slugs": slug}).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *MongoStore) Update(ctx context.Context, item *BlogItem, fields []string, expectedVersion int64) (*BlogItem, error) {
+	// Convert the data to be updated into an unordered Bson document, the field names match the bson tags.
+	set := bson.M{"update_time": item.UpdateTime}
+	unset := bson.M{}
+	for _, field := range fields {
+		value := item.fieldValue(field)
+		if t, ok := value.(time.Time); ok && t.IsZero() {
+			// Same as omitempty on insert, e.g. delete_time only exists for trashed blogs.
+			unset[field] = ""
+			continue
+		}
+		if value != nil {
+			set[field] = value
+		}
+	}
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if _, ok := set["slug"]; ok {
+		// The old slug stays in slugs and keeps redirecting.
+		update["$addToSet"] = bson.M{"slugs": item.Slug}
+	}
+
+	// Matching on the version makes the check and the update a single atomic operation.
+	filter := bson.M{"_id": item.ID}
+	if expectedVersion != 0 {
+		filter["version"] = expectedVersion
+	}
+
+	// To return the updated document instead of original we have to add options.
+	result := m.blogdb.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
+
+	decoded := &BlogItem{}
+	err := result.Decode(decoded)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrSlugTaken
+	}
+	if err == mongo.ErrNoDocuments && expectedVersion != 0 {
+		// Either the blog is gone or it is at another version, find out which one.
+		current, err := m.Read(ctx, item.ID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &VersionConflictError{Current: current.Version}
+	}
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return decoded, nil
+}
+
+func (m *MongoStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	res, err := m.blogdb.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	// Without the blog its other documents are cleaned up anyway, a previous Delete may have failed halfway.
+	if _, err := m.revisions.DeleteMany(ctx, bson.M{"blog_id": id}); err != nil {
+		return err
+	}
+	if _, err := m.comments.DeleteMany(ctx, bson.M{"blog_id": id}); err != nil {
+		return err
+	}
+	if _, err := m.attachments.DeleteMany(ctx, bson.M{"blog_id": id}); err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MongoStore) SaveRevision(ctx context.Context, rev *BlogItem) error {
+	// $setOnInsert leaves an existing revision alone.
+	_, err := m.revisions.UpdateOne(ctx,
+		bson.M{"blog_id": rev.ID, "version": rev.Version},
+		bson.M{"$setOnInsert": bson.M{"blog": rev}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (m *MongoStore) ListRevisions(ctx context.Context, id primitive.ObjectID) ([]*BlogItem, error) {
+	cursor, err := m.revisions.Find(ctx, bson.M{"blog_id": id}, options.Find().SetSort(bson.D{{Key: "version", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []mongoRevision
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	revisions := make([]*BlogItem, len(docs))
+	for i := range docs {
+		revisions[i] = &docs[i].Blog
+	}
+	return revisions, nil
+}
+
+func (m *MongoStore) ReadRevision(ctx context.Context, id primitive.ObjectID, version int64) (*BlogItem, error) {
+	doc := &mongoRevision{}
+	if err := m.revisions.FindOne(ctx, bson.M{"blog_id": id, "version": version}).Decode(doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &doc.Blog, nil
+}
+
+func (m *MongoStore) List(ctx context.Context, q ListQuery, fn func(*BlogItem) error) error {
+	filter := bson.M{"delete_time": bson.M{"$exists": q.Deleted}}
+	if q.AuthorID != "" {
+		filter["author_id"] = q.AuthorID
+	}
+	// An anchored, case sensitive regex can use the title index, the "contains" one can't.
+	var title bson.A
+	if q.TitlePrefix != "" {
+		title = append(title, bson.M{"title": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q.TitlePrefix)}})
+	}
+	if q.TitleContains != "" {
+		title = append(title, bson.M{"title": primitive.Regex{Pattern: regexp.QuoteMeta(q.TitleContains), Options: "i"}})
+	}
+	created := bson.M{}
+	if !q.CreatedAfter.IsZero() {
+		created["$gte"] = q.CreatedAfter
+	}
+	if !q.CreatedBefore.IsZero() {
+		created["$lt"] = q.CreatedBefore
+	}
+	if len(created) > 0 {
+		filter["create_time"] = created
+	}
+	if len(q.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": q.Tags}
+	}
+	if len(q.Statuses) > 0 {
+		statuses := bson.A{}
+		for _, status := range q.Statuses {
+			statuses = append(statuses, status)
+			if status == StatusPublished {
+				// Blogs from before statuses have none and count as published, null matches a missing field.
+				statuses = append(statuses, nil)
+			}
+		}
+		filter["status"] = bson.M{"$in": statuses}
+	}
+
+	// Sort by the sort key then _id, ObjectIDs grow with insertion time.
+	dir := 1
+	after := "$gt"
+	if q.Desc {
+		dir = -1
+		after = "$lt"
+	}
+	sortKey := ""
+	var afterKey interface{}
+	switch q.SortBy {
+	case SortByTitle:
+		sortKey, afterKey = "title", q.AfterTitle
+	case SortByCreateTime:
+		sortKey, afterKey = "create_time", q.AfterCreateTime
+	}
+	sort := bson.D{{Key: "_id", Value: dir}}
+	if sortKey != "" {
+		sort = append(bson.D{{Key: sortKey, Value: dir}}, sort...)
+	}
+
+	// Keyset pagination: continue right after the cursor in sort order.
+	var cursorFilter bson.M
+	if !q.After.IsZero() {
+		if sortKey == "" {
+			cursorFilter = bson.M{"_id": bson.M{after: q.After}}
+		} else {
+			cursorFilter = bson.M{"$or": bson.A{
+				bson.M{sortKey: bson.M{after: afterKey}},
+				bson.M{sortKey: afterKey, "_id": bson.M{after: q.After}},
+			}}
+		}
+	}
+	var and bson.A
+	and = append(and, title...)
+	if cursorFilter != nil {
+		and = append(and, cursorFilter)
+	}
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+
+	opts := options.Find().SetSort(sort)
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	// collection.Find returns a cursor for our query.
+	cursor, err := m.blogdb.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	// cursor.Next() returns false when there are no more items.
+	for cursor.Next(ctx) {
+		data := &BlogItem{}
+		if err := cursor.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m *MongoStore) ListTags(ctx context.Context) ([]TagCount, error) {
+	cursor, err := m.blogdb.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"delete_time": bson.M{"$exists": false}}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := []TagCount{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (m *MongoStore) CreateComment(ctx context.Context, item *CommentItem) (*CommentItem, error) {
+	data := *item
+	data.ID = primitive.NilObjectID
+
+	result, err := m.comments.InsertOne(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	data.ID = result.InsertedID.(primitive.ObjectID)
+	return &data, nil
+}
+
+func (m *MongoStore) ReadComment(ctx context.Context, id primitive.ObjectID) (*CommentItem, error) {
+	data := &CommentItem{}
+	if err := m.comments.FindOne(ctx, bson.M{"_id": id}).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *MongoStore) UpdateComment(ctx context.Context, item *CommentItem, fields []string) (*CommentItem, error) {
+	set := bson.M{"update_time": item.UpdateTime}
+	unset := bson.M{}
+	for _, field := range fields {
+		value := item.fieldValue(field)
+		if t, ok := value.(time.Time); ok && t.IsZero() {
+			unset[field] = ""
+			continue
+		}
+		if value != nil {
+			set[field] = value
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	result := m.comments.FindOneAndUpdate(ctx, bson.M{"_id": item.ID}, update, options.FindOneAndUpdate().SetReturnDocument(options.After))
+	decoded := &CommentItem{}
+	if err := result.Decode(decoded); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	return decoded, nil
+}
+
+func (m *MongoStore) DeleteComment(ctx context.Context, id primitive.ObjectID) error {
+	_, err := m.comments.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+func (m *MongoStore) ListComments(ctx context.Context, q CommentQuery, fn func(*CommentItem) error) error {
+	filter := bson.M{"blog_id": q.BlogID}
+	if !q.ParentID.IsZero() {
+		filter["parent_id"] = q.ParentID
+	}
+	if !q.After.IsZero() {
+		filter["_id"] = bson.M{"$gt": q.After}
+	}
+	// ObjectIDs grow with insertion time, replies always come after the comment they reply to.
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	cursor, err := m.comments.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		data := &CommentItem{}
+		if err := cursor.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (m *MongoStore) CreateAuthor(ctx context.Context, item *AuthorItem) (*AuthorItem, error) {
+	// The author ID is the _id, inserting it twice is a duplicate key.
+	data := *item
+	if _, err := m.authors.InsertOne(ctx, data); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrAuthorExists
+		}
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (m *MongoStore) ReadAuthor(ctx context.Context, id string) (*AuthorItem, error) {
+	data := &AuthorItem{}
+	if err := m.authors.FindOne(ctx, bson.M{"_id": id}).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAuthorNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *MongoStore) UpdateAuthor(ctx context.Context, item *AuthorItem, fields []string) (*AuthorItem, error) {
+	set := bson.M{"update_time": item.UpdateTime}
+	for _, field := range fields {
+		if value := item.fieldValue(field); value != nil {
+			set[field] = value
+		}
+	}
+	result := m.authors.FindOneAndUpdate(ctx, bson.M{"_id": item.ID}, bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After))
+	decoded := &AuthorItem{}
+	if err := result.Decode(decoded); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAuthorNotFound
+		}
+		return nil, err
+	}
+	return decoded, nil
+}
+
+func (m *MongoStore) ListAuthors(ctx context.Context, q AuthorQuery, fn func(*AuthorItem) error) error {
+	filter := bson.M{}
+	if q.After != "" {
+		filter["_id"] = bson.M{"$gt": q.After}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	cursor, err := m.authors.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		data := &AuthorItem{}
+		if err := cursor.Decode(data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// database is the database of the blogs, GridFS keeps the attachment content there too.
+func (m *MongoStore) database() *mongo.Database {
+	return m.blogdb.Database()
+}
+
+func (m *MongoStore) CreateAttachment(ctx context.Context, item *AttachmentItem) (*AttachmentItem, error) {
+	data := *item
+	data.ID = primitive.NilObjectID
+
+	result, err := m.attachments.InsertOne(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	data.ID = result.InsertedID.(primitive.ObjectID)
+	return &data, nil
+}
+
+func (m *MongoStore) ReadAttachment(ctx context.Context, id primitive.ObjectID) (*AttachmentItem, error) {
+	data := &AttachmentItem{}
+	if err := m.attachments.FindOne(ctx, bson.M{"_id": id}).Decode(data); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *MongoStore) ListAttachments(ctx context.Context, blogID primitive.ObjectID) ([]*AttachmentItem, error) {
+	cursor, err := m.attachments.Find(ctx, bson.M{"blog_id": blogID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var items []*AttachmentItem
+	if err := cursor.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (m *MongoStore) Close(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
+}
@@ -0,0 +1,935 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	// Pure Go SQLite driver, registers itself as "sqlite".
+	"modernc.org/sqlite"
+)
+
+func init() {
+	// SQLite's lower() and LIKE only fold ASCII, unicode_lower lets title_contains ignore case like the other stores.
+	sqlite.MustRegisterDeterministicScalarFunction("unicode_lower", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if s, ok := args[0].(string); ok {
+			return strings.ToLower(s), nil
+		}
+		return args[0], nil
+	})
+}
+
+// sqliteMigrations upgrade the schema one version at a time.
+// Migration i brings the schema to version i+1. Never edit a migration that has shipped,
+// append a new one instead.
+var sqliteMigrations = []string{
+	// 1: blogs table, id is the hex string of an ObjectID so ids stay compatible with MongoDB.
+	`CREATE TABLE blogs (
+		id        TEXT PRIMARY KEY,
+		author_id TEXT NOT NULL,
+		title     TEXT NOT NULL,
+		content   TEXT NOT NULL
+	)`,
+	// 2: optimistic concurrency control for updates.
+	`ALTER TABLE blogs ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	// 3 and 4: timestamps, in milliseconds since the epoch, 0 for blogs created before.
+	`ALTER TABLE blogs ADD COLUMN create_time INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE blogs ADD COLUMN update_time INTEGER NOT NULL DEFAULT 0`,
+	// 5 to 7: indexes for the ListBlogs filters and sort orders.
+	`CREATE INDEX blogs_author_id ON blogs (author_id, id)`,
+	`CREATE INDEX blogs_title ON blogs (title, id)`,
+	`CREATE INDEX blogs_create_time ON blogs (create_time, id)`,
+	// 8 and 9: tags, one row per tag of a blog.
+	`CREATE TABLE blog_tags (
+		blog_id TEXT NOT NULL,
+		tag     TEXT NOT NULL,
+		PRIMARY KEY (blog_id, tag)
+	)`,
+	`CREATE INDEX blog_tags_tag ON blog_tags (tag)`,
+	// 10 to 12: slugs, blogs.slug is the current one, blog_slugs every slug a blog ever had.
+	`ALTER TABLE blogs ADD COLUMN slug TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE blog_slugs (
+		slug    TEXT PRIMARY KEY,
+		blog_id TEXT NOT NULL
+	)`,
+	`CREATE INDEX blog_slugs_blog_id ON blog_slugs (blog_id)`,
+	// 13 to 15: publishing workflow, every blog from before was visible so they are published.
+	`ALTER TABLE blogs ADD COLUMN status TEXT NOT NULL DEFAULT 'published'`,
+	`ALTER TABLE blogs ADD COLUMN publish_time INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX blogs_status ON blogs (status, publish_time)`,
+	// 16 and 17: trash, 0 for live blogs.
+	`ALTER TABLE blogs ADD COLUMN delete_time INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX blogs_delete_time ON blogs (delete_time)`,
+	// 18 and 19: revision history, snapshot is the JSON encoded BlogItem.
+	`ALTER TABLE blogs ADD COLUMN editor TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE blog_revisions (
+		blog_id  TEXT NOT NULL,
+		version  INTEGER NOT NULL,
+		snapshot TEXT NOT NULL,
+		PRIMARY KEY (blog_id, version)
+	)`,
+	// 20 to 22: comments, parent_id is '' for comments on the blog itself, delete_time 0 unless deleted.
+	`CREATE TABLE comments (
+		id          TEXT PRIMARY KEY,
+		blog_id     TEXT NOT NULL,
+		parent_id   TEXT NOT NULL,
+		author_id   TEXT NOT NULL,
+		content     TEXT NOT NULL,
+		create_time INTEGER NOT NULL,
+		update_time INTEGER NOT NULL,
+		delete_time INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX comments_blog_id ON comments (blog_id, id)`,
+	`CREATE INDEX comments_parent_id ON comments (parent_id, id)`,
+	// 23: authors, the id is the handle blogs.author_id refers to.
+	`CREATE TABLE authors (
+		id           TEXT PRIMARY KEY,
+		display_name TEXT NOT NULL,
+		bio          TEXT NOT NULL,
+		email        TEXT NOT NULL,
+		create_time  INTEGER NOT NULL,
+		update_time  INTEGER NOT NULL
+	)`,
+	// 24: content format, every blog from before is plain text.
+	`ALTER TABLE blogs ADD COLUMN content_format TEXT NOT NULL DEFAULT 'plain'`,
+	// 25 and 26: attachments, the content is kept by hash outside the database.
+	`CREATE TABLE attachments (
+		id           TEXT PRIMARY KEY,
+		blog_id      TEXT NOT NULL,
+		filename     TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size         INTEGER NOT NULL,
+		sha256       TEXT NOT NULL,
+		create_time  INTEGER NOT NULL
+	)`,
+	`CREATE INDEX attachments_blog_id ON attachments (blog_id, id)`,
+}
+
+// likeEscaper escapes the LIKE wildcards in user input, used with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SQLiteStore keeps the blogs in a SQLite database.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens the database at path and migrates it to the latest schema.
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	// WAL lets readers (e.g. a slow ListBlogs stream) run next to a writer,
+	// writers wait on each other for up to busy_timeout instead of failing with "database is locked".
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// migrateSQLite applies every migration newer than the version recorded in schema_migrations.
+// Each migration runs in its own transaction together with its version bump.
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this server (%d)", version, len(sqliteMigrations))
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Migrated SQLite schema to version %d", i+1)
+	}
+	return nil
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// blogFields are the columns of the blogs table.
+const blogFields = `id, author_id, title, content, version, create_time, update_time, slug, status, publish_time, delete_time, editor, content_format`
+
+// blogColumns are the columns scanBlog expects, in order: blogFields then the space separated tags.
+// Normalized tags never contain spaces.
+const blogColumns = blogFields + `, (SELECT group_concat(tag, ' ') FROM blog_tags WHERE blog_id = blogs.id)`
+
+func scanBlog(row scanner) (*BlogItem, error) {
+	var id string
+	var createTime, updateTime, publishTime, deleteTime int64
+	var tags sql.NullString
+	data := &BlogItem{}
+	if err := row.Scan(&id, &data.AuthorID, &data.Title, &data.Content, &data.Version, &createTime, &updateTime,
+		&data.Slug, &data.Status, &publishTime, &deleteTime, &data.Editor, &data.ContentFormat, &tags); err != nil {
+		return nil, err
+	}
+	data.CreateTime = fromMillis(createTime)
+	data.UpdateTime = fromMillis(updateTime)
+	data.PublishTime = fromMillis(publishTime)
+	data.DeleteTime = fromMillis(deleteTime)
+	if tags.Valid {
+		data.Tags = strings.Fields(tags.String)
+		sort.Strings(data.Tags)
+	}
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt blog id %q: %v", id, err)
+	}
+	data.ID = oid
+	return data, nil
+}
+
+// replaceTags sets the tags of the blog with the given hex id.
+func replaceTags(ctx context.Context, tx *sql.Tx, id string, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blog_tags WHERE blog_id = ?`, id); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO blog_tags (blog_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimSlug records slug as a slug of the blog with the given hex id, unless another blog has it.
+func claimSlug(ctx context.Context, tx *sql.Tx, id, slug string) error {
+	var owner string
+	err := tx.QueryRowContext(ctx, `SELECT blog_id FROM blog_slugs WHERE slug = ?`, slug).Scan(&owner)
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, `INSERT INTO blog_slugs (slug, blog_id) VALUES (?, ?)`, slug, id)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if owner != id {
+		return ErrSlugTaken
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Create(ctx context.Context, item *BlogItem) (*BlogItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+	data.Version = 1
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertBlog(ctx, tx, &data); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// insertBlog inserts a new blog with its slug and tags.
+func insertBlog(ctx context.Context, tx *sql.Tx, data *BlogItem) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO blogs (`+blogFields+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		data.ID.Hex(), data.AuthorID, data.Title, data.Content, data.Version, toMillis(data.CreateTime), toMillis(data.UpdateTime),
+		data.Slug, data.status(), toMillis(data.PublishTime), toMillis(data.DeleteTime), data.Editor, data.format(),
+	)
+	if err != nil {
+		return err
+	}
+	if data.Slug != "" {
+		if err := claimSlug(ctx, tx, data.ID.Hex(), data.Slug); err != nil {
+			return err
+		}
+	}
+	return replaceTags(ctx, tx, data.ID.Hex(), data.Tags)
+}
+
+func (s *SQLiteStore) CreateMany(ctx context.Context, items []*BlogItem) ([]*BlogItem, []error) {
+	created := make([]*BlogItem, len(items))
+	errs := make([]error, len(items))
+	failAll := func(err error) ([]*BlogItem, []error) {
+		for i := range items {
+			created[i], errs[i] = nil, err
+		}
+		return created, errs
+	}
+
+	// One transaction for the whole batch, a savepoint per blog undoes just the blog that failed.
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return failAll(err)
+	}
+	defer tx.Rollback()
+
+	for i, item := range items {
+		data := *item
+		data.ID = primitive.NewObjectID()
+		data.Version = 1
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT blog`); err != nil {
+			return failAll(err)
+		}
+		if err := insertBlog(ctx, tx, &data); err != nil {
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO blog`); err != nil {
+				return failAll(err)
+			}
+			errs[i] = err
+		} else {
+			created[i] = &data
+		}
+		if _, err := tx.ExecContext(ctx, `RELEASE blog`); err != nil {
+			return failAll(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return failAll(err)
+	}
+	return created, errs
+}
+
+func (s *SQLiteStore) Read(ctx context.Context, id primitive.ObjectID) (*BlogItem, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+blogColumns+` FROM blogs WHERE id = ?`, id.Hex())
+	data, err := scanBlog(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *SQLiteStore) ReadBySlug(ctx context.Context, slug string) (*BlogItem, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT `+blogColumns+` FROM blogs WHERE id = (SELECT blog_id FROM blog_slugs WHERE slug = ?)`, slug)
+	data, err := scanBlog(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *SQLiteStore) Update(ctx context.Context, item *BlogItem, fields []string, expectedVersion int64) (*BlogItem, error) {
+	// The field names match the column names, anything not in updatableFields is
+	// skipped so a field name can never inject SQL. Tags live in their own table.
+	set := "update_time = ?, "
+	args := []interface{}{toMillis(item.UpdateTime)}
+	updateTags := false
+	for _, field := range fields {
+		if field == "tags" {
+			updateTags = true
+			continue
+		}
+		value := item.fieldValue(field)
+		if value == nil {
+			continue
+		}
+		if t, ok := value.(time.Time); ok {
+			value = toMillis(t)
+		}
+		set += field + " = ?, "
+		args = append(args, value)
+	}
+	args = append(args, item.ID.Hex(), expectedVersion, expectedVersion)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Checking the version in the WHERE clause makes the check and the update atomic.
+	result, err := tx.ExecContext(ctx,
+		`UPDATE blogs SET `+set+`version = version + 1
+		WHERE id = ? AND (? = 0 OR version = ?)`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if updated == 0 {
+		tx.Rollback()
+		if expectedVersion == 0 {
+			return nil, ErrNotFound
+		}
+		// Either the blog is gone or it is at another version, find out which one.
+		current, err := s.Read(ctx, item.ID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &VersionConflictError{Current: current.Version}
+	}
+	if updateTags {
+		if err := replaceTags(ctx, tx, item.ID.Hex(), item.Tags); err != nil {
+			return nil, err
+		}
+	}
+	// The old slug stays in blog_slugs and keeps redirecting.
+	if hasField(fields, "slug") && item.Slug != "" {
+		if err := claimSlug(ctx, tx, item.ID.Hex(), item.Slug); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := scanBlog(tx.QueryRowContext(ctx, `SELECT `+blogColumns+` FROM blogs WHERE id = ?`, item.ID.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *SQLiteStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM blogs WHERE id = ?`, id.Hex())
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blog_tags WHERE blog_id = ?`, id.Hex()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blog_slugs WHERE blog_id = ?`, id.Hex()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blog_revisions WHERE blog_id = ?`, id.Hex()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE blog_id = ?`, id.Hex()); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attachments WHERE blog_id = ?`, id.Hex()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) SaveRevision(ctx context.Context, rev *BlogItem) error {
+	snapshot, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO blog_revisions (blog_id, version, snapshot) VALUES (?, ?, ?)`,
+		rev.ID.Hex(), rev.Version, string(snapshot),
+	)
+	return err
+}
+
+func (s *SQLiteStore) ListRevisions(ctx context.Context, id primitive.ObjectID) ([]*BlogItem, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT snapshot FROM blog_revisions WHERE blog_id = ? ORDER BY version DESC`, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	revisions := []*BlogItem{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *SQLiteStore) ReadRevision(ctx context.Context, id primitive.ObjectID, version int64) (*BlogItem, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT snapshot FROM blog_revisions WHERE blog_id = ? AND version = ?`, id.Hex(), version)
+	rev, err := scanRevision(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return rev, err
+}
+
+func scanRevision(row scanner) (*BlogItem, error) {
+	var snapshot string
+	if err := row.Scan(&snapshot); err != nil {
+		return nil, err
+	}
+	rev := &BlogItem{}
+	if err := json.Unmarshal([]byte(snapshot), rev); err != nil {
+		return nil, fmt.Errorf("corrupt revision: %v", err)
+	}
+	return rev, nil
+}
+
+func (s *SQLiteStore) List(ctx context.Context, q ListQuery, fn func(*BlogItem) error) error {
+	where := []string{"delete_time = 0"}
+	if q.Deleted {
+		where = []string{"delete_time != 0"}
+	}
+	args := []interface{}{}
+	if q.AuthorID != "" {
+		where = append(where, "author_id = ?")
+		args = append(args, q.AuthorID)
+	}
+	if q.TitlePrefix != "" {
+		// A range instead of LIKE keeps the prefix case sensitive and lets SQLite use the title index.
+		where = append(where, "title >= ? AND title < ?")
+		args = append(args, q.TitlePrefix, q.TitlePrefix+"\U0010FFFF")
+	}
+	if q.TitleContains != "" {
+		where = append(where, `unicode_lower(title) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(q.TitleContains))+"%")
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "create_time >= ?")
+		args = append(args, toMillis(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "create_time < ?")
+		args = append(args, toMillis(q.CreatedBefore))
+	}
+	if len(q.Tags) > 0 {
+		// Blogs having as many of the wanted tags as there are wanted tags have all of them.
+		where = append(where, "id IN (SELECT blog_id FROM blog_tags WHERE tag IN (?"+strings.Repeat(", ?", len(q.Tags)-1)+") GROUP BY blog_id HAVING COUNT(*) = ?)")
+		for _, tag := range q.Tags {
+			args = append(args, tag)
+		}
+		args = append(args, len(q.Tags))
+	}
+	if len(q.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+
+	// Hex ObjectIDs sort the same way as the raw bytes, ordering by id gives insertion order.
+	dir, after := "ASC", ">"
+	if q.Desc {
+		dir, after = "DESC", "<"
+	}
+	orderBy := "id " + dir
+	switch q.SortBy {
+	case SortByTitle:
+		orderBy = "title " + dir + ", " + orderBy
+		if !q.After.IsZero() {
+			where = append(where, "(title, id) "+after+" (?, ?)")
+			args = append(args, q.AfterTitle, q.After.Hex())
+		}
+	case SortByCreateTime:
+		orderBy = "create_time " + dir + ", " + orderBy
+		if !q.After.IsZero() {
+			where = append(where, "(create_time, id) "+after+" (?, ?)")
+			args = append(args, toMillis(q.AfterCreateTime), q.After.Hex())
+		}
+	default:
+		if !q.After.IsZero() {
+			where = append(where, "id "+after+" ?")
+			args = append(args, q.After.Hex())
+		}
+	}
+
+	// A negative LIMIT means no limit in SQLite.
+	limit := -1
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+blogColumns+` FROM blogs WHERE `+strings.Join(where, " AND ")+` ORDER BY `+orderBy+` LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		data, err := scanBlog(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStore) ListTags(ctx context.Context) ([]TagCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT tag, COUNT(*) FROM blog_tags
+		WHERE blog_id IN (SELECT id FROM blogs WHERE delete_time = 0)
+		GROUP BY tag ORDER BY COUNT(*) DESC, tag`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []TagCount{}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tc)
+	}
+	return tags, rows.Err()
+}
+
+// commentColumns are the columns of the comments table, in the order scanComment expects them.
+const commentColumns = `id, blog_id, parent_id, author_id, content, create_time, update_time, delete_time`
+
+func scanComment(row scanner) (*CommentItem, error) {
+	var id, blogID, parentID string
+	var createTime, updateTime, deleteTime int64
+	data := &CommentItem{}
+	if err := row.Scan(&id, &blogID, &parentID, &data.AuthorID, &data.Content, &createTime, &updateTime, &deleteTime); err != nil {
+		return nil, err
+	}
+	data.CreateTime = fromMillis(createTime)
+	data.UpdateTime = fromMillis(updateTime)
+	data.DeleteTime = fromMillis(deleteTime)
+	var err error
+	if data.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("corrupt comment id %q: %v", id, err)
+	}
+	if data.BlogID, err = primitive.ObjectIDFromHex(blogID); err != nil {
+		return nil, fmt.Errorf("corrupt blog id %q of comment %s: %v", blogID, id, err)
+	}
+	if parentID != "" {
+		if data.ParentID, err = primitive.ObjectIDFromHex(parentID); err != nil {
+			return nil, fmt.Errorf("corrupt parent id %q of comment %s: %v", parentID, id, err)
+		}
+	}
+	return data, nil
+}
+
+// parentHex is the parent_id column of a comment.
+func parentHex(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
+
+func (s *SQLiteStore) CreateComment(ctx context.Context, item *CommentItem) (*CommentItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO comments (`+commentColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		data.ID.Hex(), data.BlogID.Hex(), parentHex(data.ParentID), data.AuthorID, data.Content,
+		toMillis(data.CreateTime), toMillis(data.UpdateTime), toMillis(data.DeleteTime),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *SQLiteStore) ReadComment(ctx context.Context, id primitive.ObjectID) (*CommentItem, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+commentColumns+` FROM comments WHERE id = ?`, id.Hex())
+	data, err := scanComment(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	return data, err
+}
+
+func (s *SQLiteStore) UpdateComment(ctx context.Context, item *CommentItem, fields []string) (*CommentItem, error) {
+	// Same as Update, only known field names make it into the SQL.
+	set := "update_time = ?"
+	args := []interface{}{toMillis(item.UpdateTime)}
+	for _, field := range fields {
+		value := item.fieldValue(field)
+		if value == nil {
+			continue
+		}
+		if t, ok := value.(time.Time); ok {
+			value = toMillis(t)
+		}
+		set += ", " + field + " = ?"
+		args = append(args, value)
+	}
+	args = append(args, item.ID.Hex())
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE comments SET `+set+` WHERE id = ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if updated == 0 {
+		return nil, ErrCommentNotFound
+	}
+	data, err := scanComment(tx.QueryRowContext(ctx, `SELECT `+commentColumns+` FROM comments WHERE id = ?`, item.ID.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *SQLiteStore) DeleteComment(ctx context.Context, id primitive.ObjectID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM comments WHERE id = ?`, id.Hex())
+	return err
+}
+
+func (s *SQLiteStore) ListComments(ctx context.Context, q CommentQuery, fn func(*CommentItem) error) error {
+	where := "blog_id = ?"
+	args := []interface{}{q.BlogID.Hex()}
+	if !q.ParentID.IsZero() {
+		where += " AND parent_id = ?"
+		args = append(args, q.ParentID.Hex())
+	}
+	if !q.After.IsZero() {
+		where += " AND id > ?"
+		args = append(args, q.After.Hex())
+	}
+	limit := -1
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+commentColumns+` FROM comments WHERE `+where+` ORDER BY id LIMIT ?`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		data, err := scanComment(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// authorColumns are the columns of the authors table, in the order scanAuthor expects them.
+const authorColumns = `id, display_name, bio, email, create_time, update_time`
+
+func scanAuthor(row scanner) (*AuthorItem, error) {
+	var createTime, updateTime int64
+	data := &AuthorItem{}
+	if err := row.Scan(&data.ID, &data.DisplayName, &data.Bio, &data.Email, &createTime, &updateTime); err != nil {
+		return nil, err
+	}
+	data.CreateTime = fromMillis(createTime)
+	data.UpdateTime = fromMillis(updateTime)
+	return data, nil
+}
+
+func (s *SQLiteStore) CreateAuthor(ctx context.Context, item *AuthorItem) (*AuthorItem, error) {
+	data := *item
+	// INSERT OR IGNORE leaves an existing author alone, no row inserted means the ID is taken.
+	result, err := s.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO authors (`+authorColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		data.ID, data.DisplayName, data.Bio, data.Email, toMillis(data.CreateTime), toMillis(data.UpdateTime),
+	)
+	if err != nil {
+		return nil, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted == 0 {
+		return nil, ErrAuthorExists
+	}
+	return &data, nil
+}
+
+func (s *SQLiteStore) ReadAuthor(ctx context.Context, id string) (*AuthorItem, error) {
+	data, err := scanAuthor(s.db.QueryRowContext(ctx, `SELECT `+authorColumns+` FROM authors WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrAuthorNotFound
+	}
+	return data, err
+}
+
+func (s *SQLiteStore) UpdateAuthor(ctx context.Context, item *AuthorItem, fields []string) (*AuthorItem, error) {
+	// Same as Update, only known field names make it into the SQL.
+	set := "update_time = ?"
+	args := []interface{}{toMillis(item.UpdateTime)}
+	for _, field := range fields {
+		if value := item.fieldValue(field); value != nil {
+			set += ", " + field + " = ?"
+			args = append(args, value)
+		}
+	}
+	args = append(args, item.ID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE authors SET `+set+` WHERE id = ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if updated == 0 {
+		return nil, ErrAuthorNotFound
+	}
+	data, err := scanAuthor(tx.QueryRowContext(ctx, `SELECT `+authorColumns+` FROM authors WHERE id = ?`, item.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *SQLiteStore) ListAuthors(ctx context.Context, q AuthorQuery, fn func(*AuthorItem) error) error {
+	limit := -1
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+authorColumns+` FROM authors WHERE id > ? ORDER BY id LIMIT ?`, q.After, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		data, err := scanAuthor(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// attachmentColumns are the columns of the attachments table, in the order scanAttachment expects them.
+const attachmentColumns = `id, blog_id, filename, content_type, size, sha256, create_time`
+
+func scanAttachment(row scanner) (*AttachmentItem, error) {
+	var id, blogID string
+	var createTime int64
+	data := &AttachmentItem{}
+	if err := row.Scan(&id, &blogID, &data.Filename, &data.ContentType, &data.Size, &data.SHA256, &createTime); err != nil {
+		return nil, err
+	}
+	data.CreateTime = fromMillis(createTime)
+	var err error
+	if data.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("corrupt attachment id %q: %v", id, err)
+	}
+	if data.BlogID, err = primitive.ObjectIDFromHex(blogID); err != nil {
+		return nil, fmt.Errorf("corrupt blog id %q of attachment %s: %v", blogID, id, err)
+	}
+	return data, nil
+}
+
+func (s *SQLiteStore) CreateAttachment(ctx context.Context, item *AttachmentItem) (*AttachmentItem, error) {
+	data := *item
+	data.ID = primitive.NewObjectID()
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO attachments (`+attachmentColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		data.ID.Hex(), data.BlogID.Hex(), data.Filename, data.ContentType, data.Size, data.SHA256, toMillis(data.CreateTime),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *SQLiteStore) ReadAttachment(ctx context.Context, id primitive.ObjectID) (*AttachmentItem, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id.Hex())
+	data, err := scanAttachment(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	return data, err
+}
+
+func (s *SQLiteStore) ListAttachments(ctx context.Context, blogID primitive.ObjectID) ([]*AttachmentItem, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+attachmentColumns+` FROM attachments WHERE blog_id = ? ORDER BY id`, blogID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*AttachmentItem
+	for rows.Next() {
+		data, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, data)
+	}
+	return items, rows.Err()
+}
+
+func (s *SQLiteStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
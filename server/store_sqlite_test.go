@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "blogs.db")
+	store, err := NewSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := createBlogs(t, store, 1)[0]
+	store.Close(ctx)
+
+	// Opening a migrated database again runs nothing and keeps the blogs.
+	store, err = NewSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(ctx, data.ID); err != nil {
+		t.Errorf("The blog is lost after reopening: %v", err)
+	}
+	var version int
+	if err := store.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Errorf("Got schema version %d, want %d", version, len(sqliteMigrations))
+	}
+
+	// A database migrated by a newer server is refused rather than misread.
+	if _, err := store.db.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES (?)`, len(sqliteMigrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	store.Close(ctx)
+	if store, err := NewSQLiteStore(ctx, path); err == nil {
+		store.Close(ctx)
+		t.Error("Opened a database with a newer schema")
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testStores are the stores that run without a server, MongoDB is left out.
+var testStores = []struct {
+	name string
+	open func(t *testing.T) BlogStore
+}{
+	{"memory", func(t *testing.T) BlogStore {
+		return NewMemoryStore()
+	}},
+	{"file", func(t *testing.T) BlogStore {
+		return openFileStore(t, filepath.Join(t.TempDir(), "blogs.log"))
+	}},
+	{"sqlite", func(t *testing.T) BlogStore {
+		store, err := NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "blogs.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close(context.Background()) })
+		return store
+	}},
+}
+
+func createBlogs(t *testing.T, store BlogStore, n int) []*BlogItem {
+	t.Helper()
+	var created []*BlogItem
+	for i := 0; i < n; i++ {
+		data, err := store.Create(context.Background(), &BlogItem{
+			AuthorID:   "alice",
+			Title:      fmt.Sprintf("Blog %d", i),
+			Content:    "Content",
+			CreateTime: now(),
+			UpdateTime: now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, data)
+	}
+	return created
+}
+
+// listTitles returns the titles of the blogs selected by q, in order.
+func listTitles(t *testing.T, store BlogStore, q ListQuery) []string {
+	t.Helper()
+	titles := []string{}
+	err := store.List(context.Background(), q, func(data *BlogItem) error {
+		titles = append(titles, data.Title)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return titles
+}
+
+func TestListParity(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	blogs := []*BlogItem{
+		{AuthorID: "alice", Title: "Über alles", Tags: []string{"go"}, Status: StatusPublished},
+		{AuthorID: "bob", Title: "über uns", Tags: []string{"go", "sql"}, Status: StatusDraft},
+		{AuthorID: "alice", Title: "Apple pie", Tags: []string{"food"}, Status: StatusPublished},
+		{AuthorID: "bob", Title: "apple tart", Tags: []string{"food", "sql"}, Status: StatusScheduled},
+		{AuthorID: "carol", Title: "100% cotton_fabric", Status: StatusPublished},
+		{AuthorID: "carol", Title: "Banana", Tags: []string{"food"}, Status: StatusPublished},
+		{AuthorID: "alice", Title: "Trashed apple", Status: StatusPublished, DeleteTime: start},
+	}
+	// Created in a different order than the titles sort.
+	createOrder := []int{5, 0, 3, 6, 1, 4, 2}
+
+	tests := []struct {
+		name string
+		q    ListQuery
+		want []string
+	}{
+		{"insertion order", ListQuery{},
+			[]string{"Banana", "Über alles", "apple tart", "über uns", "100% cotton_fabric", "Apple pie"}},
+		{"author", ListQuery{AuthorID: "bob"},
+			[]string{"apple tart", "über uns"}},
+		{"title prefix is case sensitive", ListQuery{TitlePrefix: "apple"},
+			[]string{"apple tart"}},
+		{"title contains ignores ASCII case", ListQuery{TitleContains: "APPLE"},
+			[]string{"apple tart", "Apple pie"}},
+		{"title contains ignores Unicode case", ListQuery{TitleContains: "ÜBER"},
+			[]string{"Über alles", "über uns"}},
+		{"title contains matches wildcards literally", ListQuery{TitleContains: "0% cotton_"},
+			[]string{"100% cotton_fabric"}},
+		{"an underscore is no wildcard", ListQuery{TitleContains: "_"},
+			[]string{"100% cotton_fabric"}},
+		{"created range", ListQuery{CreatedAfter: start.Add(time.Minute), CreatedBefore: start.Add(3 * time.Minute)},
+			[]string{"Über alles", "apple tart"}},
+		{"all tags", ListQuery{Tags: []string{"food", "sql"}},
+			[]string{"apple tart"}},
+		{"statuses", ListQuery{Statuses: []string{StatusDraft, StatusScheduled}},
+			[]string{"apple tart", "über uns"}},
+		{"trash", ListQuery{Deleted: true},
+			[]string{"Trashed apple"}},
+		{"by title", ListQuery{SortBy: SortByTitle},
+			[]string{"100% cotton_fabric", "Apple pie", "Banana", "apple tart", "Über alles", "über uns"}},
+		{"by title descending", ListQuery{SortBy: SortByTitle, Desc: true, Limit: 3},
+			[]string{"über uns", "Über alles", "apple tart"}},
+		{"by create time", ListQuery{SortBy: SortByCreateTime},
+			[]string{"Banana", "Über alles", "apple tart", "über uns", "100% cotton_fabric", "Apple pie"}},
+		{"by create time descending", ListQuery{SortBy: SortByCreateTime, Desc: true, AuthorID: "alice"},
+			[]string{"Apple pie", "Über alles"}},
+	}
+
+	for _, ts := range testStores {
+		t.Run(ts.name, func(t *testing.T) {
+			store := ts.open(t)
+			ids := make(map[string]*BlogItem)
+			for i, n := range createOrder {
+				data := *blogs[n]
+				data.Content = "Content"
+				data.CreateTime = start.Add(time.Duration(i) * time.Minute)
+				data.UpdateTime = data.CreateTime
+				created, err := store.Create(context.Background(), &data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids[created.Title] = created
+			}
+			for _, tt := range tests {
+				if got := listTitles(t, store, tt.q); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+				}
+			}
+
+			// Paging with the cursor gives the same order as a single list.
+			for _, q := range []ListQuery{{}, {SortBy: SortByTitle}, {SortBy: SortByCreateTime, Desc: true}} {
+				want := listTitles(t, store, q)
+				var got []string
+				page := q
+				page.Limit = 2
+				for {
+					titles := listTitles(t, store, page)
+					got = append(got, titles...)
+					if len(titles) < page.Limit {
+						break
+					}
+					last := ids[titles[len(titles)-1]]
+					page.After, page.AfterTitle, page.AfterCreateTime = last.ID, last.Title, last.CreateTime
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Sort %q desc %v: paged %q, want %q", q.SortBy, q.Desc, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestBlogStoreContract checks every store keeps the promises of the BlogStore interface.
+func TestBlogStoreContract(t *testing.T) {
+	for _, ts := range testStores {
+		t.Run(ts.name, func(t *testing.T) {
+			t.Run("blogs", func(t *testing.T) { testBlogs(t, ts.open(t)) })
+			t.Run("list", func(t *testing.T) { testList(t, ts.open(t)) })
+			t.Run("create many", func(t *testing.T) { testCreateMany(t, ts.open(t)) })
+			t.Run("revisions", func(t *testing.T) { testRevisions(t, ts.open(t)) })
+			t.Run("tags", func(t *testing.T) { testTags(t, ts.open(t)) })
+			t.Run("comments", func(t *testing.T) { testComments(t, ts.open(t)) })
+			t.Run("authors", func(t *testing.T) { testAuthors(t, ts.open(t)) })
+			t.Run("attachments", func(t *testing.T) { testAttachments(t, ts.open(t)) })
+		})
+	}
+}
+
+func testBlogs(t *testing.T, store BlogStore) {
+	ctx := context.Background()
+	created, err := store.Create(ctx, &BlogItem{
+		AuthorID:   "alice",
+		Title:      "First",
+		Content:    "Content",
+		Tags:       []string{"go", "sql"},
+		Slug:       "first",
+		Slugs:      []string{"first"},
+		Status:     StatusPublished,
+		CreateTime: now(),
+		UpdateTime: now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID.IsZero() || created.Version != 1 {
+		t.Fatalf("Got ID %s version %d, want a new ID at version 1", created.ID.Hex(), created.Version)
+	}
+
+	read, err := store.Read(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Title != "First" || read.Content != "Content" || read.AuthorID != "alice" || !reflect.DeepEqual(read.Tags, created.Tags) ||
+		!read.CreateTime.Equal(created.CreateTime) || read.status() != StatusPublished {
+		t.Errorf("Read %+v, want %+v", read, created)
+	}
+	if _, err := store.Read(ctx, primitive.NewObjectID()); err != ErrNotFound {
+		t.Errorf("Got %v reading a missing blog, want ErrNotFound", err)
+	}
+
+	// Only the given fields change.
+	updated, err := store.Update(ctx, &BlogItem{ID: created.ID, Title: "Renamed", Content: "Ignored", UpdateTime: now()}, []string{"title"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Version != 2 || updated.Title != "Renamed" || updated.Content != "Content" {
+		t.Errorf("Got version %d title %q content %q, want 2, Renamed, Content", updated.Version, updated.Title, updated.Content)
+	}
+	var conflict *VersionConflictError
+	_, err = store.Update(ctx, &BlogItem{ID: created.ID, Title: "Stale", UpdateTime: now()}, []string{"title"}, 1)
+	if !errors.As(err, &conflict) || conflict.Current != 2 {
+		t.Errorf("Got %v updating an old version, want a conflict at version 2", err)
+	}
+	if _, err := store.Update(ctx, &BlogItem{ID: primitive.NewObjectID(), Title: "Missing"}, []string{"title"}, 0); err != ErrNotFound {
+		t.Errorf("Got %v updating a missing blog, want ErrNotFound", err)
+	}
+
+	// A slug belongs to one blog, old slugs keep pointing to it.
+	if _, err := store.Create(ctx, &BlogItem{AuthorID: "bob", Title: "Copy", Slug: "first", Slugs: []string{"first"}, CreateTime: now()}); err != ErrSlugTaken {
+		t.Errorf("Got %v creating a blog with a taken slug, want ErrSlugTaken", err)
+	}
+	if _, err := store.Update(ctx, &BlogItem{ID: created.ID, Slug: "renamed", UpdateTime: now()}, []string{"slug"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, slug := range []string{"first", "renamed"} {
+		if data, err := store.ReadBySlug(ctx, slug); err != nil || data.ID != created.ID {
+			t.Errorf("Got %v reading slug %s, want the blog", err, slug)
+		}
+	}
+	if _, err := store.ReadBySlug(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Got %v reading a missing slug, want ErrNotFound", err)
+	}
+
+	if err := store.Delete(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Read(ctx, created.ID); err != ErrNotFound {
+		t.Errorf("Got %v reading a deleted blog, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, created.ID); err != ErrNotFound {
+		t.Errorf("Got %v deleting a deleted blog, want ErrNotFound", err)
+	}
+}
+
+func testList(t *testing.T, store BlogStore) {
+	blogs := createBlogs(t, store, 3)
+	list := func(q ListQuery) []primitive.ObjectID {
+		t.Helper()
+		var ids []primitive.ObjectID
+		err := store.List(context.Background(), q, func(data *BlogItem) error {
+			ids = append(ids, data.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+
+	if got, want := list(ListQuery{}), []primitive.ObjectID{blogs[0].ID, blogs[1].ID, blogs[2].ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, want %v in insertion order", got, want)
+	}
+	// A page starts right after the last blog of the previous one.
+	if got, want := list(ListQuery{After: blogs[0].ID, Limit: 1}), []primitive.ObjectID{blogs[1].ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got page %v, want %v", got, want)
+	}
+}
+
+func testCreateMany(t *testing.T, store BlogStore) {
+	ctx := context.Background()
+	if _, err := store.Create(ctx, &BlogItem{AuthorID: "alice", Title: "Taken", Slug: "taken", Slugs: []string{"taken"}, CreateTime: now()}); err != nil {
+		t.Fatal(err)
+	}
+	items := []*BlogItem{
+		{AuthorID: "alice", Title: "One", CreateTime: now()},
+		{AuthorID: "alice", Title: "Two", Slug: "taken", Slugs: []string{"taken"}, CreateTime: now()},
+		{AuthorID: "alice", Title: "Three", CreateTime: now()},
+	}
+	created, errs := store.CreateMany(ctx, items)
+	if len(created) != len(items) || len(errs) != len(items) {
+		t.Fatalf("Got %d blogs and %d errors for %d items", len(created), len(errs), len(items))
+	}
+	if errs[1] != ErrSlugTaken || created[1] != nil {
+		t.Errorf("Got %v for the taken slug, want ErrSlugTaken", errs[1])
+	}
+	for _, i := range []int{0, 2} {
+		if errs[i] != nil {
+			t.Fatalf("%s: %v", items[i].Title, errs[i])
+		}
+		if data, err := store.Read(ctx, created[i].ID); err != nil || data.Title != items[i].Title {
+			t.Errorf("Got %v reading %s", err, items[i].Title)
+		}
+	}
+}
+
+func testRevisions(t *testing.T, store BlogStore) {
+	ctx := context.Background()
+	data := createBlogs(t, store, 1)[0]
+	for version, title := range []string{"Version 1", "Version 2"} {
+		rev := *data
+		rev.Version, rev.Title = int64(version+1), title
+		if err := store.SaveRevision(ctx, &rev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A version never changes.
+	again := *data
+	again.Version, again.Title = 1, "Rewritten"
+	if err := store.SaveRevision(ctx, &again); err != nil {
+		t.Fatal(err)
+	}
+
+	revs, err := store.ListRevisions(ctx, data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revs) != 2 || revs[0].Version != 2 || revs[1].Version != 1 {
+		t.Fatalf("Got %d revisions, want versions 2 and 1", len(revs))
+	}
+	rev, err := store.ReadRevision(ctx, data.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Title != "Version 1" {
+		t.Errorf("Got revision 1 titled %q, want Version 1", rev.Title)
+	}
+	if _, err := store.ReadRevision(ctx, data.ID, 3); err != ErrNotFound {
+		t.Errorf("Got %v reading a missing revision, want ErrNotFound", err)
+	}
+
+	// The revisions go with the blog.
+	if err := store.Delete(ctx, data.ID); err != nil {
+		t.Fatal(err)
+	}
+	if revs, err := store.ListRevisions(ctx, data.ID); err != nil || len(revs) != 0 {
+		t.Errorf("Got %d revisions, %v of a deleted blog, want none", len(revs), err)
+	}
+}
+
+func testTags(t *testing.T, store BlogStore) {
+	ctx := context.Background()
+	for _, blog := range []*BlogItem{
+		{Title: "One", Tags: []string{"go", "sql"}},
+		{Title: "Two", Tags: []string{"go"}},
+		{Title: "Trashed", Tags: []string{"go", "trash"}, DeleteTime: now()},
+	} {
+		blog.AuthorID, blog.CreateTime = "alice", now()
+		if _, err := store.Create(ctx, blog); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tags, err := store.ListTags(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TagCount{{"go", 2}, {"sql", 1}}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("Got tags %v, want %v", tags, want)
+	}
+}
+
+func testComments(t *testing.T, store BlogStore) {
+	ctx := context.Background()
+	blog := createBlogs(t, store, 1)[0]
+	create := func(parent primitive.ObjectID, content string) *CommentItem {
+		t.Helper()
+		c, err := store.CreateComment(ctx, &CommentItem{BlogID: blog.ID, ParentID: parent, AuthorID: "bob", Content: content, CreateTime: now(), UpdateTime: now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	first := create(primitive.NilObjectID, "First")
+	reply := create(first.ID, "Reply")
+	second := create(primitive.NilObjectID, "Second")
+
+	updated, err := store.UpdateComment(ctx, &CommentItem{ID: first.ID, Content: "Edited", UpdateTime: now()}, []string{"content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Content != "Edited" || updated.AuthorID != "bob" {
+		t.Errorf("Got %+v after editing the content", updated)
+	}
+
+	list := func(q CommentQuery) []primitive.ObjectID {
+		t.Helper()
+		var ids []primitive.ObjectID
+		if err := store.ListComments(ctx, q, func(c *CommentItem) error {
+			ids = append(ids, c.ID)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return ids
+	}
+	if got, want := list(CommentQuery{BlogID: blog.ID}), []primitive.ObjectID{first.ID, reply.ID, second.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got comments %v, want %v", got, want)
+	}
+	if got, want := list(CommentQuery{BlogID: blog.ID, ParentID: first.ID}), []primitive.ObjectID{reply.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got replies %v, want %v", got, want)
+	}
+	if got, want := list(CommentQuery{BlogID: blog.ID, After: first.ID, Limit: 1}), []primitive.ObjectID{reply.ID}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got page %v, want %v", got, want)
+	}
+
+	if err := store.DeleteComment(ctx, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadComment(ctx, second.ID); err != ErrCommentNotFound {
+		t.Errorf("Got %v reading a deleted comment, want ErrCommentNotFound", err)
+	}
+	// The comments go with the blog.
+	if err := store.Delete(ctx, blog.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadComment(ctx, first.ID); err != ErrCommentNotFound {
+		t.Errorf("Got %v reading a comment of a deleted blog, want ErrCommentNotFound", err)
+	}
+}
+
+func testAuthors(t *testing.T, store BlogStore) {
+	ctx := context.Background()
+	for _, id := range []string{"carol", "alice", "bob"} {
+		if _, err := store.CreateAuthor(ctx, &AuthorItem{ID: id, DisplayName: id, CreateTime: now(), UpdateTime: now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.CreateAuthor(ctx, &AuthorItem{ID: "bob", DisplayName: "Another Bob"}); err != ErrAuthorExists {
+		t.Errorf("Got %v creating a taken ID, want ErrAuthorExists", err)
+	}
+	if _, err := store.ReadAuthor(ctx, "dave"); err != ErrAuthorNotFound {
+		t.Errorf("Got %v reading a missing author, want ErrAuthorNotFound", err)
+	}
+
+	updated, err := store.UpdateAuthor(ctx, &AuthorItem{ID: "bob", Bio: "Writes", DisplayName: "Ignored", UpdateTime: now()}, []string{"bio"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Bio != "Writes" || updated.DisplayName != "bob" {
+		t.Errorf("Got %+v after changing the bio", updated)
+	}
+
+	var ids []string
+	if err := store.ListAuthors(ctx, AuthorQuery{After: "alice", Limit: 1}, func(a *AuthorItem) error {
+		ids = append(ids, a.ID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"bob"}) {
+		t.Errorf("Got authors %v after alice, want bob", ids)
+	}
+}
+
+func testAttachments(t *testing.T, store BlogStore) {
+	ctx := context.Background()
+	blog := createBlogs(t, store, 1)[0]
+	var want []primitive.ObjectID
+	for _, name := range []string{"b.png", "a.png"} {
+		a, err := store.CreateAttachment(ctx, &AttachmentItem{BlogID: blog.ID, Filename: name, ContentType: "image/png", Size: 3, SHA256: "abc", CreateTime: now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, a.ID)
+	}
+	items, err := store.ListAttachments(ctx, blog.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []primitive.ObjectID
+	for _, a := range items {
+		got = append(got, a.ID)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got attachments %v, want %v in the order they were created", got, want)
+	}
+	if _, err := store.ReadAttachment(ctx, primitive.NewObjectID()); err != ErrAttachmentNotFound {
+		t.Errorf("Got %v reading a missing attachment, want ErrAttachmentNotFound", err)
+	}
+
+	// The attachments go with the blog.
+	if err := store.Delete(ctx, blog.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReadAttachment(ctx, want[0]); err != ErrAttachmentNotFound {
+		t.Errorf("Got %v reading an attachment of a deleted blog, want ErrAttachmentNotFound", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on the tags of a single blog.
+const (
+	maxTags      = 10
+	maxTagLength = 32
+)
+
+// TagCount is a tag with the number of blogs carrying it.
+type TagCount struct {
+	Tag   string `bson:"_id"`
+	Count int64  `bson:"count"`
+}
+
+// normalizeTag folds case and turns inner whitespace into dashes: " Go  Lang " becomes "go-lang".
+func normalizeTag(tag string) string {
+	return strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+}
+
+// normalizeTags normalizes every tag, drops empty and duplicate ones and sorts them,
+// so "Go", "go " and "GO" are all the same tag.
+// It fails if a tag is too long or there are too many of them.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxTags {
+		return nil, fmt.Errorf("a blog can have at most %d tags, got %d", maxTags, len(normalized))
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// hasTags reports whether tags contains all of wanted.
+func hasTags(tags, wanted []string) bool {
+	for _, w := range wanted {
+		found := false
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// countTags counts the tags of the given blogs, most used first and alphabetically for equal counts.
+// Blogs in the trash don't count.
+func countTags(items []BlogItem) []TagCount {
+	counts := map[string]int64{}
+	for _, data := range items {
+		if !data.DeleteTime.IsZero() {
+			continue
+		}
+		for _, tag := range data.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	sortTagCounts(tags)
+	return tags
+}
+
+func sortTagCounts(tags []TagCount) {
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+)
+
+// errInTrash is the error of a change to a blog that is in the trash.
+var errInTrash = errors.New("blog is in the trash")
+
+// notInTrash is the check of updateWithRevision for the changes only live blogs may get,
+// blogs in the trash have to be restored first.
+func notInTrash(current *BlogItem) error {
+	if !current.DeleteTime.IsZero() {
+		return errInTrash
+	}
+	return nil
+}
+
+// trashPurger permanently deletes blogs that have been in the trash for longer than the retention.
+type trashPurger struct {
+	store     BlogStore
+	feed      *changeFeed
+	retention time.Duration
+}
+
+// run purges right away and then regularly until ctx is done.
+// Nobody waits for a purge, so checking once an hour is enough unless the retention is shorter.
+func (p *trashPurger) run(ctx context.Context) {
+	interval := time.Hour
+	if p.retention < interval {
+		interval = p.retention
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := p.purgeExpired(ctx); err != nil {
+			log.Printf("Could not purge the trash: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d blogs from the trash", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeExpired deletes every blog trashed before the retention and returns how many.
+func (p *trashPurger) purgeExpired(ctx context.Context) (int, error) {
+	var expired []*BlogItem
+	cutoff := now().Add(-p.retention)
+	err := p.store.List(ctx, ListQuery{Deleted: true}, func(data *BlogItem) error {
+		if data.DeleteTime.Before(cutoff) {
+			expired = append(expired, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, data := range expired {
+		// A blog restored since it was listed has a new version, leave it alone.
+		current, err := p.store.Read(ctx, data.ID)
+		if err == ErrNotFound || (err == nil && current.Version != data.Version) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		err = p.store.Delete(ctx, data.ID)
+		if err == ErrNotFound {
+			// Purged through PurgeBlog in the meantime.
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		p.feed.publishAt(blogpb.BlogEventType_DELETED, current, now())
+		purged++
+	}
+	return purged, nil
+}
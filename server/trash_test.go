@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestTrashedBlogsCantBeChanged(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	if _, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: e.blogID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]func() error{
+		"update": func() error {
+			_, err := e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+				Blog:       &blogpb.Blog{Id: e.blogID, Title: "Back from the trash"},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+			})
+			return err
+		},
+		"revert": func() error {
+			_, err := e.blogs.RevertBlog(ctx, &blogpb.RevertBlogReq{BlogId: e.blogID, Number: 1})
+			return err
+		},
+		"publish": func() error {
+			_, err := e.blogs.PublishBlog(ctx, &blogpb.PublishBlogReq{Id: e.blogID})
+			return err
+		},
+		"unpublish": func() error {
+			_, err := e.blogs.UnpublishBlog(ctx, &blogpb.UnpublishBlogReq{Id: e.blogID})
+			return err
+		},
+	}
+	for name, change := range changes {
+		if got := status.Code(change()); got != codes.FailedPrecondition {
+			t.Errorf("%s: got %v, want FailedPrecondition", name, got)
+		}
+	}
+
+	after, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: e.blogID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.GetBlog().GetVersion() != before.GetBlog().GetVersion() {
+		t.Errorf("The trashed blog went from version %d to %d", before.GetBlog().GetVersion(), after.GetBlog().GetVersion())
+	}
+}
+
+func TestSearchSkipsTrashedBlogs(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	search := func() int {
+		t.Helper()
+		res, err := e.blogs.SearchBlogs(ctx, &blogpb.SearchBlogsReq{Query: "conformance"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res.GetResults())
+	}
+	if got := search(); got != 1 {
+		t.Fatalf("Got %d results before deleting, want 1", got)
+	}
+
+	if _, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	// A failed update must not put the blog back into the index.
+	e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+		Blog:       &blogpb.Blog{Id: e.blogID, Title: "Conformance again"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	})
+	if got := search(); got != 0 {
+		t.Errorf("Got %d results for a blog in the trash, want none", got)
+	}
+
+	if _, err := e.blogs.RestoreBlog(ctx, &blogpb.RestoreBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(); got != 1 {
+		t.Errorf("Got %d results after restoring, want 1", got)
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	purger := &trashPurger{store: e.store, feed: e.feed, retention: time.Hour}
+	if n, err := purger.purgeExpired(ctx); err != nil || n != 0 {
+		t.Fatalf("Got %d purged, %v, want none within the retention", n, err)
+	}
+
+	purger.retention = 0
+	if n, err := purger.purgeExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("Got %d purged, %v, want the one trashed blog", n, err)
+	}
+	if _, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: e.trashedID}); status.Code(err) != codes.NotFound {
+		t.Errorf("Got %v reading the purged blog, want NotFound", err)
+	}
+	if _, err := e.blogs.ReadBlog(ctx, &blogpb.ReadBlogReq{Id: e.blogID}); err != nil {
+		t.Errorf("The live blog is gone: %v", err)
+	}
+}
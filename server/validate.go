@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Limits of the blog fields and of the other request fields without a limit of their own.
+const (
+	maxTitleLength   = 200
+	maxContentLength = 100000
+	maxEditorLength  = 100
+	maxQueryLength   = 500
+	// maxHighlightTagLength limits the pre_tag and post_tag of SearchBlogs.
+	maxHighlightTagLength = 50
+)
+
+// fieldViolations collects what is wrong with a request field by field. Fields are named
+// by their path in the request, like "blog.title", and go to the client as the field
+// violations of a google.rpc.BadRequest.
+type fieldViolations struct {
+	list []*errdetails.BadRequest_FieldViolation
+}
+
+func (v *fieldViolations) add(field, format string, args ...interface{}) {
+	v.list = append(v.list, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// check adds err as the violation of field, if there is one.
+func (v *fieldViolations) check(field string, err error) {
+	if err != nil {
+		v.add(field, "%v", err)
+	}
+}
+
+// text checks an optional string is valid UTF-8 and at most max characters long.
+func (v *fieldViolations) text(field, value string, max int) {
+	if !utf8.ValidString(value) {
+		v.add(field, "must be valid UTF-8")
+	} else if utf8.RuneCountInString(value) > max {
+		v.add(field, "is longer than %d characters", max)
+	}
+}
+
+// requiredText is text for a string that must not be blank.
+func (v *fieldViolations) requiredText(field, value string, max int) {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "must not be empty")
+		return
+	}
+	v.text(field, value, max)
+}
+
+// objectID checks a required ID is the hex form of an ObjectID.
+func (v *fieldViolations) objectID(field, id string) {
+	if id == "" {
+		v.add(field, "must not be empty")
+	} else if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		v.add(field, "%q is not an ID of 24 hex digits", id)
+	}
+}
+
+// optionalObjectID is objectID for an ID that may be left empty.
+func (v *fieldViolations) optionalObjectID(field, id string) {
+	if id != "" {
+		v.objectID(field, id)
+	}
+}
+
+func (v *fieldViolations) notNegative(field string, n int64) {
+	if n < 0 {
+		v.add(field, "must not be negative")
+	}
+}
+
+func (v *fieldViolations) timestamp(field string, ts *timestamppb.Timestamp) {
+	if ts != nil && ts.CheckValid() != nil {
+		v.add(field, "is not a valid timestamp")
+	}
+}
+
+// tags checks a list of tags the way normalizeTags takes them.
+func (v *fieldViolations) tags(field string, tags []string) {
+	for i, tag := range tags {
+		if !utf8.ValidString(tag) {
+			v.add(fmt.Sprintf("%s[%d]", field, i), "must be valid UTF-8")
+		}
+	}
+	if _, err := normalizeTags(tags); err != nil {
+		v.add(field, "%v", err)
+	}
+}
+
+// err returns nil without violations, otherwise an InvalidArgument error listing them,
+// in the message for people and as a BadRequest detail for programs.
+func (v *fieldViolations) err() error {
+	if len(v.list) == 0 {
+		return nil
+	}
+	problems := make([]string, len(v.list))
+	for i, violation := range v.list {
+		problems[i] = violation.GetField() + " " + violation.GetDescription()
+	}
+	st := status.New(codes.InvalidArgument, "Invalid request: "+strings.Join(problems, ", "))
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v.list})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// invalidField is the error of a request with a single bad field, found by a handler.
+func invalidField(field, format string, args ...interface{}) error {
+	v := &fieldViolations{}
+	v.add(field, format, args...)
+	return v.err()
+}
+
+// requestValidator is the validation layer in front of every RPC: requests breaking the rules
+// below are rejected before they reach a handler. It checks everything that can be told from
+// the request alone, the handlers only check against the stored data.
+type requestValidator struct {
+	// maxAttachmentSize is the largest attachment accepted in bytes.
+	maxAttachmentSize int64
+}
+
+// unary is the grpc.UnaryServerInterceptor of the layer.
+func (r *requestValidator) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := r.validate(req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// stream is the grpc.StreamServerInterceptor of the layer, it checks every message received.
+func (r *requestValidator) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &validatingStream{ServerStream: ss, validator: r})
+}
+
+type validatingStream struct {
+	grpc.ServerStream
+	validator *requestValidator
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.validator.validate(m)
+}
+
+// validate applies the rules of the request message.
+func (r *requestValidator) validate(req interface{}) error {
+	v := &fieldViolations{}
+	switch req := req.(type) {
+	// BlogService
+	case *blogpb.CreateBlogReq:
+		validateNewBlog(v, "blog", req.GetBlog())
+		v.text("idempotency_key", req.GetIdempotencyKey(), maxIdempotencyKeyLength)
+	case *blogpb.ReadBlogReq:
+		v.objectID("id", req.GetId())
+	case *blogpb.ReadBlogBySlugReq:
+		if req.GetSlug() == "" {
+			v.add("slug", "must not be empty")
+		} else if !isSlug(req.GetSlug()) {
+			// Only the form: the length limit is for slugs being chosen, not looked up.
+			v.add("slug", "%q is not a slug, like %q", req.GetSlug(), slugify(req.GetSlug()))
+		}
+	case *blogpb.UpdateBlogReq:
+		validateBlogUpdate(v, req)
+	case *blogpb.DeleteBlogReq:
+		v.objectID("id", req.GetId())
+	case *blogpb.ListRevisionsReq:
+		v.objectID("blog_id", req.GetBlogId())
+	case *blogpb.GetRevisionReq:
+		v.objectID("blog_id", req.GetBlogId())
+		if req.GetNumber() <= 0 {
+			v.add("number", "must be positive")
+		}
+	case *blogpb.RevertBlogReq:
+		v.objectID("blog_id", req.GetBlogId())
+		if req.GetNumber() <= 0 {
+			v.add("number", "must be positive")
+		}
+		v.notNegative("expected_version", req.GetExpectedVersion())
+		v.text("editor", req.GetEditor(), maxEditorLength)
+	case *blogpb.PublishBlogReq:
+		v.objectID("id", req.GetId())
+		v.timestamp("publish_time", req.GetPublishTime())
+		v.notNegative("expected_version", req.GetExpectedVersion())
+	case *blogpb.UnpublishBlogReq:
+		v.objectID("id", req.GetId())
+		v.notNegative("expected_version", req.GetExpectedVersion())
+	case *blogpb.RestoreBlogReq:
+		v.objectID("id", req.GetId())
+	case *blogpb.PurgeBlogReq:
+		v.objectID("id", req.GetId())
+	case *blogpb.ListBlogsReq:
+		v.notNegative("page_size", int64(req.GetPageSize()))
+		v.text("author_id", req.GetAuthorId(), maxAuthorIDLength)
+		v.text("title_prefix", req.GetTitlePrefix(), maxTitleLength)
+		v.text("title_contains", req.GetTitleContains(), maxTitleLength)
+		v.timestamp("created_after", req.GetCreatedAfter())
+		v.timestamp("created_before", req.GetCreatedBefore())
+		v.tags("tags", req.GetTags())
+		// statuses and order_by are checked where they are translated, in listQueryFromRequest.
+	case *blogpb.ListDeletedBlogsReq:
+		v.notNegative("page_size", int64(req.GetPageSize()))
+	case *blogpb.SearchBlogsReq:
+		v.requiredText("query", req.GetQuery(), maxQueryLength)
+		v.notNegative("limit", int64(req.GetLimit()))
+		v.text("pre_tag", req.GetPreTag(), maxHighlightTagLength)
+		v.text("post_tag", req.GetPostTag(), maxHighlightTagLength)
+	case *blogpb.ListTagsReq:
+	case *blogpb.WatchBlogsReq:
+		v.text("author_id", req.GetAuthorId(), maxAuthorIDLength)
+		v.tags("tags", req.GetTags())
+	case *blogpb.BatchCreateBlogsReq:
+		// Checked blog by blog in BatchCreateBlogs, a bad blog only fails its own result.
+	case *blogpb.RenderBlogReq:
+		v.objectID("id", req.GetId())
+		v.notNegative("version", req.GetVersion())
+
+	// CommentService
+	case *blogpb.CreateCommentReq:
+		v.objectID("comment.blog_id", req.GetComment().GetBlogId())
+		v.optionalObjectID("comment.parent_id", req.GetComment().GetParentId())
+		v.text("comment.author_id", req.GetComment().GetAuthorId(), maxAuthorIDLength)
+		v.check("comment.content", validateCommentContent(req.GetComment().GetContent()))
+	case *blogpb.UpdateCommentReq:
+		v.objectID("id", req.GetId())
+		v.check("content", validateCommentContent(req.GetContent()))
+	case *blogpb.DeleteCommentReq:
+		v.objectID("id", req.GetId())
+	case *blogpb.ListCommentsReq:
+		v.objectID("blog_id", req.GetBlogId())
+		v.optionalObjectID("parent_id", req.GetParentId())
+		v.notNegative("page_size", int64(req.GetPageSize()))
+
+	// AuthorService
+	case *blogpb.CreateAuthorReq:
+		if req.GetAuthor() == nil {
+			v.add("author", "is required")
+			break
+		}
+		v.check("author.id", validateAuthorID(req.GetAuthor().GetId()))
+		validateAuthorFields(v, req.GetAuthor(), authorFields)
+	case *blogpb.ReadAuthorReq:
+		v.requiredText("id", req.GetId(), maxAuthorIDLength)
+	case *blogpb.UpdateAuthorReq:
+		if req.GetAuthor() == nil {
+			v.add("author", "is required")
+			break
+		}
+		v.requiredText("author.id", req.GetAuthor().GetId(), maxAuthorIDLength)
+		fields := req.GetUpdateMask().GetPaths()
+		if len(fields) == 0 {
+			fields = authorFields
+		}
+		for _, field := range fields {
+			if !hasField(authorFields, field) {
+				v.add("update_mask", "unknown field %q, allowed fields are %v", field, authorFields)
+			}
+		}
+		validateAuthorFields(v, req.GetAuthor(), fields)
+	case *blogpb.ListAuthorsReq:
+		v.notNegative("page_size", int64(req.GetPageSize()))
+
+	// AttachmentService
+	case *blogpb.UploadAttachmentReq:
+		// Only the first message of an upload carries the attachment, the others are just content.
+		if req.GetAttachment() != nil {
+			validateAttachment(v, req.GetAttachment(), r.maxAttachmentSize)
+		}
+	case *blogpb.DownloadAttachmentReq:
+		v.objectID("id", req.GetId())
+	case *blogpb.ListAttachmentsReq:
+		v.objectID("blog_id", req.GetBlogId())
+
+	default:
+		// A new RPC without rules would slip through unchecked.
+		return status.Errorf(codes.Internal, "No validation rules for %T", req)
+	}
+	return v.err()
+}
+
+// validateNewBlog checks a blog to create, field is its path in the request.
+func validateNewBlog(v *fieldViolations, field string, blog *blogpb.Blog) {
+	if blog == nil {
+		v.add(field, "is required")
+		return
+	}
+	v.check(field+".author_id", validateAuthorID(blog.GetAuthorId()))
+	v.requiredText(field+".title", blog.GetTitle(), maxTitleLength)
+	v.requiredText(field+".content", blog.GetContent(), maxContentLength)
+	v.tags(field+".tags", blog.GetTags())
+	if blog.GetSlug() != "" {
+		v.check(field+".slug", validateSlug(blog.GetSlug()))
+	}
+	switch blog.GetStatus() {
+	case blogpb.BlogStatus_BLOG_STATUS_UNSPECIFIED, blogpb.BlogStatus_DRAFT, blogpb.BlogStatus_PUBLISHED, blogpb.BlogStatus_SCHEDULED:
+	default:
+		v.add(field+".status", "a new blog cannot be %s", blog.GetStatus())
+	}
+	v.timestamp(field+".publish_time", blog.GetPublishTime())
+	if _, err := formatFromProto(blog.GetContentFormat()); err != nil {
+		v.add(field+".content_format", "unknown format %v", blog.GetContentFormat())
+	}
+}
+
+// validateBlogUpdate checks the fields of an UpdateBlogReq named in its update_mask.
+func validateBlogUpdate(v *fieldViolations, req *blogpb.UpdateBlogReq) {
+	blog := req.GetBlog()
+	if blog == nil {
+		v.add("blog", "is required")
+		return
+	}
+	v.objectID("blog.id", blog.GetId())
+	v.notNegative("expected_version", req.GetExpectedVersion())
+	v.text("editor", req.GetEditor(), maxEditorLength)
+
+	fields := req.GetUpdateMask().GetPaths()
+	if len(fields) == 0 {
+		fields = updatableFields
+	}
+	for _, field := range fields {
+		switch field {
+		case "author_id":
+			v.check("blog.author_id", validateAuthorID(blog.GetAuthorId()))
+		case "title":
+			v.requiredText("blog.title", blog.GetTitle(), maxTitleLength)
+		case "content":
+			v.requiredText("blog.content", blog.GetContent(), maxContentLength)
+		case "tags":
+			v.tags("blog.tags", blog.GetTags())
+		case "slug":
+			// An empty slug follows the title again.
+			if blog.GetSlug() != "" {
+				v.check("blog.slug", validateSlug(blog.GetSlug()))
+			}
+		case "content_format":
+			if _, err := formatFromProto(blog.GetContentFormat()); err != nil {
+				v.add("blog.content_format", "unknown format %v", blog.GetContentFormat())
+			}
+		default:
+			v.add("update_mask", "unknown field %q, allowed fields are %v", field, updatableFields)
+		}
+	}
+}
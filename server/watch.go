@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// errResumeTokenExpired is returned for resume tokens whose following events are no longer kept.
+	errResumeTokenExpired = errors.New("resume token expired")
+	// errFeedClosed is returned to watchers once the server is shutting down.
+	errFeedClosed = errors.New("change feed closed")
+)
+
+// blogEvent is a change to a blog, numbered in the order of the changes.
+type blogEvent struct {
+	seq  uint64
+	kind blogpb.BlogEventType
+	blog BlogItem
+	time time.Time
+}
+
+// changeFeed is the pub/sub behind WatchBlogs. The RPCs changing blogs publish an event
+// per change into a buffer of the latest events, every watcher reads them from there at
+// its own pace. A slow watcher never holds up an RPC, and one that reconnects resumes
+// where it left off as long as its events are still in the buffer.
+type changeFeed struct {
+	mu sync.Mutex
+	// epoch tells the resume tokens of this run from those of a previous one, whose events are gone.
+	epoch   string
+	history int
+	// events holds the latest events oldest first, next is the seq of the event after the last one.
+	events []blogEvent
+	next   uint64
+	// changed is closed and replaced on every publish, waking up the watchers.
+	changed chan struct{}
+	closed  bool
+}
+
+// newChangeFeed keeps the latest history events for watchers to resume from.
+func newChangeFeed(history int) *changeFeed {
+	epoch := make([]byte, 4)
+	rand.Read(epoch)
+	return &changeFeed{
+		epoch:   hex.EncodeToString(epoch),
+		history: history,
+		next:    1,
+		changed: make(chan struct{}),
+	}
+}
+
+// publish records a change to a blog and wakes up the watchers.
+func (f *changeFeed) publish(kind blogpb.BlogEventType, data *BlogItem) {
+	f.publishAt(kind, data, data.UpdateTime)
+}
+
+// publishAt is publish for a change that happened at t rather than at the update time of the blog,
+// like purging it.
+func (f *changeFeed) publishAt(kind blogpb.BlogEventType, data *BlogItem, t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.events = append(f.events, blogEvent{seq: f.next, kind: kind, blog: *data, time: t})
+	f.next++
+	if len(f.events) > f.history {
+		// Copy instead of reslicing, so the dropped events don't stay behind in the array.
+		f.events = append([]blogEvent(nil), f.events[len(f.events)-f.history:]...)
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// since returns the events from seq on and a channel that is closed once more events follow.
+// It fails with errResumeTokenExpired if some of them were dropped already.
+func (f *changeFeed) since(seq uint64) ([]blogEvent, <-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, nil, errFeedClosed
+	}
+	oldest := f.next - uint64(len(f.events))
+	if seq < oldest || seq > f.next {
+		return nil, nil, errResumeTokenExpired
+	}
+	events := append([]blogEvent(nil), f.events[seq-oldest:]...)
+	return events, f.changed, nil
+}
+
+// head is the seq of the next event, watching without a resume token starts there.
+func (f *changeFeed) head() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.next
+}
+
+// close ends every watch, called on shutdown so the streams don't hold up GracefulStop.
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.changed)
+	}
+}
+
+// resumeToken is the token of the event with the given seq: "<epoch>.<seq>".
+func (f *changeFeed) resumeToken(seq uint64) string {
+	return fmt.Sprintf("%s.%d", f.epoch, seq)
+}
+
+// parseResumeToken returns the seq of the event a token was issued for.
+// Tokens of a previous run are expired, their events are gone.
+func (f *changeFeed) parseResumeToken(token string) (uint64, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return 0, errors.New("malformed resume token")
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, errors.New("malformed resume token")
+	}
+	if parts[0] != f.epoch {
+		return 0, errResumeTokenExpired
+	}
+	return seq, nil
+}
+
+// watchFilter selects the events sent to a watcher.
+type watchFilter struct {
+	authorID string
+	tags     []string
+}
+
+func (w *watchFilter) matches(data *BlogItem) bool {
+	if w.authorID != "" && data.AuthorID != w.authorID {
+		return false
+	}
+	return hasTags(data.Tags, w.tags)
+}
+
+func (s BlogServiceServer) WatchBlogs(req *blogpb.WatchBlogsReq, stream blogpb.BlogService_WatchBlogsServer) error {
+	tags, err := normalizeTags(req.GetTags())
+	if err != nil {
+		return invalidField("tags", "%v", err)
+	}
+	filter := &watchFilter{authorID: req.GetAuthorId(), tags: tags}
+
+	seq := s.feed.head()
+	if req.GetResumeToken() != "" {
+		last, err := s.feed.parseResumeToken(req.GetResumeToken())
+		if err == errResumeTokenExpired {
+			return status.Errorf(codes.FailedPrecondition, "resume_token is from before the server restarted, list the blogs again and watch without one")
+		}
+		if err != nil {
+			return invalidField("resume_token", "%v", err)
+		}
+		seq = last + 1
+	}
+
+	for {
+		events, changed, err := s.feed.since(seq)
+		if err == errFeedClosed {
+			return status.Errorf(codes.Unavailable, "Server is shutting down, watch again with the last resume_token")
+		}
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "Events after the resume_token are no longer kept, list the blogs again and watch without one")
+		}
+		skipped := false
+		for _, ev := range events {
+			seq = ev.seq + 1
+			skipped = !filter.matches(&ev.blog)
+			if skipped {
+				continue
+			}
+			err := stream.Send(&blogpb.WatchBlogsRes{
+				Type:        ev.kind,
+				Blog:        ev.blog.toProto(),
+				Time:        timestampOrNil(ev.time),
+				ResumeToken: s.feed.resumeToken(ev.seq),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// Move the resume token past the events left out, so resuming doesn't fail once the
+		// last sent event is gone from the history although nothing was missed.
+		if skipped {
+			if err := stream.Send(&blogpb.WatchBlogsRes{ResumeToken: s.feed.resumeToken(seq - 1)}); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
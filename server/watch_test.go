@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	blogpb "github.com/snow-dev/simple-api/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestChangeFeedHistory(t *testing.T) {
+	feed := newChangeFeed(2)
+	for _, title := range []string{"One", "Two", "Three"} {
+		feed.publish(blogpb.BlogEventType_CREATED, &BlogItem{Title: title})
+	}
+
+	// The first event was dropped to keep two.
+	if _, _, err := feed.since(1); err != errResumeTokenExpired {
+		t.Errorf("Got %v for a dropped event, want errResumeTokenExpired", err)
+	}
+	events, _, err := feed.since(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].blog.Title != "Two" || events[1].blog.Title != "Three" {
+		t.Errorf("Got %d events from 2, want Two and Three", len(events))
+	}
+	// Nothing happened after the head yet, a watcher waits there.
+	events, changed, err := feed.since(feed.head())
+	if err != nil || len(events) != 0 {
+		t.Fatalf("Got %d events, %v at the head, want none", len(events), err)
+	}
+	feed.publish(blogpb.BlogEventType_UPDATED, &BlogItem{Title: "Three"})
+	select {
+	case <-changed:
+	default:
+		t.Error("Publishing didn't wake up the watchers")
+	}
+
+	seq, err := feed.parseResumeToken(feed.resumeToken(3))
+	if err != nil || seq != 3 {
+		t.Errorf("Got seq %d, %v from the token of 3", seq, err)
+	}
+	if _, err := newChangeFeed(2).parseResumeToken(feed.resumeToken(3)); err != errResumeTokenExpired {
+		t.Errorf("Got %v for a token of another run, want errResumeTokenExpired", err)
+	}
+
+	feed.close()
+	if _, _, err := feed.since(feed.head()); err != errFeedClosed {
+		t.Errorf("Got %v after closing, want errFeedClosed", err)
+	}
+}
+
+// watch starts a watch with req and waits briefly for its first message.
+// It returns the error the watch failed with, nil if it was still waiting.
+func (e *testEnv) watch(ctx context.Context, req *blogpb.WatchBlogsReq) error {
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	stream, err := e.blogs.WatchBlogs(ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	if status.Code(err) == codes.DeadlineExceeded {
+		return nil
+	}
+	return err
+}
+
+func TestWatchResume(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	// Start after everything published so far.
+	token := e.feed.resumeToken(e.feed.head() - 1)
+
+	// Changes made while nobody watches.
+	tagged := e.newBlog("Tagged")
+	tagged.Tags = []string{"go"}
+	created, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: tagged})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Untagged")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.blogs.UpdateBlog(ctx, &blogpb.UpdateBlogReq{
+		Blog:       &blogpb.Blog{Id: created.GetBlog().GetId(), Title: "Tagged and renamed"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: created.GetBlog().GetId()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resuming delivers every missed change of the watched blogs, in order.
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := e.blogs.WatchBlogs(watchCtx, &blogpb.WatchBlogsReq{Tags: []string{"Go"}, ResumeToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []blogpb.BlogEventType{blogpb.BlogEventType_CREATED, blogpb.BlogEventType_UPDATED, blogpb.BlogEventType_DELETED}
+	var last *blogpb.WatchBlogsRes
+	for _, kind := range want {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.GetType() != kind || res.GetBlog().GetId() != created.GetBlog().GetId() {
+			t.Fatalf("Got %v of %s, want %v of the tagged blog", res.GetType(), res.GetBlog().GetTitle(), kind)
+		}
+		last = res
+	}
+	cancel()
+
+	// Resuming from the last event sends only what came after it.
+	watchCtx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	stream, err = e.blogs.WatchBlogs(watchCtx, &blogpb.WatchBlogsReq{ResumeToken: last.GetResumeToken()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Later")}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetType() != blogpb.BlogEventType_CREATED || res.GetBlog().GetTitle() != "Later" {
+		t.Errorf("Got %v of %s, want the blog created later", res.GetType(), res.GetBlog().GetTitle())
+	}
+}
+
+func TestWatchExpiredResumeToken(t *testing.T) {
+	e := newTestEnv(t)
+	ctx := context.Background()
+	tests := map[string]codes.Code{
+		// A token of a previous run of the server.
+		newChangeFeed(10).resumeToken(1): codes.FailedPrecondition,
+		"malformed":                      codes.InvalidArgument,
+	}
+	for token, want := range tests {
+		if err := e.watch(ctx, &blogpb.WatchBlogsReq{ResumeToken: token}); status.Code(err) != want {
+			t.Errorf("%s: got %v, want %v", token, err, want)
+		}
+	}
+
+	// Events dropped from the history can't be resumed from either.
+	for i := 0; i < e.feed.history+2; i++ {
+		e.feed.publish(blogpb.BlogEventType_UPDATED, &BlogItem{})
+	}
+	if err := e.watch(ctx, &blogpb.WatchBlogsReq{ResumeToken: e.feed.resumeToken(1)}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Got %v resuming from a dropped event, want FailedPrecondition", err)
+	}
+}
+
+func TestWatchFilteredProgress(t *testing.T) {
+	e := newTestEnv(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// From a resume token, so the changes below can't happen before the watch started.
+	token := e.feed.resumeToken(e.feed.head() - 1)
+	stream, err := e.blogs.WatchBlogs(ctx, &blogpb.WatchBlogsReq{Tags: []string{"go"}, ResumeToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A change the filter leaves out still moves the resume token past it.
+	if _, err := e.blogs.CreateBlog(ctx, &blogpb.CreateBlogReq{Blog: e.newBlog("Untagged")}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetType() != blogpb.BlogEventType_BLOG_EVENT_TYPE_UNSPECIFIED || res.GetBlog() != nil {
+		t.Errorf("Got %v of %s, want only a resume token", res.GetType(), res.GetBlog().GetTitle())
+	}
+	if want := e.feed.resumeToken(e.feed.head() - 1); res.GetResumeToken() != want {
+		t.Errorf("Got resume token %q, want %q of the skipped change", res.GetResumeToken(), want)
+	}
+}
+
+func TestWatchPurge(t *testing.T) {
+	e := newTestEnv(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	token := e.feed.resumeToken(e.feed.head() - 1)
+	stream, err := e.blogs.WatchBlogs(ctx, &blogpb.WatchBlogsReq{ResumeToken: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDeleted := func(id string) {
+		t.Helper()
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.GetType() != blogpb.BlogEventType_DELETED || res.GetBlog().GetId() != id {
+			t.Errorf("Got %v of %s, want the blog %s deleted", res.GetType(), res.GetBlog().GetId(), id)
+		}
+	}
+
+	// Purging sends a second DELETED, whether once the retention is over or through PurgeBlog.
+	// Below zero, the retention is over even for a blog trashed within the same millisecond.
+	purger := &trashPurger{store: e.store, feed: e.feed, retention: -time.Second}
+	if n, err := purger.purgeExpired(ctx); err != nil || n != 1 {
+		t.Fatalf("Got %d purged, %v, want the trashed blog", n, err)
+	}
+	expectDeleted(e.trashedID)
+	if _, err := e.blogs.DeleteBlog(ctx, &blogpb.DeleteBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	expectDeleted(e.blogID)
+	if _, err := e.blogs.PurgeBlog(ctx, &blogpb.PurgeBlogReq{Id: e.blogID}); err != nil {
+		t.Fatal(err)
+	}
+	expectDeleted(e.blogID)
+}